@@ -0,0 +1,233 @@
+// Package access decides what principals may do to each site, by the role they're given in it.
+// Roles are kept as membership items in the table, one per user & site:
+//
+//	viewer  may only read the site & its pages
+//	author  may also create pages, and change & delete the pages they're the author of
+//	editor  may change & delete any page, and change the site, publishing it included
+//	owner   may also delete & restore the site, and manage its members
+//
+// API keys are limited to sites & operations already, so they aren't members: keys allowing
+// writes or deletes act as editors of their sites, and read-only keys as viewers.
+//
+// Every site belongs to a tenant, the organization that created it, and principals have no role
+// in the sites of other tenants whatever their memberships, so those sites are as good as
+// missing to them. Sites created before tenants, and principals without one, are in the default
+// tenant "".
+package access
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/feckmore/go-lambda-dynamo/auth"
+)
+
+// Role is what a member may do in a site
+type Role string
+
+// Roles, from the least allowed to the most
+const (
+	Viewer Role = "viewer"
+	Author Role = "author"
+	Editor Role = "editor"
+	Owner  Role = "owner"
+)
+
+// Roles are the roles members may have
+var Roles = []Role{Viewer, Author, Editor, Owner}
+
+// rank orders the roles, so that each allows what those below it do. Principals that aren't
+// members have no role, ranked below them all.
+var rank = map[Role]int{Viewer: 1, Author: 2, Editor: 3, Owner: 4}
+
+// Valid reports whether the role is one of Roles
+func (r Role) Valid() bool {
+	return rank[r] > 0
+}
+
+// AtLeast reports whether the role allows all that the other role does
+func (r Role) AtLeast(other Role) bool {
+	return r.Valid() && rank[r] >= rank[other]
+}
+
+// Member is a user's role in a site. Site is the site's path, and Subject the user's principal.
+type Member struct {
+	Site      string    `json:"site"`
+	Subject   string    `json:"subject"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store is where members are kept, along with the tenants of the sites
+type Store interface {
+	Member(site, subject string) (*Member, error)
+	Memberships(subject string) ([]Member, error)
+	// Tenant returns the tenant of the site with the path, and whether there's a site there
+	Tenant(site string) (string, bool, error)
+	// Sites returns the paths of the tenant's sites
+	Sites(tenant string) ([]string, error)
+}
+
+// Policy returns principals' roles in sites. Admins are subjects that own every site of their
+// tenant, such as the operators who set up a stage.
+type Policy struct {
+	Store  Store
+	Admins []string
+}
+
+// FromEnv returns the policy of the members in the store, with the admins of the environment's
+// comma separated ADMINS
+func FromEnv(store Store) *Policy {
+	policy := &Policy{Store: store}
+	for _, admin := range strings.Split(os.Getenv("ADMINS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" {
+			policy.Admins = append(policy.Admins, admin)
+		}
+	}
+
+	return policy
+}
+
+// Admin reports whether the principal is an admin, and so owns every site of its tenant
+func (p *Policy) Admin(principal *auth.Principal) bool {
+	if principal == nil || principal.Method == "apikey" {
+		return false
+	}
+	for _, admin := range p.Admins {
+		if principal.Subject == admin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InTenant reports whether there's a site with the path in the principal's tenant
+func (p *Policy) InTenant(principal *auth.Principal, site string) (bool, error) {
+	if principal == nil {
+		return false, nil
+	}
+
+	tenant, found, err := p.Store.Tenant(strings.ToLower(site))
+	if err != nil || !found {
+		return false, err
+	}
+
+	return tenant == principal.Tenant, nil
+}
+
+// Role returns the principal's role in the site with the path, or "" when it has none
+func (p *Policy) Role(principal *auth.Principal, site string) (Role, error) {
+	site = strings.ToLower(site)
+	inTenant, err := p.InTenant(principal, site)
+	if err != nil || !inTenant {
+		return "", err
+	}
+
+	switch {
+	case p.Admin(principal):
+		return Owner, nil
+	case principal.Method == "apikey":
+		if !contains(principal.Sites, site) {
+			return "", nil
+		}
+		if contains(principal.Scopes, "write") || contains(principal.Scopes, "delete") {
+			return Editor, nil
+		}
+		return Viewer, nil
+	}
+
+	member, err := p.Store.Member(site, principal.Subject)
+	if err != nil || member == nil {
+		return "", err
+	}
+
+	return member.Role, nil
+}
+
+// Sites returns the paths of the sites of its tenant the principal has a role in
+func (p *Policy) Sites(principal *auth.Principal) ([]string, error) {
+	if principal == nil {
+		return nil, nil
+	}
+
+	tenantSites, err := p.Store.Sites(principal.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.Admin(principal):
+		return tenantSites, nil
+	case principal.Method == "apikey":
+		return intersect(tenantSites, principal.Sites), nil
+	}
+
+	members, err := p.Store.Memberships(principal.Subject)
+	if err != nil {
+		return nil, err
+	}
+	var memberSites []string
+	for _, member := range members {
+		memberSites = append(memberSites, member.Site)
+	}
+
+	return intersect(tenantSites, memberSites), nil
+}
+
+// SiteOf returns the path of the site of the site or page with the path
+func SiteOf(path string) string {
+	return strings.ToLower(strings.SplitN(path, "/", 2)[0])
+}
+
+// CanEditPage reports whether the principal, with the role, may change or delete the page with
+// the author: editors may change any page, but authors only their own
+func CanEditPage(role Role, principal *auth.Principal, author *string) bool {
+	if role.AtLeast(Editor) {
+		return true
+	}
+
+	return role == Author && principal != nil && author != nil && *author == principal.Subject
+}
+
+// LastOwner reports whether the subject is the only owner among a site's members, who can't be
+// removed or demoted without leaving no one to manage the site
+func LastOwner(members []Member, subject string) bool {
+	last := false
+	for _, member := range members {
+		if member.Role != Owner {
+			continue
+		}
+		if member.Subject != subject {
+			return false
+		}
+		last = true
+	}
+
+	return last
+}
+
+// intersect returns the values that are also among the others
+func intersect(values, others []string) []string {
+	var result []string
+	for _, value := range values {
+		if contains(others, value) {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
+
+// contains reports whether the values include the value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
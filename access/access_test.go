@@ -0,0 +1,177 @@
+package access
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/feckmore/go-lambda-dynamo/auth"
+)
+
+// memory is a Store of members, and of the tenants of sites, in memory
+type memory struct {
+	members []Member
+	tenants map[string]string
+}
+
+func (m *memory) Member(site, subject string) (*Member, error) {
+	for i := range m.members {
+		if m.members[i].Site == site && m.members[i].Subject == subject {
+			return &m.members[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memory) Memberships(subject string) ([]Member, error) {
+	var members []Member
+	for _, member := range m.members {
+		if member.Subject == subject {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+func (m *memory) Tenant(site string) (string, bool, error) {
+	tenant, found := m.tenants[site]
+	return tenant, found, nil
+}
+
+func (m *memory) Sites(tenant string) ([]string, error) {
+	var sites []string
+	for site, t := range m.tenants {
+		if t == tenant {
+			sites = append(sites, site)
+		}
+	}
+	sort.Strings(sites)
+	return sites, nil
+}
+
+func TestRole(t *testing.T) {
+	policy := &Policy{
+		Store: &memory{
+			members: []Member{
+				{Site: "acme", Subject: "user-1", Role: Owner},
+				{Site: "acme", Subject: "user-2", Role: Author},
+				{Site: "globex", Subject: "user-2", Role: Viewer},
+				{Site: "umbrella", Subject: "user-1", Role: Owner},
+			},
+			tenants: map[string]string{"acme": "", "globex": "", "initech": "", "umbrella": "other-corp"},
+		},
+		Admins: []string{"admin"},
+	}
+
+	var testCases = []struct {
+		name      string
+		principal *auth.Principal
+		site      string
+		role      Role
+	}{
+		{"owner", &auth.Principal{Subject: "user-1", Method: "jwt"}, "acme", Owner},
+		{"path case", &auth.Principal{Subject: "user-1", Method: "jwt"}, "Acme", Owner},
+		{"author", &auth.Principal{Subject: "user-2", Method: "jwt"}, "acme", Author},
+		{"viewer", &auth.Principal{Subject: "user-2", Method: "jwt"}, "globex", Viewer},
+		{"not a member", &auth.Principal{Subject: "user-1", Method: "jwt"}, "globex", ""},
+		{"anonymous", nil, "acme", ""},
+		{"admin", &auth.Principal{Subject: "admin", Method: "jwt"}, "initech", Owner},
+		{"no site", &auth.Principal{Subject: "admin", Method: "jwt"}, "hooli", ""},
+		{"key named like an admin", &auth.Principal{Subject: "admin", Method: "apikey", Sites: []string{"acme"}, Scopes: []string{"read"}}, "acme", Viewer},
+		{"writing key", &auth.Principal{Subject: "apikey:1", Method: "apikey", Sites: []string{"acme"}, Scopes: []string{"read", "write"}}, "acme", Editor},
+		{"key for another site", &auth.Principal{Subject: "apikey:1", Method: "apikey", Sites: []string{"acme"}, Scopes: []string{"read"}}, "globex", ""},
+		{"member of another tenant's site", &auth.Principal{Subject: "user-1", Method: "jwt"}, "umbrella", ""},
+		{"admin of another tenant", &auth.Principal{Subject: "admin", Method: "jwt", Tenant: "other-corp"}, "acme", ""},
+		{"admin of the site's tenant", &auth.Principal{Subject: "admin", Method: "jwt", Tenant: "other-corp"}, "umbrella", Owner},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			role, err := policy.Role(tc.principal, tc.site)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if role != tc.role {
+				t.Errorf("Role: got %q; wanted %q", role, tc.role)
+			}
+		})
+	}
+}
+
+func TestSites(t *testing.T) {
+	policy := &Policy{
+		Store: &memory{
+			members: []Member{
+				{Site: "acme", Subject: "user-1", Role: Owner},
+				{Site: "globex", Subject: "user-1", Role: Viewer},
+				{Site: "initech", Subject: "user-2", Role: Editor},
+				{Site: "umbrella", Subject: "user-1", Role: Owner},
+			},
+			tenants: map[string]string{"acme": "", "globex": "", "initech": "", "umbrella": "other-corp"},
+		},
+		Admins: []string{"admin"},
+	}
+
+	var testCases = []struct {
+		name      string
+		principal *auth.Principal
+		sites     []string
+	}{
+		{"member", &auth.Principal{Subject: "user-1", Method: "jwt"}, []string{"acme", "globex"}},
+		{"member in another tenant", &auth.Principal{Subject: "user-1", Method: "jwt", Tenant: "other-corp"}, []string{"umbrella"}},
+		{"admin", &auth.Principal{Subject: "admin", Method: "jwt"}, []string{"acme", "globex", "initech"}},
+		{"key", &auth.Principal{Subject: "apikey:1", Method: "apikey", Sites: []string{"globex", "umbrella"}}, []string{"globex"}},
+		{"anonymous", nil, nil},
+	}
+
+	for _, tc := range testCases {
+		sites, err := policy.Sites(tc.principal)
+		if err != nil || !reflect.DeepEqual(sites, tc.sites) {
+			t.Errorf("Sites of %s: got %v, %v; wanted %v", tc.name, sites, err, tc.sites)
+		}
+	}
+}
+
+func TestCanEditPage(t *testing.T) {
+	author := &auth.Principal{Subject: "user-2", Method: "jwt"}
+	own, other := "user-2", "user-3"
+
+	var testCases = []struct {
+		name   string
+		role   Role
+		author *string
+		ok     bool
+	}{
+		{"editor, other's page", Editor, &other, true},
+		{"owner, no author", Owner, nil, true},
+		{"author, own page", Author, &own, true},
+		{"author, other's page", Author, &other, false},
+		{"author, no author", Author, nil, false},
+		{"viewer, own page", Viewer, &own, false},
+		{"no role", "", &own, false},
+	}
+
+	for _, tc := range testCases {
+		if ok := CanEditPage(tc.role, author, tc.author); ok != tc.ok {
+			t.Errorf("CanEditPage %s: got %v; wanted %v", tc.name, ok, tc.ok)
+		}
+	}
+}
+
+func TestLastOwner(t *testing.T) {
+	members := []Member{
+		{Subject: "user-1", Role: Owner},
+		{Subject: "user-2", Role: Editor},
+	}
+	if !LastOwner(members, "user-1") {
+		t.Error("LastOwner: got false for the only owner")
+	}
+	if LastOwner(members, "user-2") {
+		t.Error("LastOwner: got true for an editor")
+	}
+
+	members = append(members, Member{Subject: "user-3", Role: Owner})
+	if LastOwner(members, "user-1") {
+		t.Error("LastOwner: got true with another owner")
+	}
+}
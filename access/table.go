@@ -0,0 +1,326 @@
+package access
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// Table is a Store in the DynamoDB table alongside the sites, whose items have their tenant as
+// `tenant`, left out for the default tenant. All of a site's members share an id, and their
+// version is their subject, so a site's members are one query on the id. Members are of type
+// "member" with a path of their subject's, prefixed so it can't be taken for a site's, which
+// lists a user's sites with one query of the type-path index.
+type Table struct {
+	DB   dynamodbiface.DynamoDBAPI
+	Name string
+}
+
+// item is how a member is stored in the table
+type item struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+	Path    string `json:"path"`
+	Member  Member `json:"member"`
+	// DeletedAt is set while the member's site is in the trash
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Item returns the member as it's stored in the table, for writing it in a unit of work
+func Item(m *Member) interface{} {
+	return item{
+		ID:      MembersID(m.Site),
+		Version: m.Subject,
+		Type:    "member",
+		Path:    subjectPath(m.Subject),
+		Member:  *m,
+	}
+}
+
+// reservation is how a site's path is held in the table, so no two sites are created at one path
+type reservation struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+	Path    string `json:"path"`
+	SiteID  string `json:"siteId"`
+}
+
+// Reservation returns the item holding the site path for the site, for creating it in the unit
+// of work that creates the site. Creating it fails when another site already holds the path.
+func Reservation(sitePath, siteID string) interface{} {
+	return reservation{
+		ID:      ReservationID(sitePath),
+		Version: "path",
+		Type:    "sitePath",
+		Path:    ReservationID(sitePath),
+		SiteID:  siteID,
+	}
+}
+
+// ReservationID returns the id of the item holding the site path
+func ReservationID(sitePath string) string {
+	return "path#" + strings.ToLower(sitePath)
+}
+
+// MembersID returns the id shared by the site's members
+func MembersID(site string) string {
+	return "member#" + strings.ToLower(site)
+}
+
+// subjectPath returns the path of the subject's memberships
+func subjectPath(subject string) string {
+	return "member#" + subject
+}
+
+// TenantCondition is the condition of the items, such as sites, of the tenant. Items of the
+// default tenant have no tenant attribute.
+func TenantCondition(tenant string) expression.ConditionBuilder {
+	if tenant == "" {
+		return expression.AttributeNotExists(expression.Name("tenant"))
+	}
+
+	return expression.Name("tenant").Equal(expression.Value(tenant))
+}
+
+// site is the part of a site item that says which tenant it's in
+type site struct {
+	Path      string     `json:"path"`
+	Tenant    string     `json:"tenant"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Tenant returns the tenant of the site with the path, and whether there's a site there. Sites in
+// the trash are left out, so no one has a role in them until they're restored.
+func (t *Table) Tenant(path string) (string, bool, error) {
+	key := expression.Key("type").Equal(expression.Value("site")).And(expression.Key("path").Equal(expression.Value(strings.ToLower(path))))
+	projection := expression.NamesList(expression.Name("path"), expression.Name("tenant"), expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithProjection(projection).Build()
+	if err != nil {
+		return "", false, err
+	}
+
+	// the site's versions share its path, and some of them may be in the trash without the site
+	var live *site
+	var unmarshalErr error
+	err = t.DB.QueryPages(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(t.Name),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var sites []site
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &sites); unmarshalErr != nil {
+			return false
+		}
+		for i := range sites {
+			if sites[i].DeletedAt == nil {
+				live = &sites[i]
+				return false
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil || live == nil {
+		return "", false, err
+	}
+
+	return live.Tenant, true, nil
+}
+
+// Sites returns the paths of the tenant's sites that aren't in the trash
+func (t *Table) Sites(tenant string) ([]string, error) {
+	var paths []string
+
+	key := expression.Key("type").Equal(expression.Value("site"))
+	projection := expression.NamesList(expression.Name("path"), expression.Name("tenant"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(TenantCondition(tenant).And(expression.AttributeNotExists(expression.Name("deletedAt")))).WithProjection(projection).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	var unmarshalErr error
+	err = t.DB.QueryPages(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(t.Name),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var sites []site
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &sites); unmarshalErr != nil {
+			return false
+		}
+		for _, s := range sites {
+			paths = append(paths, s.Path)
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+
+	return paths, err
+}
+
+// Member returns the subject's membership of the site, or nil when it's not a member or the site
+// is in the trash
+func (t *Table) Member(site, subject string) (*Member, error) {
+	stored, err := t.member(site, subject)
+	if err != nil || stored == nil || stored.DeletedAt != nil {
+		return nil, err
+	}
+
+	return &stored.Member, nil
+}
+
+// TrashedMember returns the subject's membership of the site in the trash, or nil when it's not
+// a member or the site isn't in the trash, for telling who may take the site back out
+func (t *Table) TrashedMember(site, subject string) (*Member, error) {
+	stored, err := t.member(site, subject)
+	if err != nil || stored == nil || stored.DeletedAt == nil {
+		return nil, err
+	}
+
+	return &stored.Member, nil
+}
+
+// member returns the stored membership of the subject in the site, or nil when there's none
+func (t *Table) member(site, subject string) (*item, error) {
+	result, err := t.DB.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id":      {S: aws.String(MembersID(site))},
+			"version": {S: aws.String(subject)},
+		},
+		TableName: aws.String(t.Name),
+	})
+	if err != nil || result.Item == nil {
+		return nil, err
+	}
+
+	var stored item
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &stored); err != nil {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+// Members returns the site's members, those of a site in the trash included
+func (t *Table) Members(site string) ([]Member, error) {
+	key := expression.Key("id").Equal(expression.Value(MembersID(site)))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(t.Name),
+	}, true)
+}
+
+// Memberships returns the subject's memberships of every site that isn't in the trash
+func (t *Table) Memberships(subject string) ([]Member, error) {
+	key := expression.Key("type").Equal(expression.Value("member")).And(expression.Key("path").Equal(expression.Value(subjectPath(subject))))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(t.Name),
+	}, false)
+}
+
+// TrashedMemberships returns the subject's memberships of the sites in the trash, for listing
+// the sites it may see there
+func (t *Table) TrashedMemberships(subject string) ([]Member, error) {
+	key := expression.Key("type").Equal(expression.Value("member")).And(expression.Key("path").Equal(expression.Value(subjectPath(subject))))
+	filter := expression.AttributeExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(t.Name),
+	}, true)
+}
+
+// query returns the members of every page of the query's results, leaving out those of sites in
+// the trash unless trashed is set
+func (t *Table) query(input *dynamodb.QueryInput, trashed bool) ([]Member, error) {
+	var members []Member
+
+	var unmarshalErr error
+	err := t.DB.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var items []item
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items); unmarshalErr != nil {
+			return false
+		}
+		for _, i := range items {
+			if i.DeletedAt == nil || trashed {
+				members = append(members, i.Member)
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+
+	return members, err
+}
+
+// Put adds the member to its site, or changes its role when it's a member already
+func (t *Table) Put(m *Member) error {
+	av, err := dynamodbattribute.MarshalMap(Item(m))
+	if err != nil {
+		return err
+	}
+
+	_, err = t.DB.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(t.Name),
+	})
+
+	return err
+}
+
+// Remove removes the subject from the site's members
+func (t *Table) Remove(site, subject string) error {
+	_, err := t.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id":      {S: aws.String(MembersID(site))},
+			"version": {S: aws.String(subject)},
+		},
+		TableName: aws.String(t.Name),
+	})
+
+	return err
+}
@@ -0,0 +1,221 @@
+// Package apikeys authenticates machine clients, such as build servers & importers, by the API
+// keys they send in the X-API-Key header. Keys are limited to some sites and some operations on
+// them, and only a hash of each is kept, so a key is shown once when it's created and can't be
+// recovered after.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/google/uuid"
+)
+
+// Header is the request header API keys are sent in
+const Header = "X-API-Key"
+
+// prefix starts every key, so they're recognizable when leaked, e.g. by secret scanners
+const prefix = "gld_"
+
+// touchInterval is how stale a key's last use may get before it's recorded again, which saves
+// a write for every request of a busy client
+const touchInterval = time.Minute
+
+// Operations keys may be allowed, by the HTTP methods of the requests they make
+const (
+	Read   = "read"
+	Write  = "write"
+	Delete = "delete"
+)
+
+// Operations are the operations keys may be allowed
+var Operations = []string{Read, Write, Delete}
+
+// Key is an API key, as listed. The key itself is only in Token when the key is created.
+type Key struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Tenant     string     `json:"tenant,omitempty"`
+	Sites      []string   `json:"sites" dynamodbav:"sites,stringset"`
+	Operations []string   `json:"operations" dynamodbav:"operations,stringset"`
+	Token      string     `json:"token,omitempty" dynamodbav:"-"`
+	Hash       string     `json:"-" dynamodbav:"hash"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Store is where keys are kept
+type Store interface {
+	Key(id string) (*Key, error)
+	Touch(id string, at time.Time) error
+}
+
+// New returns a new key for the owner, with its token & the token's hash
+func New(owner, name string, sites, operations []string) (*Key, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		ID:         uuid.New().String(),
+		Name:       name,
+		Owner:      owner,
+		Sites:      sites,
+		Operations: operations,
+		CreatedAt:  time.Now(),
+	}
+	key.Token = prefix + key.ID + "_" + hex.EncodeToString(secret)
+	key.Hash = Hash(key.Token)
+
+	return key, nil
+}
+
+// Hash returns the hex SHA-256 hash of the token. Tokens are long & random, so unlike passwords
+// they don't need a slow hash to resist guessing.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Validate returns what's wrong with the key, or "" when it's valid. The key's site paths are
+// lowercased, as the paths of sites are, so they match the paths requests are made for.
+func Validate(key *Key) string {
+	if key.Name == "" {
+		return "API key needs a name"
+	}
+	if len(key.Sites) == 0 {
+		return "API key needs at least one site"
+	}
+	for i, site := range key.Sites {
+		if site == "" || strings.ContainsAny(site, "/#") {
+			return "API key sites must be site paths"
+		}
+		key.Sites[i] = strings.ToLower(site)
+	}
+	if len(key.Operations) == 0 {
+		return "API key needs at least one operation of " + strings.Join(Operations, ", ")
+	}
+	for _, operation := range key.Operations {
+		if operation != Read && operation != Write && operation != Delete {
+			return "API key operations must be some of " + strings.Join(Operations, ", ")
+		}
+	}
+
+	return ""
+}
+
+// Operation returns the operation of a request with the HTTP method
+func Operation(method string) string {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return Read
+	case http.MethodDelete:
+		return Delete
+	default:
+		return Write
+	}
+}
+
+// id returns the key id of the token, or "" when it isn't a key
+func id(token string) string {
+	if !strings.HasPrefix(token, prefix) {
+		return ""
+	}
+	parts := strings.SplitN(strings.TrimPrefix(token, prefix), "_", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return ""
+	}
+	if _, err := uuid.Parse(parts[0]); err != nil {
+		return ""
+	}
+
+	return parts[0]
+}
+
+// Authenticator authenticates requests with an API key in their X-API-Key header. The key must
+// be for the site whose path is the request's `siteid` path parameter, and allow the operation
+// of its method. So requests to endpoints that aren't for one site are refused, as are those to
+// the endpoints taking a site's id rather than its path, which change the site itself.
+type Authenticator struct {
+	Keys Store
+}
+
+// NewAuthenticator returns an authenticator of the keys in the table
+func NewAuthenticator(db dynamodbiface.DynamoDBAPI, table string) *Authenticator {
+	return &Authenticator{Keys: &Table{DB: db, Name: table}}
+}
+
+// Authenticate returns the principal of the request's key, whose subject is "apikey:" and the
+// key's id
+func (a *Authenticator) Authenticate(ctx context.Context, request events.APIGatewayProxyRequest) (*auth.Principal, error) {
+	token := strings.TrimSpace(auth.Header(request, Header))
+	if token == "" {
+		return nil, auth.ErrNoCredentials
+	}
+
+	keyID := id(token)
+	if keyID == "" {
+		return nil, &auth.CredentialsError{Reason: "API key is malformed"}
+	}
+	key, err := a.Keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(Hash(token))) != 1 {
+		return nil, &auth.CredentialsError{Reason: "API key isn't recognized"}
+	}
+	if key.RevokedAt != nil {
+		return nil, &auth.CredentialsError{Reason: "API key has been revoked"}
+	}
+
+	// the request is counted as a use even when it's out of the key's scope
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > touchInterval {
+		if err := a.Keys.Touch(key.ID, now); err != nil {
+			log.Println("Error recording API key use:", err)
+		}
+	}
+
+	site := strings.ToLower(request.PathParameters["siteid"])
+	if site == "" {
+		return nil, &auth.ScopeError{Reason: "API keys can only be used for a site's endpoints"}
+	}
+	if !contains(key.Sites, site) {
+		return nil, &auth.ScopeError{Reason: "API key isn't for this site"}
+	}
+	if operation := Operation(request.HTTPMethod); !contains(key.Operations, operation) {
+		return nil, &auth.ScopeError{Reason: "API key doesn't allow " + operation + " requests"}
+	}
+
+	return &auth.Principal{
+		Subject: "apikey:" + key.ID,
+		Method:  "apikey",
+		Scopes:  key.Operations,
+		Sites:   key.Sites,
+		Tenant:  key.Tenant,
+	}, nil
+}
+
+// contains reports whether the values include the value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
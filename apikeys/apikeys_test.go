@@ -0,0 +1,123 @@
+package apikeys
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+)
+
+// memory is a Store of keys in memory, recording when they're touched
+type memory struct {
+	keys    map[string]*Key
+	touched []string
+}
+
+func (m *memory) Key(id string) (*Key, error) {
+	return m.keys[id], nil
+}
+
+func (m *memory) Touch(id string, at time.Time) error {
+	m.touched = append(m.touched, id)
+	m.keys[id].LastUsedAt = &at
+	return nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	key, err := New("user-1", "importer", []string{"acme"}, []string{Read, Write})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Tenant = "acme-corp"
+	revoked, _ := New("user-1", "old importer", []string{"acme"}, []string{Read})
+	revokedAt := time.Now()
+	revoked.RevokedAt = &revokedAt
+
+	// the token with its last hex digit changed
+	wrong := key.Token[:len(key.Token)-1] + "0"
+	if wrong == key.Token {
+		wrong = key.Token[:len(key.Token)-1] + "1"
+	}
+
+	store := &memory{keys: map[string]*Key{key.ID: key, revoked.ID: revoked}}
+	authenticator := &Authenticator{Keys: store}
+
+	var testCases = []struct {
+		name   string
+		token  string
+		method string
+		site   string
+		err    string
+	}{
+		{"read", key.Token, "GET", "acme", ""},
+		{"write", key.Token, "POST", "Acme", ""},
+		{"delete", key.Token, "DELETE", "acme", "*auth.ScopeError"},
+		{"other site", key.Token, "GET", "globex", "*auth.ScopeError"},
+		{"no site", key.Token, "GET", "", "*auth.ScopeError"},
+		{"revoked", revoked.Token, "GET", "acme", "*auth.CredentialsError"},
+		{"wrong secret", wrong, "GET", "acme", "*auth.CredentialsError"},
+		{"unknown", prefix + "1b4e28ba-2fa1-11d2-883f-0016d3cca427_00", "GET", "acme", "*auth.CredentialsError"},
+		{"malformed", "not-a-key", "GET", "acme", "*auth.CredentialsError"},
+		{"missing", "", "GET", "acme", "no credentials"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := events.APIGatewayProxyRequest{
+				HTTPMethod:     tc.method,
+				Headers:        map[string]string{"x-api-key": tc.token},
+				PathParameters: map[string]string{"siteid": tc.site},
+			}
+			principal, err := authenticator.Authenticate(context.Background(), request)
+
+			var got string
+			switch err.(type) {
+			case nil:
+			case *auth.ScopeError:
+				got = "*auth.ScopeError"
+			case *auth.CredentialsError:
+				got = "*auth.CredentialsError"
+			default:
+				got = err.Error()
+			}
+			if got != tc.err {
+				t.Fatalf("Authenticate: got error %v; wanted %s", err, tc.err)
+			}
+			if err == nil && (principal.Subject != "apikey:"+key.ID || principal.Method != "apikey" || principal.Tenant != "acme-corp") {
+				t.Errorf("Authenticate: got principal %+v", principal)
+			}
+		})
+	}
+
+	// uses are only recorded once a minute
+	if len(store.touched) != 1 || store.touched[0] != key.ID {
+		t.Errorf("Touch: got %v; wanted the key touched once", store.touched)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var testCases = []struct {
+		key Key
+		ok  bool
+	}{
+		{Key{Name: "importer", Sites: []string{"acme"}, Operations: []string{Read}}, true},
+		{Key{Name: "", Sites: []string{"acme"}, Operations: []string{Read}}, false},
+		{Key{Name: "importer", Operations: []string{Read}}, false},
+		{Key{Name: "importer", Sites: []string{"acme/about"}, Operations: []string{Read}}, false},
+		{Key{Name: "importer", Sites: []string{"acme"}}, false},
+		{Key{Name: "importer", Sites: []string{"acme"}, Operations: []string{"publish"}}, false},
+	}
+
+	for _, tc := range testCases {
+		if detail := Validate(&tc.key); (detail == "") != tc.ok {
+			t.Errorf("Validate %+v: got %q; wanted ok %v", tc.key, detail, tc.ok)
+		}
+	}
+
+	key := Key{Name: "importer", Sites: []string{"Acme", "globex"}, Operations: []string{Read}}
+	if detail := Validate(&key); detail != "" || key.Sites[0] != "acme" || key.Sites[1] != "globex" {
+		t.Errorf("Validate: got %q & sites %v; wanted the sites' paths lowercased", detail, key.Sites)
+	}
+}
@@ -0,0 +1,152 @@
+package apikeys
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// version is the version of every key item, since keys only have the one
+const version = "apikey"
+
+// Table is a Store in the DynamoDB table alongside the sites. Keys are items of type "apikey"
+// whose path is their owner's, prefixed so it can't be taken for a site's, which lists an
+// owner's keys with one query of the type-path index. A key for a single site goes in the trash
+// along with the site, where it's refused.
+type Table struct {
+	DB   dynamodbiface.DynamoDBAPI
+	Name string
+}
+
+// item is how a key is stored in the table
+type item struct {
+	ID        string     `json:"id"`
+	Version   string     `json:"version"`
+	Type      string     `json:"type"`
+	Path      string     `json:"path"`
+	Key       Key        `json:"key"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// ownerPath returns the path of the owner's keys
+func ownerPath(owner string) string {
+	return "apikey#" + owner
+}
+
+// New adds the key to the table
+func (t *Table) New(key *Key) error {
+	av, err := dynamodbattribute.MarshalMap(item{
+		ID:      key.ID,
+		Version: version,
+		Type:    "apikey",
+		Path:    ownerPath(key.Owner),
+		Key:     *key,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = t.DB.PutItem(&dynamodb.PutItemInput{
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+		TableName:           aws.String(t.Name),
+	})
+
+	return err
+}
+
+// Key returns the key with the id, or nil when there's none or it's in the trash
+func (t *Table) Key(id string) (*Key, error) {
+	result, err := t.DB.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id":      {S: aws.String(id)},
+			"version": {S: aws.String(version)},
+		},
+		TableName: aws.String(t.Name),
+	})
+	if err != nil || result.Item == nil {
+		return nil, err
+	}
+
+	var stored item
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Type != "apikey" || stored.DeletedAt != nil {
+		return nil, nil
+	}
+
+	return &stored.Key, nil
+}
+
+// Keys returns the owner's keys, revoked ones included
+func (t *Table) Keys(owner string) ([]Key, error) {
+	var keys []Key
+
+	key := expression.Key("type").Equal(expression.Value("apikey")).And(expression.Key("path").Equal(expression.Value(ownerPath(owner))))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	var unmarshalErr error
+	err = t.DB.QueryPages(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(t.Name),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var items []item
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items); unmarshalErr != nil {
+			return false
+		}
+		for _, i := range items {
+			keys = append(keys, i.Key)
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+
+	return keys, err
+}
+
+// Touch records the key was used at the time
+func (t *Table) Touch(id string, at time.Time) error {
+	return t.set(id, "lastUsedAt", at)
+}
+
+// Revoke records the key was revoked at the time, after which it's refused
+func (t *Table) Revoke(id string, at time.Time) error {
+	return t.set(id, "revokedAt", at)
+}
+
+// set sets the field of the key with the id to the time
+func (t *Table) set(id, field string, at time.Time) error {
+	update := expression.Set(expression.Name("key."+field), expression.Value(at))
+	condition := expression.AttributeExists(expression.Name("id"))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = t.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id":      {S: aws.String(id)},
+			"version": {S: aws.String(version)},
+		},
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(t.Name),
+	})
+
+	return err
+}
@@ -0,0 +1,158 @@
+// Package audit keeps an append-only trail of the changes made to sites & pages: who made them,
+// from where, and what they changed. The site & page endpoints append an entry for every write.
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+)
+
+// Action is what a request did to its target
+type Action string
+
+// Actions recorded in the trail
+const (
+	Create    Action = "create"
+	Update    Action = "update"
+	Delete    Action = "delete"
+	Restore   Action = "restore"
+	Move      Action = "move"
+	Publish   Action = "publish"
+	Unpublish Action = "unpublish"
+)
+
+// Anonymous is the actor of requests that weren't authenticated
+const Anonymous = "anonymous"
+
+// maxValueSize is the largest JSON value kept in a change, which stops large page content
+// pushing entries past DynamoDB's item size limit. Larger values are left out, and the change
+// marked as truncated.
+const maxValueSize = 8 << 10
+
+// ignored are fields that change with every write, and so aren't recorded as changes
+var ignored = map[string]bool{"updatedAt": true}
+
+// Target is the site or page a request changed
+type Target struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	Path    string `json:"path"`
+}
+
+// Change is a field of the target whose value changed. Before is missing for fields the target
+// didn't have, and After for those it no longer has.
+type Change struct {
+	Field     string      `json:"field"`
+	Before    interface{} `json:"before,omitempty"`
+	After     interface{} `json:"after,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// Entry records one change to a site or page. Site is the path of the target's site.
+type Entry struct {
+	ID        string    `json:"id"`
+	Site      string    `json:"site"`
+	At        time.Time `json:"at"`
+	Action    Action    `json:"action"`
+	Actor     string    `json:"actor"`
+	Target    Target    `json:"target"`
+	Changes   []Change  `json:"changes,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	SourceIP  string    `json:"sourceIp,omitempty"`
+}
+
+// Log is where entries are appended
+type Log interface {
+	Append(entry *Entry) error
+}
+
+// NewEntry returns the entry of the action a request took on the target, with the changes from
+// the target's value before the request to its value after. Either value may be nil, for
+// targets that were created or removed.
+func NewEntry(request events.APIGatewayProxyRequestContext, action Action, target Target, before, after interface{}) *Entry {
+	return &Entry{
+		ID:        uuid.New().String(),
+		Site:      strings.ToLower(strings.SplitN(target.Path, "/", 2)[0]),
+		At:        time.Now(),
+		Action:    action,
+		Actor:     Actor(request),
+		Target:    target,
+		Changes:   Diff(Snapshot(before), Snapshot(after)),
+		RequestID: request.RequestID,
+		SourceIP:  request.Identity.SourceIP,
+	}
+}
+
+// Actor returns who made the request: the principal of its authorizer, or the caller identified
+// by IAM or Cognito, or Anonymous
+func Actor(request events.APIGatewayProxyRequestContext) string {
+	if principal, ok := request.Authorizer["principalId"].(string); ok && principal != "" {
+		return principal
+	}
+	for _, id := range []string{request.Identity.UserArn, request.Identity.User, request.Identity.CognitoIdentityID} {
+		if id != "" {
+			return id
+		}
+	}
+
+	return Anonymous
+}
+
+// Snapshot returns the JSON fields of the value, or nil for nil. Taking a snapshot keeps the
+// value as it is now, for values about to be changed in place.
+func Snapshot(value interface{}) map[string]interface{} {
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil() {
+		return nil
+	}
+
+	var fields map[string]interface{}
+	b, err := json.Marshal(value)
+	if err == nil {
+		err = json.Unmarshal(b, &fields)
+	}
+	if err != nil {
+		return map[string]interface{}{}
+	}
+
+	return fields
+}
+
+// Diff returns the changes from the before fields to the after fields, by field name
+func Diff(before, after map[string]interface{}) []Change {
+	var changes []Change
+
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+
+	for name := range names {
+		if ignored[name] || reflect.DeepEqual(before[name], after[name]) {
+			continue
+		}
+		change := Change{Field: name, Before: before[name], After: after[name]}
+		if size(change.Before) > maxValueSize || size(change.After) > maxValueSize {
+			change.Before, change.After, change.Truncated = nil, nil, true
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	return changes
+}
+
+// size returns the length of the value as JSON
+func size(value interface{}) int {
+	b, _ := json.Marshal(value)
+	return len(b)
+}
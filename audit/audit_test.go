@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// page is a page as the endpoints model it
+type page struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Name      *string   `json:"name,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Content   string    `json:"content,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+func TestDiff(t *testing.T) {
+	name, renamed := "Rockets", "Fast Rockets"
+	before := &page{ID: "rockets", Path: "acme/rockets", Name: &name, Tags: []string{"rockets"}, UpdatedAt: time.Unix(1554197400, 0)}
+	after := &page{ID: "rockets", Path: "acme/rockets", Name: &renamed, Content: strings.Repeat("x", maxValueSize), UpdatedAt: time.Unix(1554201000, 0)}
+
+	var testCases = []struct {
+		name          string
+		before, after interface{}
+		want          []Change
+	}{
+		{"created", nil, &page{ID: "rockets", Path: "acme/rockets"}, []Change{{Field: "id", After: "rockets"}, {Field: "path", After: "acme/rockets"}}},
+		{"updated", before, after, []Change{
+			{Field: "content", Truncated: true},
+			{Field: "name", Before: "Rockets", After: "Fast Rockets"},
+			{Field: "tags", Before: []interface{}{"rockets"}},
+		}},
+		{"unchanged", before, before, nil},
+		{"removed", map[string]interface{}{"id": "rockets"}, (*page)(nil), []Change{{Field: "id", Before: "rockets"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Diff(Snapshot(tc.before), Snapshot(tc.after))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Diff: got %+v; wanted %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewEntry(t *testing.T) {
+	request := events.APIGatewayProxyRequestContext{RequestID: "request"}
+	request.Identity.SourceIP = "203.0.113.7"
+	request.Identity.UserArn = "arn:aws:iam::123456789012:user/wile"
+
+	target := Target{Type: "page", ID: "rockets", Version: "1", Path: "Acme/rockets"}
+	entry := NewEntry(request, Delete, target, nil, nil)
+	if entry.Site != "acme" || entry.Actor != "arn:aws:iam::123456789012:user/wile" || entry.RequestID != "request" || entry.SourceIP != "203.0.113.7" {
+		t.Errorf("NewEntry: got %+v", entry)
+	}
+	if entry.ID == "" || entry.At.IsZero() || entry.Changes != nil {
+		t.Errorf("NewEntry: got id %q, time %v & changes %+v", entry.ID, entry.At, entry.Changes)
+	}
+
+	request.Authorizer = map[string]interface{}{"principalId": "wile@acme.example"}
+	if actor := Actor(request); actor != "wile@acme.example" {
+		t.Errorf("Actor: got %q; wanted the authorizer's principal", actor)
+	}
+	if actor := Actor(events.APIGatewayProxyRequestContext{}); actor != Anonymous {
+		t.Errorf("Actor: got %q; wanted %q", actor, Anonymous)
+	}
+}
@@ -0,0 +1,26 @@
+package audit
+
+import "sync"
+
+// Memory is a Log kept in memory, for tests
+type Memory struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// Append adds the entry to the log
+func (m *Memory) Append(entry *Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = append(m.entries, *entry)
+	return nil
+}
+
+// Entries returns the entries appended so far, in order
+func (m *Memory) Entries() []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Entry(nil), m.entries...)
+}
@@ -0,0 +1,124 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// sortableTime formats times so they sort in order as strings, unlike RFC 3339 with nanoseconds
+// which drops trailing zeros
+const sortableTime = "2006-01-02T15:04:05.000000000Z"
+
+// Table is a Log in the DynamoDB table alongside the sites. All of a site's entries share an id,
+// and their version starts with when they were made, so a site's trail over a time range is one
+// query on the id. Entries have no type or path, which keeps them out of the table's secondary
+// indexes, and no expiry, so the TTL never purges them.
+type Table struct {
+	DB   dynamodbiface.DynamoDBAPI
+	Name string
+}
+
+// Filter narrows a site's trail to the entries of a target or actor, made from From up to To.
+// Empty fields & zero times don't narrow it.
+type Filter struct {
+	TargetID string
+	Actor    string
+	From     time.Time
+	To       time.Time
+}
+
+// entry is how an entry is stored in the table
+type entry struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	Entry   Entry  `json:"entry"`
+}
+
+// trailID returns the id shared by the entries of the site's trail
+func trailID(site string) string {
+	return "audit#" + site
+}
+
+// Append adds the entry to its site's trail. Entries are only ever added, so the put fails
+// rather than overwrite an existing one.
+func (t *Table) Append(e *Entry) error {
+	item, err := dynamodbattribute.MarshalMap(entry{ID: trailID(e.Site), Version: e.At.UTC().Format(sortableTime) + "#" + e.ID, Entry: *e})
+	if err != nil {
+		return err
+	}
+
+	_, err = t.DB.PutItem(&dynamodb.PutItemInput{
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+		TableName:           aws.String(t.Name),
+	})
+
+	return err
+}
+
+// Query returns up to limit of the site's entries passing the filter, newest first
+func (t *Table) Query(site string, filter Filter, limit int) ([]Entry, error) {
+	entries := []Entry{}
+
+	key := expression.Key("id").Equal(expression.Value(trailID(site)))
+	switch {
+	case !filter.From.IsZero() && !filter.To.IsZero():
+		key = key.And(expression.Key("version").Between(expression.Value(filter.From.UTC().Format(sortableTime)), expression.Value(filter.To.UTC().Format(sortableTime))))
+	case !filter.From.IsZero():
+		key = key.And(expression.Key("version").GreaterThanEqual(expression.Value(filter.From.UTC().Format(sortableTime))))
+	case !filter.To.IsZero():
+		key = key.And(expression.Key("version").LessThan(expression.Value(filter.To.UTC().Format(sortableTime))))
+	}
+	builder := expression.NewBuilder().WithKeyCondition(key)
+
+	var conditions []expression.ConditionBuilder
+	if filter.TargetID != "" {
+		conditions = append(conditions, expression.Name("entry.target.id").Equal(expression.Value(filter.TargetID)))
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, expression.Name("entry.actor").Equal(expression.Value(filter.Actor)))
+	}
+	switch len(conditions) {
+	case 1:
+		builder = builder.WithFilter(conditions[0])
+	case 2:
+		builder = builder.WithFilter(conditions[0].And(conditions[1]))
+	}
+
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	// filters apply after each page is read, so pages are read until there are enough entries
+	var unmarshalErr error
+	err = t.DB.QueryPages(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ScanIndexForward:          aws.Bool(false),
+		TableName:                 aws.String(t.Name),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var items []entry
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items); unmarshalErr != nil {
+			return false
+		}
+		for _, item := range items {
+			if len(entries) < limit {
+				entries = append(entries, item.Entry)
+			}
+		}
+		return len(entries) < limit
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+
+	return entries, err
+}
@@ -0,0 +1,266 @@
+// Package auth authenticates API requests. Authenticators turn a request's credentials into the
+// Principal making it, and the middleware puts the principal in the handler's context, or
+// responds 401 itself when the credentials are missing or invalid.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ErrNoCredentials is returned by authenticators for requests without credentials they recognize
+var ErrNoCredentials = errors.New("no credentials")
+
+// CredentialsError is returned by authenticators for credentials that aren't valid. The reason
+// is reported to the client.
+type CredentialsError struct {
+	Reason string
+}
+
+func (e *CredentialsError) Error() string {
+	return "invalid credentials: " + e.Reason
+}
+
+// ScopeError is returned by authenticators for valid credentials that don't allow the request,
+// such as an API key for another site. The reason is reported to the client.
+type ScopeError struct {
+	Reason string
+}
+
+func (e *ScopeError) Error() string {
+	return "insufficient scope: " + e.Reason
+}
+
+// Principal is who a request is made by
+type Principal struct {
+	// Subject identifies the user or machine client, e.g. the `sub` claim of a token
+	Subject string `json:"subject"`
+	// Method is how the principal was authenticated, e.g. "jwt"
+	Method string `json:"method"`
+	// Scopes are what the principal's credentials allow, when they are limited
+	Scopes []string `json:"scopes,omitempty"`
+	// Sites are the paths of the only sites the principal's credentials are for, when they are
+	// limited
+	Sites []string `json:"sites,omitempty"`
+	// Tenant is the organization the principal belongs to, whose sites are the only ones it can
+	// reach. Principals without one are in the default tenant, "".
+	Tenant string `json:"tenant,omitempty"`
+	// Claims are the verified claims of a token
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Handler is an API Gateway proxy handler, as the endpoints' Handler functions are
+type Handler func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// Authenticator returns the principal making the request. It returns ErrNoCredentials when the
+// request has none it recognizes, a *CredentialsError when they aren't valid, and a *ScopeError
+// when they don't allow the request. Other errors are failures to check the credentials, such as
+// fetching keys.
+type Authenticator interface {
+	Authenticate(ctx context.Context, request events.APIGatewayProxyRequest) (*Principal, error)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context carrying the principal
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the principal carried by the context, or nil for anonymous requests
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(contextKey{}).(*Principal)
+	return principal
+}
+
+// Require wraps the handler so it's only called for authenticated requests, responding 401 to
+// the rest, or 503 when their credentials couldn't be checked. The principal is put in the
+// handler's context, and also in the request context's authorizer as `principalId`, where an
+// API Gateway authorizer would put it.
+func Require(authenticator Authenticator, next Handler) Handler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		principal, err := authenticator.Authenticate(ctx, request)
+		if err != nil {
+			return refuse(err), nil
+		}
+
+		return next(NewContext(ctx, principal), withPrincipal(request, principal))
+	}
+}
+
+// Optional wraps the handler so it's called for anonymous requests as well as authenticated
+// ones, for public endpoints. Invalid credentials are still refused with a 401.
+func Optional(authenticator Authenticator, next Handler) Handler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		principal, err := authenticator.Authenticate(ctx, request)
+		if err == ErrNoCredentials {
+			return next(ctx, request)
+		}
+		if err != nil {
+			return refuse(err), nil
+		}
+
+		return next(NewContext(ctx, principal), withPrincipal(request, principal))
+	}
+}
+
+// withPrincipal returns the request with the principal's subject in its authorizer
+func withPrincipal(request events.APIGatewayProxyRequest, principal *Principal) events.APIGatewayProxyRequest {
+	authorizer := map[string]interface{}{}
+	for name, value := range request.RequestContext.Authorizer {
+		authorizer[name] = value
+	}
+	authorizer["principalId"] = principal.Subject
+	request.RequestContext.Authorizer = authorizer
+
+	return request
+}
+
+// Chain returns an authenticator trying each of the authenticators in turn, until one finds
+// credentials it recognizes
+func Chain(authenticators ...Authenticator) Authenticator {
+	return chain(authenticators)
+}
+
+type chain []Authenticator
+
+// Authenticate returns the principal of the first authenticator finding credentials
+func (c chain) Authenticate(ctx context.Context, request events.APIGatewayProxyRequest) (*Principal, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(ctx, request)
+		if err != ErrNoCredentials {
+			return principal, err
+		}
+	}
+
+	return nil, ErrNoCredentials
+}
+
+// FromEnv returns the authenticator configured by the environment:
+//
+//	JWKS_SOURCE       the https URL or file path of the JSON Web Key Set verifying tokens
+//	JWT_ISSUER        the `iss` tokens must have, if set
+//	JWT_AUDIENCE      an `aud` tokens must have, if set
+//	JWT_TENANT_CLAIM  the claim naming the principal's tenant, `tenant` by default
+//
+// Without a key set every token is refused, so a misconfigured stage fails closed. Requests
+// without a bearer token are passed to the other authenticators, such as for API keys.
+func FromEnv(others ...Authenticator) Authenticator {
+	source := strings.TrimSpace(os.Getenv("JWKS_SOURCE"))
+	if source == "" {
+		log.Println("JWKS_SOURCE isn't set, so no token will be accepted")
+	}
+
+	var keys KeySource
+	if source == "" {
+		keys = NoKeySource()
+	} else if strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://") {
+		keys = NewURLKeySource(source, time.Hour)
+	} else {
+		keys = FileKeySource(strings.TrimPrefix(source, "file://"))
+	}
+
+	bearer := &Bearer{Verifier: &Verifier{
+		Keys:        keys,
+		Issuer:      strings.TrimSpace(os.Getenv("JWT_ISSUER")),
+		Audience:    strings.TrimSpace(os.Getenv("JWT_AUDIENCE")),
+		TenantClaim: strings.TrimSpace(os.Getenv("JWT_TENANT_CLAIM")),
+	}}
+
+	return Chain(append([]Authenticator{bearer}, others...)...)
+}
+
+// Bearer authenticates requests with a JWT in their Authorization header
+type Bearer struct {
+	Verifier *Verifier
+}
+
+// Authenticate verifies the request's bearer token
+func (b *Bearer) Authenticate(ctx context.Context, request events.APIGatewayProxyRequest) (*Principal, error) {
+	authorization := Header(request, "Authorization")
+	if authorization == "" {
+		return nil, ErrNoCredentials
+	}
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	return b.Verifier.Verify(strings.TrimSpace(parts[1]))
+}
+
+// Header returns the request's header with the name, whatever its case
+func Header(request events.APIGatewayProxyRequest, name string) string {
+	for key, value := range request.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	for key, values := range request.MultiValueHeaders {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+// problem is an RFC 7807 problem details body
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// refuse returns the problem response for the error: a 401 challenging for a bearer token when
+// the credentials are missing or invalid, a 403 when they don't allow the request, and a 503
+// otherwise
+func refuse(err error) events.APIGatewayProxyResponse {
+	status, detail, challenge := http.StatusUnauthorized, "Request needs a bearer token or API key", "Bearer"
+	if cerr, ok := err.(*CredentialsError); ok {
+		detail, challenge = capitalize(cerr.Reason), `Bearer error="invalid_token"`
+	} else if serr, ok := err.(*ScopeError); ok {
+		status, detail = http.StatusForbidden, capitalize(serr.Reason)
+	} else if err != ErrNoCredentials {
+		log.Println("Error authenticating request:", err)
+		status, detail = http.StatusServiceUnavailable, "Error authenticating request"
+	}
+
+	body, _ := json.Marshal(problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	response := events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+	if status == http.StatusUnauthorized {
+		response.Headers["WWW-Authenticate"] = challenge
+	}
+
+	return response
+}
+
+// capitalize returns the reason as the sentence of a problem's detail
+func capitalize(reason string) string {
+	if reason == "" {
+		return reason
+	}
+
+	return strings.ToUpper(reason[:1]) + reason[1:]
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// keys are the test signing keys, whose public halves are in the key set file written by keySetFile
+type keys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newKeys(t *testing.T) *keys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &keys{rsa: rsaKey, ec: ecKey}
+}
+
+// keySetFile writes the public keys to a JSON Web Key Set file, returning its path
+func (k *keys) keySetFile(t *testing.T) string {
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	set := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(k.rsa.N.Bytes()), "e": encode(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(k.ec.X.Bytes()), "y": encode(k.ec.Y.Bytes())},
+	}}
+	b, _ := json.Marshal(set)
+
+	file, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	return file.Name()
+}
+
+// sign returns a token of the claims signed with the algorithm's key
+func (k *keys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// r & s are each padded to 32 bytes
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	k := newKeys(t)
+	path := k.keySetFile(t)
+	defer os.Remove(path)
+
+	now := time.Date(2019, 4, 2, 9, 30, 0, 0, time.UTC)
+	verifier := &Verifier{
+		Keys:     FileKeySource(path),
+		Issuer:   "https://id.example.com/",
+		Audience: "sites-api",
+		now:      func() time.Time { return now },
+	}
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":    "user-1",
+			"iss":    "https://id.example.com/",
+			"aud":    []string{"sites-api", "other"},
+			"exp":    now.Add(time.Hour).Unix(),
+			"scope":  "sites:read sites:write",
+			"tenant": "acme-corp",
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+
+	var testCases = []struct {
+		name   string
+		token  string
+		reason string
+	}{
+		{"RS256", k.sign(t, "RS256", "rsa", claims(nil)), ""},
+		{"ES256", k.sign(t, "ES256", "ec", claims(nil)), ""},
+		{"without kid", k.sign(t, "ES256", "", claims(nil)), ""},
+		{"within leeway", k.sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), ""},
+		{"expired", k.sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})), "token has expired"},
+		{"no expiry", k.sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": nil})), "token has no expiry"},
+		{"not yet valid", k.sign(t, "RS256", "rsa", claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), "token isn't valid yet"},
+		{"other issuer", k.sign(t, "RS256", "rsa", claims(map[string]interface{}{"iss": "https://evil.example.com/"})), "token is from another issuer"},
+		{"other audience", k.sign(t, "RS256", "rsa", claims(map[string]interface{}{"aud": "other"})), "token is for another audience"},
+		{"no subject", k.sign(t, "RS256", "rsa", claims(map[string]interface{}{"sub": nil})), "token has no subject"},
+		{"tenant not a string", k.sign(t, "RS256", "rsa", claims(map[string]interface{}{"tenant": []string{"acme-corp", "globex"}})), "token tenant is malformed"},
+		{"unknown kid", k.sign(t, "RS256", "rotated", claims(nil)), "token is signed by an unknown key"},
+		{"key for other alg", k.sign(t, "ES256", "rsa", claims(nil)), "token is signed by an unknown key"},
+		{"alg none", k.sign(t, "none", "rsa", claims(nil)), `token algorithm "none" isn't accepted`},
+		{"alg HS256", k.sign(t, "HS256", "rsa", claims(nil)), `token algorithm "HS256" isn't accepted`},
+		{"tampered", tamper(k.sign(t, "RS256", "rsa", claims(nil)), claims(map[string]interface{}{"sub": "admin"})), "token signature doesn't match"},
+		{"not a JWT", "opaque-token", "token is not a JWT"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			principal, err := verifier.Verify(tc.token)
+			if tc.reason == "" {
+				if err != nil {
+					t.Fatalf("Verify: got error %v", err)
+				}
+				if principal.Subject != "user-1" || principal.Method != "jwt" || len(principal.Scopes) != 2 || principal.Tenant != "acme-corp" {
+					t.Errorf("Verify: got principal %+v", principal)
+				}
+				return
+			}
+			cerr, ok := err.(*CredentialsError)
+			if !ok || cerr.Reason != tc.reason {
+				t.Errorf("Verify: got error %v; wanted %q", err, tc.reason)
+			}
+		})
+	}
+}
+
+// tamper returns the token with its claims replaced, keeping its signature
+func tamper(token string, claims map[string]interface{}) string {
+	parts := strings.Split(token, ".")
+	c, _ := json.Marshal(claims)
+	parts[1] = base64.RawURLEncoding.EncodeToString(c)
+	return strings.Join(parts, ".")
+}
+
+func TestRequire(t *testing.T) {
+	k := newKeys(t)
+	path := k.keySetFile(t)
+	defer os.Remove(path)
+
+	authenticator := &Bearer{Verifier: &Verifier{Keys: FileKeySource(path)}}
+	token := k.sign(t, "RS256", "rsa", map[string]interface{}{"sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()})
+
+	var called *Principal
+	var principalID interface{}
+	handler := func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		called = FromContext(ctx)
+		principalID = request.RequestContext.Authorizer["principalId"]
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	}
+
+	var testCases = []struct {
+		name      string
+		wrap      func(Authenticator, Handler) Handler
+		headers   map[string]string
+		status    int
+		challenge string
+		subject   string
+	}{
+		{"required with token", Require, map[string]string{"authorization": "Bearer " + token}, http.StatusOK, "", "user-1"},
+		{"required without token", Require, nil, http.StatusUnauthorized, "Bearer", ""},
+		{"required with other scheme", Require, map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}, http.StatusUnauthorized, "Bearer", ""},
+		{"required with bad token", Require, map[string]string{"Authorization": "Bearer " + token + "x"}, http.StatusUnauthorized, `Bearer error="invalid_token"`, ""},
+		{"optional with token", Optional, map[string]string{"Authorization": "Bearer " + token}, http.StatusOK, "", "user-1"},
+		{"optional without token", Optional, nil, http.StatusOK, "", ""},
+		{"optional with bad token", Optional, map[string]string{"Authorization": "Bearer " + token + "x"}, http.StatusUnauthorized, `Bearer error="invalid_token"`, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			called, principalID = nil, nil
+			response, err := tc.wrap(authenticator, handler)(context.Background(), events.APIGatewayProxyRequest{Headers: tc.headers})
+			if err != nil {
+				t.Fatalf("handler: got error %v", err)
+			}
+			if response.StatusCode != tc.status {
+				t.Fatalf("handler: got status %d; wanted %d", response.StatusCode, tc.status)
+			}
+			if response.Headers["WWW-Authenticate"] != tc.challenge {
+				t.Errorf("handler: got challenge %q; wanted %q", response.Headers["WWW-Authenticate"], tc.challenge)
+			}
+			if tc.status == http.StatusUnauthorized && response.Headers["Content-Type"] != "application/problem+json" {
+				t.Errorf("handler: got content type %q", response.Headers["Content-Type"])
+			}
+			if tc.subject == "" {
+				if called != nil || principalID != nil {
+					t.Errorf("handler: got principal %+v, %v; wanted none", called, principalID)
+				}
+				return
+			}
+			if called == nil || called.Subject != tc.subject || principalID != tc.subject {
+				t.Errorf("handler: got principal %+v, %v; wanted %s", called, principalID, tc.subject)
+			}
+		})
+	}
+}
+
+func TestFromEnvWithoutKeySet(t *testing.T) {
+	k := newKeys(t)
+	token := k.sign(t, "RS256", "rsa", map[string]interface{}{"sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()})
+
+	source, set := os.LookupEnv("JWKS_SOURCE")
+	os.Unsetenv("JWKS_SOURCE")
+	if set {
+		defer os.Setenv("JWKS_SOURCE", source)
+	}
+
+	handler := func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		t.Errorf("handler: called for a token without a key set")
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	}
+	request := events.APIGatewayProxyRequest{Headers: map[string]string{"Authorization": "Bearer " + token}}
+	response, err := Require(FromEnv(), handler)(context.Background(), request)
+	if err != nil {
+		t.Fatalf("handler: got error %v", err)
+	}
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("handler: got status %d; wanted %d", response.StatusCode, http.StatusUnauthorized)
+	}
+	if response.Headers["Content-Type"] != "application/problem+json" {
+		t.Errorf("handler: got content type %q", response.Headers["Content-Type"])
+	}
+}
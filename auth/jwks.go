@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errUnknownKey is returned by key sources without a key for a token
+var errUnknownKey = errors.New("unknown key")
+
+// refetchInterval is how often a URL key source fetches the key set again for tokens signed by
+// keys it doesn't have, which happens once the issuer rotates its keys
+const refetchInterval = time.Minute
+
+// KeySource returns the public key with the id for verifying the algorithm: an *rsa.PublicKey
+// for RS256 or an *ecdsa.PublicKey on P-256 for ES256. Tokens without a key id may be verified
+// by the only key for their algorithm.
+type KeySource interface {
+	Key(kid, alg string) (crypto.PublicKey, error)
+}
+
+// KeySet is a parsed JSON Web Key Set (RFC 7517)
+type KeySet struct {
+	keys []key
+}
+
+// key is a public key of a key set with the algorithm it verifies
+type key struct {
+	kid string
+	alg string
+	pub crypto.PublicKey
+}
+
+// jwk is a JSON Web Key. Only the members of RSA & P-256 public keys are read.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseKeySet parses a JSON Web Key Set. Keys for encryption, and of types & curves that can't
+// verify RS256 or ES256, are skipped.
+func ParseKeySet(b []byte) (*KeySet, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	keySet := &KeySet{}
+	for _, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA" && (k.Alg == "" || k.Alg == "RS256"):
+			n, errN := decodeInt(k.N)
+			e, errE := decodeInt(k.E)
+			if errN != nil || errE != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("RSA key %q is malformed", k.Kid)
+			}
+			keySet.keys = append(keySet.keys, key{kid: k.Kid, alg: "RS256", pub: &rsa.PublicKey{N: n, E: int(e.Int64())}})
+		case k.Kty == "EC" && k.Crv == "P-256" && (k.Alg == "" || k.Alg == "ES256"):
+			x, errX := decodeInt(k.X)
+			y, errY := decodeInt(k.Y)
+			if errX != nil || errY != nil || !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("EC key %q is malformed", k.Kid)
+			}
+			keySet.keys = append(keySet.keys, key{kid: k.Kid, alg: "ES256", pub: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}})
+		}
+	}
+
+	return keySet, nil
+}
+
+// Key returns the set's key with the id for the algorithm
+func (s *KeySet) Key(kid, alg string) (crypto.PublicKey, error) {
+	var match *key
+	for i, k := range s.keys {
+		if k.alg != alg {
+			continue
+		}
+		if k.kid == kid {
+			return k.pub, nil
+		}
+		if kid == "" {
+			if match != nil {
+				// without an id, which of several keys signed the token is ambiguous
+				return nil, errUnknownKey
+			}
+			match = &s.keys[i]
+		}
+	}
+	if match == nil {
+		return nil, errUnknownKey
+	}
+
+	return match.pub, nil
+}
+
+// decodeInt decodes a base64url big-endian integer of a key
+func decodeInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("malformed integer")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// FileKeySource returns a key source reading the key set from the file, such as one checked in
+// for tests or bundled with the functions. The file is read once.
+func FileKeySource(path string) KeySource {
+	return &fileKeySource{path: path}
+}
+
+type fileKeySource struct {
+	path string
+	once sync.Once
+	set  *KeySet
+	err  error
+}
+
+// Key returns the key from the file's key set
+func (f *fileKeySource) Key(kid, alg string) (crypto.PublicKey, error) {
+	f.once.Do(func() {
+		var b []byte
+		b, f.err = ioutil.ReadFile(f.path)
+		if f.err == nil {
+			f.set, f.err = ParseKeySet(b)
+		}
+	})
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return f.set.Key(kid, alg)
+}
+
+// NoKeySource returns a key source without any keys, refusing every token as signed by an
+// unknown key. It's used when no key set is configured, so tokens are refused with a 401
+// rather than failing to be checked.
+func NoKeySource() KeySource {
+	return noKeySource{}
+}
+
+type noKeySource struct{}
+
+// Key returns errUnknownKey, whatever the key
+func (noKeySource) Key(kid, alg string) (crypto.PublicKey, error) {
+	return nil, errUnknownKey
+}
+
+// URLKeySource is a key source fetching the key set from a URL, such as an identity provider's
+// jwks_uri. The key set is cached for the TTL, and fetched again early for unknown keys.
+type URLKeySource struct {
+	URL    string
+	TTL    time.Duration
+	Client *http.Client
+
+	mu        sync.Mutex
+	set       *KeySet
+	fetchedAt time.Time
+}
+
+// NewURLKeySource returns a key source fetching the key set from the URL, caching it for the TTL
+func NewURLKeySource(url string, ttl time.Duration) *URLKeySource {
+	return &URLKeySource{URL: url, TTL: ttl, Client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Key returns the key from the cached key set, fetching it when it's stale or lacks the key
+func (u *URLKeySource) Key(kid, alg string) (crypto.PublicKey, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.set == nil || time.Since(u.fetchedAt) > u.TTL {
+		if err := u.fetch(); err != nil {
+			return nil, err
+		}
+	}
+
+	pub, err := u.set.Key(kid, alg)
+	if err == errUnknownKey && time.Since(u.fetchedAt) > refetchInterval {
+		if err := u.fetch(); err != nil {
+			return nil, err
+		}
+		pub, err = u.set.Key(kid, alg)
+	}
+
+	return pub, err
+}
+
+// fetch replaces the cached key set with the URL's
+func (u *URLKeySource) fetch() error {
+	response, err := u.Client.Get(u.URL)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching key set: %s", response.Status)
+	}
+
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	set, err := ParseKeySet(b)
+	if err != nil {
+		return err
+	}
+
+	u.set, u.fetchedAt = set, time.Now()
+	return nil
+}
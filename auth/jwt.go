@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Verifier verifies JWTs signed with RS256 or ES256 by a key of its key set, and checks their
+// time claims along with the issuer & audience when those are set
+type Verifier struct {
+	Keys     KeySource
+	Issuer   string
+	Audience string
+	// TenantClaim is the claim naming the principal's tenant, `tenant` by default
+	TenantClaim string
+	// Leeway allows for clock skew between the issuer and us, a minute by default
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+// header is the JOSE header of a token
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// invalid returns the credentials error with the reason
+func invalid(format string, a ...interface{}) error {
+	return &CredentialsError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// Verify returns the principal of the token, whose subject is its `sub` claim and tenant its
+// tenant claim. Errors other than failing to fetch the key set are *CredentialsError.
+func (v *Verifier) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid("token is not a JWT")
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, invalid("token header is malformed")
+	}
+	if h.Alg != "RS256" && h.Alg != "ES256" {
+		return nil, invalid("token algorithm %q isn't accepted", h.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("token signature is malformed")
+	}
+
+	// keys are only returned for the algorithm they're meant for, so a token can't pick another
+	key, err := v.Keys.Key(h.Kid, h.Alg)
+	if err == errUnknownKey {
+		return nil, invalid("token is signed by an unknown key")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch h.Alg {
+	case "RS256":
+		if err := rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+			return nil, invalid("token signature doesn't match")
+		}
+	case "ES256":
+		if len(signature) != 64 {
+			return nil, invalid("token signature doesn't match")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key.(*ecdsa.PublicKey), digest[:], r, s) {
+			return nil, invalid("token signature doesn't match")
+		}
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalid("token claims are malformed")
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, invalid("token has no subject")
+	}
+
+	tenantClaim := v.TenantClaim
+	if tenantClaim == "" {
+		tenantClaim = "tenant"
+	}
+	tenant, ok := claims[tenantClaim].(string)
+	if !ok && claims[tenantClaim] != nil {
+		return nil, invalid("token tenant is malformed")
+	}
+
+	return &Principal{Subject: subject, Method: "jwt", Scopes: scopes(claims), Tenant: tenant, Claims: claims}, nil
+}
+
+// checkClaims checks the token is current, and is for our issuer & audience
+func (v *Verifier) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	leeway := v.Leeway
+	if leeway == 0 {
+		leeway = time.Minute
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return invalid("token has no expiry")
+	}
+	if now.Add(-leeway).After(time.Unix(int64(exp), 0)) {
+		return invalid("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return invalid("token isn't valid yet")
+	}
+
+	if v.Issuer != "" && claims["iss"] != v.Issuer {
+		return invalid("token is from another issuer")
+	}
+	if v.Audience != "" && !audience(claims["aud"], v.Audience) {
+		return invalid("token is for another audience")
+	}
+
+	return nil
+}
+
+// audience reports whether the `aud` claim, a string or list of strings, includes the audience
+func audience(claim interface{}, want string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == want
+	case []interface{}:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// scopes returns the space separated `scope` claim of OAuth access tokens
+func scopes(claims map[string]interface{}) []string {
+	scope, _ := claims["scope"].(string)
+	return strings.Fields(scope)
+}
+
+// decodeSegment decodes the base64url JSON segment of a token into v
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,322 @@
+// Package changes turns the records of the table's DynamoDB stream into changes to sites &
+// pages, and hands them to subscribers such as the search indexer and cache invalidator. The
+// stream carries every write, including those of site cascades and moves that the endpoints
+// don't report anywhere else.
+package changes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/content"
+)
+
+// Kind is what happened to a site or page
+type Kind string
+
+// Kinds of changes. A page or site taken out of the trash is reported as created, since it
+// appears again to everything downstream.
+const (
+	Created   Kind = "created"
+	Updated   Kind = "updated"
+	Published Kind = "published"
+	Deleted   Kind = "deleted"
+)
+
+// published is the status of a published site
+const published = 1
+
+// Site defines the fields of the site model carried in changes
+type Site struct {
+	ID          string     `json:"id"`
+	Version     string     `json:"version"`
+	Path        string     `json:"path"`
+	Type        string     `json:"type"`
+	Status      int        `json:"status,omitempty"`
+	Name        *string    `json:"name,omitempty"`
+	Description *string    `json:"description,omitempty"`
+	Keywords    *string    `json:"keywords,omitempty"`
+	Tags        []string   `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	URL         *string    `json:"url,omitempty"`
+	UpdatedAt   time.Time  `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Page defines the fields of the page model carried in changes
+type Page struct {
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Path        string                 `json:"path"`
+	Type        string                 `json:"type"`
+	ParentID    *string                `json:"parentId,omitempty"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Tags        []string               `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Author      *string                `json:"author,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	ContentType *string                `json:"contentType,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time             `json:"deletedAt,omitempty"`
+}
+
+// Change is a change to a site or page. Type is "site" or "page", and Site is the path of the
+// site either way. Only the old & new values of that type are set, old ones being nil for
+// created items and new ones nil for deleted items.
+type Change struct {
+	// EventID is the id of the stream record, which is the same each time the record is retried
+	EventID string    `json:"-"`
+	Kind    Kind      `json:"kind"`
+	Type    string    `json:"type"`
+	Site    string    `json:"site"`
+	ID      string    `json:"id"`
+	Version string    `json:"version"`
+	Path    string    `json:"path"`
+	At      time.Time `json:"at"`
+	OldSite *Site     `json:"-"`
+	NewSite *Site     `json:"-"`
+	OldPage *Page     `json:"-"`
+	NewPage *Page     `json:"-"`
+}
+
+// Subscriber is notified of changes. Stream records are retried when a subscriber fails, so
+// subscribers may be notified of the same change more than once.
+type Subscriber interface {
+	Notify(ctx context.Context, change Change) error
+}
+
+// SubscriberFunc lets a function be a subscriber
+type SubscriberFunc func(ctx context.Context, change Change) error
+
+// Notify calls the function
+func (f SubscriberFunc) Notify(ctx context.Context, change Change) error {
+	return f(ctx, change)
+}
+
+// Dispatcher classifies the records of stream events and notifies its subscribers of the changes
+type Dispatcher struct {
+	subscribers []Subscriber
+}
+
+// NewDispatcher returns a dispatcher notifying the subscribers, in order
+func NewDispatcher(subscribers ...Subscriber) *Dispatcher {
+	return &Dispatcher{subscribers: subscribers}
+}
+
+// Dispatch notifies every subscriber of every change in the event. Records that can't be decoded
+// are logged and skipped, since retrying them would never succeed and would hold up the stream.
+// Subscribers that fail don't stop the others, but an error is returned so the event is retried.
+func (d *Dispatcher) Dispatch(ctx context.Context, event events.DynamoDBEvent) error {
+	failures := 0
+
+	for _, record := range event.Records {
+		change, err := Classify(record)
+		if err != nil {
+			log.Println("Error decoding stream record", record.EventID, err)
+			continue
+		}
+		if change == nil {
+			continue
+		}
+
+		for _, subscriber := range d.subscribers {
+			if err := subscriber.Notify(ctx, *change); err != nil {
+				log.Printf("Error notifying %T of %s %s %s: %v\n", subscriber, change.Kind, change.Type, change.ID, err)
+				failures++
+			}
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d notifications failed", failures)
+	}
+
+	return nil
+}
+
+// Classify returns the change a stream record makes to a site or page, or nil when it isn't one:
+// records of other items like tags & redirects, and the TTL purging items already in the trash.
+func Classify(record events.DynamoDBEventRecord) (*Change, error) {
+	oldImage, newImage := image(record.Change.OldImage), image(record.Change.NewImage)
+
+	itemType := attribute(newImage, "type")
+	if itemType == "" {
+		itemType = attribute(oldImage, "type")
+	}
+	if itemType != "site" && itemType != "page" {
+		return nil, nil
+	}
+
+	oldDeleted, newDeleted := oldImage["deletedAt"] != nil, newImage["deletedAt"] != nil
+	var kind Kind
+	switch record.EventName {
+	case string(events.DynamoDBOperationTypeInsert):
+		if newDeleted {
+			return nil, nil
+		}
+		kind = Created
+	case string(events.DynamoDBOperationTypeModify):
+		switch {
+		case oldDeleted && newDeleted:
+			return nil, nil
+		case newDeleted:
+			kind = Deleted
+		case oldDeleted:
+			kind = Created
+		default:
+			kind = Updated
+		}
+	case string(events.DynamoDBOperationTypeRemove):
+		if oldDeleted {
+			return nil, nil
+		}
+		kind = Deleted
+	default:
+		return nil, fmt.Errorf("unknown event %q", record.EventName)
+	}
+
+	change := &Change{EventID: record.EventID, Kind: kind, Type: itemType, At: record.Change.ApproximateCreationDateTime.Time}
+	var err error
+	if itemType == "site" {
+		change.OldSite, change.NewSite, err = decodeSites(oldImage, newImage, kind)
+		if err != nil {
+			return nil, err
+		}
+		site := change.NewSite
+		if site == nil {
+			site = change.OldSite
+		}
+		if kind == Updated && change.OldSite.Status != published && change.NewSite.Status == published {
+			change.Kind = Published
+		}
+		change.ID, change.Version, change.Path, change.Site = site.ID, site.Version, site.Path, site.Path
+	} else {
+		change.OldPage, change.NewPage, err = decodePages(oldImage, newImage, kind)
+		if err != nil {
+			return nil, err
+		}
+		page := change.NewPage
+		if page == nil {
+			page = change.OldPage
+		}
+		change.ID, change.Version, change.Path, change.Site = page.ID, page.Version, page.Path, SitePath(page.Path)
+	}
+
+	return change, nil
+}
+
+// SitePath returns the path of the site a page belongs to, which is the first part of its path
+func SitePath(pagePath string) string {
+	return strings.SplitN(pagePath, "/", 2)[0]
+}
+
+// decodeSites decodes the images that matter for the kind of change
+func decodeSites(oldImage, newImage map[string]*dynamodb.AttributeValue, kind Kind) (*Site, *Site, error) {
+	var oldSite, newSite *Site
+	if kind != Created {
+		oldSite = &Site{}
+		if err := dynamodbattribute.UnmarshalMap(oldImage, oldSite); err != nil {
+			return nil, nil, err
+		}
+	}
+	if kind != Deleted {
+		newSite = &Site{}
+		if err := dynamodbattribute.UnmarshalMap(newImage, newSite); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return oldSite, newSite, nil
+}
+
+// decodePages decodes the images that matter for the kind of change
+func decodePages(oldImage, newImage map[string]*dynamodb.AttributeValue, kind Kind) (*Page, *Page, error) {
+	var oldPage, newPage *Page
+	if kind != Created {
+		oldPage = &Page{}
+		if err := dynamodbattribute.UnmarshalMap(oldImage, oldPage); err != nil {
+			return nil, nil, err
+		}
+	}
+	if kind != Deleted {
+		newPage = &Page{}
+		if err := dynamodbattribute.UnmarshalMap(newImage, newPage); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return oldPage, newPage, nil
+}
+
+// attribute returns the string attribute of the item, or an empty string when it isn't one
+func attribute(item map[string]*dynamodb.AttributeValue, name string) string {
+	if item[name] == nil || item[name].S == nil {
+		return ""
+	}
+
+	return *item[name].S
+}
+
+// image converts a stream image to the attribute values the SDK unmarshals items from
+func image(values map[string]events.DynamoDBAttributeValue) map[string]*dynamodb.AttributeValue {
+	if values == nil {
+		return nil
+	}
+
+	item := make(map[string]*dynamodb.AttributeValue, len(values))
+	for name, value := range values {
+		item[name] = attributeValue(value)
+	}
+
+	return item
+}
+
+// attributeValue converts a stream attribute value to the SDK's
+func attributeValue(value events.DynamoDBAttributeValue) *dynamodb.AttributeValue {
+	av := &dynamodb.AttributeValue{}
+
+	switch value.DataType() {
+	case events.DataTypeBinary:
+		av.B = value.Binary()
+	case events.DataTypeBoolean:
+		b := value.Boolean()
+		av.BOOL = &b
+	case events.DataTypeBinarySet:
+		av.BS = value.BinarySet()
+	case events.DataTypeList:
+		av.L = []*dynamodb.AttributeValue{}
+		for _, v := range value.List() {
+			av.L = append(av.L, attributeValue(v))
+		}
+	case events.DataTypeMap:
+		av.M = image(value.Map())
+	case events.DataTypeNumber:
+		n := value.Number()
+		av.N = &n
+	case events.DataTypeNumberSet:
+		for _, n := range value.NumberSet() {
+			n := n
+			av.NS = append(av.NS, &n)
+		}
+	case events.DataTypeNull:
+		null := true
+		av.NULL = &null
+	case events.DataTypeString:
+		s := value.String()
+		av.S = &s
+	case events.DataTypeStringSet:
+		for _, s := range value.StringSet() {
+			s := s
+			av.SS = append(av.SS, &s)
+		}
+	}
+
+	return av
+}
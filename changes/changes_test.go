@@ -0,0 +1,161 @@
+package changes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/feckmore/go-lambda-dynamo/search"
+)
+
+func TestClassify(t *testing.T) {
+	var testCases = []struct {
+		fixture string
+		want    []Change
+	}{
+		{"page-created.json", []Change{{EventID: "c4ca4238a0b923820dcc509a6f75849b", Kind: Created, Type: "page", Site: "acme", ID: "5f0c6a2e-8b4e-4bb8-9a55-3f1e1d8a2c11", Version: "a3e1f0b2-7c2d-4e7a-8d5b-6c9f0e1d2b33", Path: "acme/blog/rockets", At: time.Unix(1554197400, 0)}}},
+		{"page-updated.json", []Change{{EventID: "c81e728d9d4c2f636f067f89cc14862c", Kind: Updated, Type: "page", Site: "acme", ID: "5f0c6a2e-8b4e-4bb8-9a55-3f1e1d8a2c11", Version: "a3e1f0b2-7c2d-4e7a-8d5b-6c9f0e1d2b33", Path: "acme/news/rockets", At: time.Unix(1554201000, 0)}}},
+		{"page-deleted.json", []Change{{EventID: "eccbc87e4b5ce2fe28308fd9f2a7baf3", Kind: Deleted, Type: "page", Site: "acme", ID: "5f0c6a2e-8b4e-4bb8-9a55-3f1e1d8a2c11", Version: "a3e1f0b2-7c2d-4e7a-8d5b-6c9f0e1d2b33", Path: "acme/news/rockets", At: time.Unix(1554204600, 0)}}},
+		{"site-published.json", []Change{{EventID: "a87ff679a2f3e71d9181a67b7542122c", Kind: Published, Type: "site", Site: "acme", ID: "0b9e2c1d-3f4a-4b5c-8d6e-7f8091a2b3c4", Version: "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f", Path: "acme", At: time.Unix(1554208200, 0)}}},
+		{"ignored.json", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			var got []Change
+			for _, record := range loadEvent(t, tc.fixture).Records {
+				change, err := Classify(record)
+				if err != nil {
+					t.Fatalf("Classify: %v", err)
+				}
+				if change != nil {
+					// compare the old & new values separately
+					change.OldSite, change.NewSite, change.OldPage, change.NewPage = nil, nil, nil, nil
+					change.At = change.At.UTC()
+					got = append(got, *change)
+				}
+			}
+
+			for i := range tc.want {
+				tc.want[i].At = tc.want[i].At.UTC()
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Classify: got %+v; wanted %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClassifyDecodesImages(t *testing.T) {
+	change, err := Classify(loadEvent(t, "page-created.json").Records[0])
+	if err != nil {
+		t.Fatalf("Classify: %v", err)
+	}
+	page := change.NewPage
+	if change.OldPage != nil || page == nil {
+		t.Fatalf("Classify: got old page %+v & new page %+v; wanted only a new page", change.OldPage, page)
+	}
+	if *page.Name != "Rockets" || !reflect.DeepEqual(page.Tags, []string{"anvils", "rockets"}) {
+		t.Errorf("Classify: got name %q & tags %q", *page.Name, page.Tags)
+	}
+	if len(page.Blocks) != 2 || page.Blocks[0].Level != 1 || page.Blocks[1].Spans[1].Marks[0] != "bold" {
+		t.Errorf("Classify: got blocks %+v", page.Blocks)
+	}
+
+	change, err = Classify(loadEvent(t, "page-updated.json").Records[0])
+	if err != nil {
+		t.Fatalf("Classify: %v", err)
+	}
+	wantFields := map[string]interface{}{"price": 9.99, "sku": "R-100", "sale": true, "discontinued": nil}
+	if !reflect.DeepEqual(change.NewPage.Fields, wantFields) {
+		t.Errorf("Classify: got fields %#v; wanted %#v", change.NewPage.Fields, wantFields)
+	}
+	if change.OldPage.Path != "acme/blog/rockets" {
+		t.Errorf("Classify: got old path %q; wanted acme/blog/rockets", change.OldPage.Path)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	var event events.DynamoDBEvent
+	for _, fixture := range []string{"page-created.json", "ignored.json", "page-updated.json", "site-published.json", "page-deleted.json"} {
+		event.Records = append(event.Records, loadEvent(t, fixture).Records...)
+	}
+
+	var kinds []Kind
+	var purged [][]string
+	index := search.NewMemory()
+	dispatcher := NewDispatcher(
+		SubscriberFunc(func(ctx context.Context, change Change) error {
+			kinds = append(kinds, change.Kind)
+			return nil
+		}),
+		&SearchIndexer{Index: index},
+		&CacheInvalidator{Purge: func(ctx context.Context, site string, paths []string) error {
+			purged = append(purged, paths)
+			return nil
+		}},
+	)
+
+	if err := dispatcher.Dispatch(context.Background(), event); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+
+	if want := []Kind{Created, Updated, Published, Deleted}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("Dispatch: got kinds %q; wanted %q", kinds, want)
+	}
+	wantPurged := [][]string{
+		{"/blog/rockets", "/sitemap.xml", "/feed"},
+		{"/news/rockets", "/sitemap.xml", "/feed", "/blog/rockets"},
+		{"/*"},
+		{"/news/rockets", "/sitemap.xml", "/feed"},
+	}
+	if !reflect.DeepEqual(purged, wantPurged) {
+		t.Errorf("Dispatch: got purged %q; wanted %q", purged, wantPurged)
+	}
+	// the page was indexed, renamed & deleted, leaving nothing to find
+	if matches, _ := search.Search(index, "acme", "rockets"); len(matches) != 0 {
+		t.Errorf("Dispatch: got search matches %+v; wanted none", matches)
+	}
+}
+
+func TestDispatchFailure(t *testing.T) {
+	notified := 0
+	dispatcher := NewDispatcher(
+		SubscriberFunc(func(ctx context.Context, change Change) error {
+			return errors.New("unavailable")
+		}),
+		SubscriberFunc(func(ctx context.Context, change Change) error {
+			notified++
+			return nil
+		}),
+	)
+
+	err := dispatcher.Dispatch(context.Background(), loadEvent(t, "page-created.json"))
+	if err == nil {
+		t.Errorf("Dispatch: got no error; wanted one so the event is retried")
+	}
+	if notified != 1 {
+		t.Errorf("Dispatch: notified the other subscriber %d times; wanted 1", notified)
+	}
+}
+
+// loadEvent reads a recorded stream event from testdata
+func loadEvent(t *testing.T, fixture string) events.DynamoDBEvent {
+	var event events.DynamoDBEvent
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("reading %s: %v", fixture, err)
+	}
+	if err := json.Unmarshal(b, &event); err != nil {
+		t.Fatalf("unmarshalling %s: %v", fixture, err)
+	}
+
+	return event
+}
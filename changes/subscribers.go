@@ -0,0 +1,64 @@
+package changes
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/search"
+)
+
+// SearchIndexer keeps the search index up to date with page changes. The page endpoints index
+// pages as they write them, so this catches the rest: pages trashed & restored with their site,
+// and writes whose indexing failed.
+type SearchIndexer struct {
+	Index search.Index
+}
+
+// Notify updates the index from the page's old document to its new one
+func (s *SearchIndexer) Notify(ctx context.Context, change Change) error {
+	if change.Type != "page" {
+		return nil
+	}
+
+	return search.Update(s.Index, change.Site, document(change.OldPage), document(change.NewPage))
+}
+
+// document returns the text of the page that is indexed for search, or nil without a page
+func document(page *Page) *search.Document {
+	if page == nil {
+		return nil
+	}
+
+	return &search.Document{
+		ID:          page.ID,
+		Version:     page.Version,
+		Name:        aws.StringValue(page.Name),
+		Description: aws.StringValue(page.Description),
+		Keywords:    aws.StringValue(page.Keywords),
+		Content:     search.Text(page.Blocks, page.Fields),
+	}
+}
+
+// CacheInvalidator purges the paths a change affects from a site's cache, such as a CDN in front
+// of its pages. Purge is called with the site's path and paths relative to the site's URL.
+type CacheInvalidator struct {
+	Purge func(ctx context.Context, site string, paths []string) error
+}
+
+// Notify purges the page along with the site's sitemap & feed that list it, or the whole site
+// when the site itself changed
+func (c *CacheInvalidator) Notify(ctx context.Context, change Change) error {
+	if change.Type == "site" {
+		return c.Purge(ctx, change.Site, []string{"/*"})
+	}
+
+	site := &render.Site{Path: change.Site}
+	paths := []string{render.Relative(site, change.Path), "/sitemap.xml", "/feed"}
+	// a moved page is gone from its old path too
+	if change.OldPage != nil && change.NewPage != nil && change.OldPage.Path != change.NewPage.Path {
+		paths = append(paths, render.Relative(site, change.OldPage.Path))
+	}
+
+	return c.Purge(ctx, change.Site, paths)
+}
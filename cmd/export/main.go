@@ -0,0 +1,360 @@
+// Command export writes a published site out as static files: an index.html per page rendered
+// the same way as the live render endpoint, an assets.json manifest of the images, embeds &
+// card image the pages use, and a sitemap.xml. The output is a directory, or a gzipped tarball
+// when the output path ends in .tar.gz or .tgz.
+//
+//	export -site acme -out ./acme.tar.gz
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/sitemap"
+)
+
+type SiteStatus int
+
+const (
+	Unpublished SiteStatus = iota
+	Published
+)
+
+// Site defines the fields of the site model needed to export it
+type Site struct {
+	render.Site
+	Status    SiteStatus `json:"status,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+}
+
+// Asset is a file outside the site that its pages use, and which pages use it
+type Asset struct {
+	URL   string   `json:"url"`
+	Kind  string   `json:"kind"`
+	Pages []string `json:"pages"`
+}
+
+// Output is where the exported files are written
+type Output interface {
+	WriteFile(name string, data []byte) error
+	Close() error
+}
+
+var db *dynamodb.DynamoDB
+var table string
+
+func main() {
+	sitePath := flag.String("site", "", "path of the site to export")
+	out := flag.String("out", "", "directory, or .tar.gz file, to write the site to")
+	baseURL := flag.String("url", "", "URL the site is served from, instead of the site's own URL")
+	region := flag.String("region", os.Getenv("AWS_REGION"), "AWS region of the table")
+	flag.StringVar(&table, "table", os.Getenv("TABLE_NAME"), "DynamoDB table name")
+	flag.Parse()
+
+	if *sitePath == "" || *out == "" || table == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	session, err := session.NewSession(&aws.Config{Region: aws.String(*region)})
+	if err != nil {
+		log.Fatalln("Failed to connect to AWS:", err)
+	}
+	db = dynamodb.New(session)
+
+	site, err := publishedSite(strings.ToLower(*sitePath))
+	if err != nil {
+		log.Fatalln("Error getting site from dynamodb:", err)
+	}
+	if site == nil {
+		log.Fatalln("No published site found at", *sitePath)
+	}
+	if *baseURL != "" {
+		site.URL = baseURL
+	}
+
+	pages, err := publishedPages(site.Path)
+	if err != nil {
+		log.Fatalln("Error querying pages in dynamodb:", err)
+	}
+
+	output, err := openOutput(*out)
+	if err != nil {
+		log.Fatalln("Error opening output:", err)
+	}
+	err = export(output, &site.Site, pages)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalln("Error exporting site:", err)
+	}
+
+	log.Printf("Exported %d pages of %s to %s", len(pages), site.Path, *out)
+}
+
+// export renders the pages of the site to the output, along with the assets manifest & sitemap
+func export(output Output, site *render.Site, pages []render.Page) error {
+	var urls []sitemap.URL
+	assets := map[string]*Asset{}
+	if site.CardImageURL != nil && *site.CardImageURL != "" {
+		assets[*site.CardImageURL] = &Asset{URL: *site.CardImageURL, Kind: "cardImage", Pages: []string{}}
+	}
+
+	for i := range pages {
+		page := &pages[i]
+		relative := render.Relative(site, page.Path)
+
+		var b bytes.Buffer
+		if err := render.HTML(&b, site, page); err != nil {
+			return fmt.Errorf("rendering %s: %v", page.Path, err)
+		}
+		if err := output.WriteFile(htmlFile(relative), b.Bytes()); err != nil {
+			return err
+		}
+
+		for _, block := range page.Blocks {
+			if block.Type != content.Image && block.Type != content.Embed {
+				continue
+			}
+			asset := assets[block.URL]
+			if asset == nil {
+				asset = &Asset{URL: block.URL, Kind: block.Type}
+				assets[block.URL] = asset
+			}
+			asset.Pages = append(asset.Pages, relative)
+		}
+
+		if url := render.URL(site, page.Path); url != "" {
+			urls = append(urls, sitemap.URL{Loc: url, LastMod: page.UpdatedAt})
+		}
+	}
+
+	manifest := make([]*Asset, 0, len(assets))
+	for _, asset := range assets {
+		manifest = append(manifest, asset)
+	}
+	sort.Slice(manifest, func(i, j int) bool { return manifest[i].URL < manifest[j].URL })
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := output.WriteFile("assets.json", data); err != nil {
+		return err
+	}
+
+	// a sitemap needs absolute URLs, so it's left out when the site's URL isn't known
+	if urls == nil {
+		log.Println("Site has no URL, so no sitemap.xml is written. Set one with -url")
+		return nil
+	}
+	chunks := sitemap.Split(urls)
+	if len(chunks) == 1 {
+		return writeSitemap(output, "sitemap.xml", chunks[0])
+	}
+
+	// past sitemap.MaxURLs, sitemap.xml is an index of numbered sitemaps
+	index := make([]sitemap.URL, len(chunks))
+	for i, chunk := range chunks {
+		name := fmt.Sprintf("sitemap-%d.xml", i+1)
+		if err := writeSitemap(output, name, chunk); err != nil {
+			return err
+		}
+		index[i] = sitemap.URL{Loc: render.URL(site, site.Path+"/"+name), LastMod: sitemap.Latest(chunk)}
+	}
+	var b bytes.Buffer
+	if err := sitemap.WriteIndex(&b, index); err != nil {
+		return err
+	}
+
+	return output.WriteFile("sitemap.xml", b.Bytes())
+}
+
+// writeSitemap writes a sitemap of the URLs to the named file
+func writeSitemap(output Output, name string, urls []sitemap.URL) error {
+	var b bytes.Buffer
+	if err := sitemap.Write(&b, urls); err != nil {
+		return err
+	}
+
+	return output.WriteFile(name, b.Bytes())
+}
+
+// htmlFile returns the file a page relative to the site is written to, so that it's served at
+// the same path by any static host
+func htmlFile(relative string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(relative, "/")+"/index.html", "/")
+}
+
+// openOutput returns a gzipped tarball output for .tar.gz & .tgz paths, or a directory output
+func openOutput(out string) (Output, error) {
+	if strings.HasSuffix(out, ".tar.gz") || strings.HasSuffix(out, ".tgz") {
+		file, err := os.Create(out)
+		if err != nil {
+			return nil, err
+		}
+		return newTarOutput(file), nil
+	}
+
+	return &dirOutput{dir: out}, os.MkdirAll(out, 0755)
+}
+
+// dirOutput writes files under a directory
+type dirOutput struct {
+	dir string
+}
+
+func (d *dirOutput) WriteFile(name string, data []byte) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (d *dirOutput) Close() error {
+	return nil
+}
+
+// tarOutput writes files into a gzipped tarball
+type tarOutput struct {
+	file io.WriteCloser
+	gzip *gzip.Writer
+	tar  *tar.Writer
+	now  time.Time
+}
+
+func newTarOutput(file io.WriteCloser) *tarOutput {
+	gz := gzip.NewWriter(file)
+	return &tarOutput{file: file, gzip: gz, tar: tar.NewWriter(gz), now: time.Now()}
+}
+
+func (t *tarOutput) WriteFile(name string, data []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: t.now}
+	if err := t.tar.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := t.tar.Write(data)
+	return err
+}
+
+func (t *tarOutput) Close() error {
+	for _, closer := range []io.Closer{t.tar, t.gzip, t.file} {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishedSite returns the latest version of the site at the path when it's published and
+// outside the trash, or nil otherwise
+func publishedSite(sitePath string) (*Site, error) {
+	var sites []Site
+
+	key := expression.Key("type").Equal(expression.Value("site")).And(expression.Key("path").Equal(expression.Value(sitePath)))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &sites)
+	if err != nil || len(sites) == 0 {
+		return nil, err
+	}
+
+	latest := sites[0]
+	for _, site := range sites[1:] {
+		if site.UpdatedAt.After(latest.UpdatedAt) {
+			latest = site
+		}
+	}
+	if latest.Status != Published {
+		return nil, nil
+	}
+
+	return &latest, nil
+}
+
+// publishedPages walks the site's pages outside the trash in path order, returning the latest
+// version at each path
+func publishedPages(sitePath string) ([]render.Page, error) {
+	var pages []render.Page
+
+	key := expression.Key("type").Equal(expression.Value("page")).And(expression.Key("path").BeginsWith(sitePath))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	queryInput := dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var results []render.Page
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &results); unmarshalErr != nil {
+			return false
+		}
+		for _, result := range results {
+			// the prefix also matches other sites whose paths start with this one's
+			if result.Path != sitePath && !strings.HasPrefix(result.Path, sitePath+"/") {
+				continue
+			}
+			// versions of a page come back together, since the index is sorted by path
+			last := len(pages) - 1
+			if last >= 0 && pages[last].Path == result.Path {
+				if result.UpdatedAt.After(pages[last].UpdatedAt) {
+					pages[last] = result
+				}
+				continue
+			}
+			pages = append(pages, result)
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+
+	return pages, err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/render"
+)
+
+// memoryOutput keeps the exported files in memory
+type memoryOutput map[string]string
+
+func (m memoryOutput) WriteFile(name string, data []byte) error {
+	m[name] = string(data)
+	return nil
+}
+
+func (m memoryOutput) Close() error {
+	return nil
+}
+
+func TestExport(t *testing.T) {
+	site := &render.Site{Path: "acme", Name: aws.String("Acme"), URL: aws.String("https://acme.example.com")}
+	pages := []render.Page{
+		{Path: "acme", Name: aws.String("Home"), UpdatedAt: time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC), Blocks: []content.Block{
+			{Type: content.Image, URL: "https://cdn.example.com/hero.png", Alt: "Hero"},
+		}},
+		{Path: "acme/about", Name: aws.String("About"), Blocks: []content.Block{
+			{Type: content.Image, URL: "https://cdn.example.com/hero.png", Alt: "Hero"},
+			{Type: content.Embed, URL: "https://www.youtube.com/embed/abc"},
+		}},
+	}
+
+	output := memoryOutput{}
+	if err := export(output, site, pages); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	for _, name := range []string{"index.html", "about/index.html", "assets.json", "sitemap.xml"} {
+		if _, ok := output[name]; !ok {
+			t.Errorf("export: missing %s", name)
+		}
+	}
+	if !strings.Contains(output["about/index.html"], "<title>About</title>") {
+		t.Errorf("export: about/index.html isn't the rendered page:\n%s", output["about/index.html"])
+	}
+
+	var assets []Asset
+	if err := json.Unmarshal([]byte(output["assets.json"]), &assets); err != nil {
+		t.Fatalf("export: assets.json: %v", err)
+	}
+	if len(assets) != 2 || assets[0].URL != "https://cdn.example.com/hero.png" || len(assets[0].Pages) != 2 {
+		t.Errorf("export: got assets %+v; wanted hero image on both pages & the embed", assets)
+	}
+
+	for _, want := range []string{
+		"<loc>https://acme.example.com/</loc>",
+		"<lastmod>2019-04-01T00:00:00Z</lastmod>",
+		"<loc>https://acme.example.com/about</loc>",
+	} {
+		if !strings.Contains(output["sitemap.xml"], want) {
+			t.Errorf("export: sitemap.xml missing %s in\n%s", want, output["sitemap.xml"])
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/feckmore/go-lambda-dynamo/changes"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/webhooks"
+)
+
+var dispatcher *changes.Dispatcher
+var cdn *cloudfront.CloudFront
+var region, stage, table, queueURL string
+var distributions map[string]string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+	queueURL = os.Getenv("DELIVERY_QUEUE_URL")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+	log.Println("DELIVERY_QUEUE_URL:", queueURL)
+
+	// CDN_DISTRIBUTIONS maps sites to the CloudFront distributions serving them, as
+	// comma separated site=distribution pairs
+	distributions = map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("CDN_DISTRIBUTIONS"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+			distributions[strings.ToLower(parts[0])] = parts[1]
+		}
+	}
+	log.Println("CDN_DISTRIBUTIONS:", len(distributions))
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the subscribers & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db := dynamodb.New(session)
+		subscribers := []changes.Subscriber{
+			&changes.SearchIndexer{Index: &search.Table{DB: db, Name: table}},
+			&webhooks.Notifier{Store: &webhooks.Table{DB: db, Name: table}, Queue: &webhooks.SQSQueue{SQS: sqs.New(session), URL: queueURL}},
+		}
+		if len(distributions) > 0 {
+			cdn = cloudfront.New(session)
+			subscribers = append(subscribers, &changes.CacheInvalidator{Purge: invalidate})
+		}
+		dispatcher = changes.NewDispatcher(subscribers...)
+	}
+
+	lambda.Start(Handler)
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It receives batches of records from the table's stream, and notifies the subscribers of the
+// changes to sites & pages in them. Returning an error has the batch retried.
+func Handler(ctx context.Context, event events.DynamoDBEvent) error {
+	return dispatcher.Dispatch(ctx, event)
+}
+
+// invalidate creates a CloudFront invalidation of the paths, for sites served by a distribution
+func invalidate(ctx context.Context, site string, paths []string) error {
+	distribution, ok := distributions[site]
+	if !ok {
+		return nil
+	}
+
+	items := make([]*string, len(paths))
+	for i, path := range paths {
+		items[i] = aws.String(path)
+	}
+
+	_, err := cdn.CreateInvalidationWithContext(ctx, &cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(distribution),
+		InvalidationBatch: &cloudfront.InvalidationBatch{
+			CallerReference: aws.String(strconv.FormatInt(time.Now().UnixNano(), 10)),
+			Paths: &cloudfront.Paths{
+				Items:    items,
+				Quantity: aws.Int64(int64(len(items))),
+			},
+		},
+	})
+
+	return err
+}
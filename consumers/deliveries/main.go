@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/feckmore/go-lambda-dynamo/webhooks"
+)
+
+var worker *webhooks.Worker
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the worker & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db := dynamodb.New(session)
+		worker = &webhooks.Worker{Store: &webhooks.Table{DB: db, Name: table}, Sender: webhooks.NewSender()}
+	}
+
+	lambda.Start(Handler)
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It receives messages queued by the changes consumer, and delivers their payloads to the
+// webhooks. Returning an error has the messages retried.
+func Handler(ctx context.Context, event events.SQSEvent) error {
+	for _, record := range event.Records {
+		var message webhooks.Message
+		if err := json.Unmarshal([]byte(record.Body), &message); err != nil {
+			// retrying a message that can't be decoded would never succeed
+			log.Println("Error decoding message", record.MessageId, err)
+			continue
+		}
+
+		if err := worker.Deliver(ctx, message); err != nil {
+			log.Println("Error delivering message", record.MessageId, err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+// Package content defines what a page version holds beyond its metadata: the typed blocks its
+// body is made of, and the custom fields its content type declares with a JSON Schema.
+package content
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The types of block a page's content can be made of
+const (
+	RichText     = "richText"
+	Heading      = "heading"
+	Image        = "image"
+	Embed        = "embed"
+	CallToAction = "callToAction"
+)
+
+// The marks that can be applied to a span of rich text
+const (
+	Bold   = "bold"
+	Italic = "italic"
+	Code   = "code"
+)
+
+// MaxBlocks is the most blocks a page can hold, which keeps a page version well within
+// DynamoDB's 400KB item limit
+const MaxBlocks = 200
+
+// Block is one piece of a page's content. Which fields are used depends on the type:
+//   - richText: spans
+//   - heading: text & level (1-6)
+//   - image: url, alt & optional caption
+//   - embed: url (https) & optional caption
+//   - callToAction: text (the label) & url
+type Block struct {
+	Type    string `json:"type"`
+	Text    string `json:"text,omitempty"`
+	Level   int    `json:"level,omitempty"`
+	Spans   []Span `json:"spans,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Alt     string `json:"alt,omitempty"`
+	Caption string `json:"caption,omitempty"`
+}
+
+// Span is a run of rich text sharing the same marks, and optionally linking somewhere
+type Span struct {
+	Text  string   `json:"text"`
+	Marks []string `json:"marks,omitempty"`
+	Href  string   `json:"href,omitempty"`
+}
+
+// BlockError reports the block that failed validation, and why
+type BlockError struct {
+	Index  int
+	Type   string
+	Reason string
+}
+
+func (e *BlockError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("block %d: %s", e.Index, e.Reason)
+	}
+	return fmt.Sprintf("block %d (%s): %s", e.Index, e.Type, e.Reason)
+}
+
+// Validate checks the blocks in order, returning a *BlockError for the first invalid one
+func Validate(blocks []Block) error {
+	if len(blocks) > MaxBlocks {
+		return fmt.Errorf("page has more than %d blocks", MaxBlocks)
+	}
+
+	for i, block := range blocks {
+		if reason := block.validate(); reason != "" {
+			return &BlockError{Index: i, Type: block.Type, Reason: reason}
+		}
+	}
+
+	return nil
+}
+
+// validate returns what is wrong with the block, or an empty string when it is valid
+func (b Block) validate() string {
+	switch b.Type {
+	case RichText:
+		if len(b.Spans) == 0 {
+			return "needs at least one span"
+		}
+		for _, span := range b.Spans {
+			if reason := span.validate(); reason != "" {
+				return reason
+			}
+		}
+
+	case Heading:
+		if strings.TrimSpace(b.Text) == "" {
+			return "needs text"
+		}
+		if b.Level < 1 || b.Level > 6 {
+			return "level must be from 1 to 6"
+		}
+
+	case Image:
+		if !IsLink(b.URL) {
+			return "url must be an http(s) URL or a path starting with /"
+		}
+		if strings.TrimSpace(b.Alt) == "" {
+			return "needs alt text"
+		}
+
+	case Embed:
+		if u, err := url.Parse(b.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return "url must be an https URL"
+		}
+
+	case CallToAction:
+		if strings.TrimSpace(b.Text) == "" {
+			return "needs a label in text"
+		}
+		if !IsLink(b.URL) {
+			return "url must be an http(s) URL or a path starting with /"
+		}
+
+	case "":
+		return "needs a type"
+
+	default:
+		return fmt.Sprintf("unknown type %q", b.Type)
+	}
+
+	return ""
+}
+
+// validate returns what is wrong with the span, or an empty string when it is valid
+func (s Span) validate() string {
+	if s.Text == "" {
+		return "spans need text"
+	}
+	for _, mark := range s.Marks {
+		if mark != Bold && mark != Italic && mark != Code {
+			return fmt.Sprintf("unknown mark %q", mark)
+		}
+	}
+	if s.Href != "" && !IsLink(s.Href) {
+		return "span href must be an http(s) URL or a path starting with /"
+	}
+
+	return ""
+}
+
+// IsLink reports whether the link is an absolute http(s) URL or a path on the site, which rules
+// out schemes like javascript: that would be unsafe to render. Browsers read a backslash after
+// the leading slash as another slash, so paths starting /\ are protocol relative like //.
+func IsLink(link string) bool {
+	if strings.HasPrefix(link, "/") {
+		return !strings.HasPrefix(link, "//") && !strings.HasPrefix(link, "/\\")
+	}
+
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,69 @@
+package content
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		in        []Block
+		wantIndex int // -1 when valid
+	}{
+		{"No blocks", nil, -1},
+		{"Every type", []Block{
+			{Type: Heading, Text: "Welcome", Level: 1},
+			{Type: RichText, Spans: []Span{{Text: "Hello "}, {Text: "world", Marks: []string{Bold, Italic}, Href: "/acme/world"}}},
+			{Type: Image, URL: "https://cdn.example.com/logo.png", Alt: "Logo", Caption: "Our logo"},
+			{Type: Embed, URL: "https://www.youtube.com/embed/abc"},
+			{Type: CallToAction, Text: "Sign up", URL: "/acme/signup"},
+		}, -1},
+		{"Missing type", []Block{{Text: "Welcome"}}, 0},
+		{"Unknown type", []Block{{Type: "video", URL: "https://example.com"}}, 0},
+		{"Heading without text", []Block{{Type: Heading, Text: " ", Level: 1}}, 0},
+		{"Heading level", []Block{{Type: Heading, Text: "Welcome", Level: 1}, {Type: Heading, Text: "Deep", Level: 7}}, 1},
+		{"Rich text without spans", []Block{{Type: RichText}}, 0},
+		{"Rich text empty span", []Block{{Type: RichText, Spans: []Span{{Text: ""}}}}, 0},
+		{"Rich text unknown mark", []Block{{Type: RichText, Spans: []Span{{Text: "Hi", Marks: []string{"blink"}}}}}, 0},
+		{"Rich text script href", []Block{{Type: RichText, Spans: []Span{{Text: "Hi", Href: "javascript:alert(1)"}}}}, 0},
+		{"Image without alt", []Block{{Type: Image, URL: "/logo.png"}}, 0},
+		{"Image protocol relative", []Block{{Type: Image, URL: "//evil.example.com/logo.png", Alt: "Logo"}}, 0},
+		{"Image backslash protocol relative", []Block{{Type: Image, URL: "/\\evil.example.com/logo.png", Alt: "Logo"}}, 0},
+		{"Call to action backslash protocol relative", []Block{{Type: CallToAction, Text: "Sign up", URL: "/\\evil.example.com"}}, 0},
+		{"Embed over http", []Block{{Type: Embed, URL: "http://www.youtube.com/embed/abc"}}, 0},
+		{"Call to action without url", []Block{{Type: CallToAction, Text: "Sign up"}}, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.in)
+			if tc.wantIndex < 0 {
+				if err != nil {
+					t.Errorf("Validate: got error %v; wanted none", err)
+				}
+				return
+			}
+
+			blockErr, ok := err.(*BlockError)
+			if !ok {
+				t.Fatalf("Validate: got error %v; wanted a BlockError", err)
+			}
+			if blockErr.Index != tc.wantIndex {
+				t.Errorf("Validate: got block %d; wanted %d", blockErr.Index, tc.wantIndex)
+			}
+		})
+	}
+}
+
+func TestValidateTooManyBlocks(t *testing.T) {
+	blocks := make([]Block, MaxBlocks+1)
+	for i := range blocks {
+		blocks[i] = Block{Type: Heading, Text: "Heading", Level: 2}
+	}
+
+	err := Validate(blocks)
+	if err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("Validate: got error %v; wanted too many blocks", err)
+	}
+}
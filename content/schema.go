@@ -0,0 +1,274 @@
+package content
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema is the subset of JSON Schema that content types declare their fields with. Decoding a
+// schema that uses keywords outside the subset fails, so nothing in a stored schema goes
+// unenforced.
+type Schema struct {
+	Dialect              string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+var schemaTypes = map[string]bool{"object": true, "array": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true}
+
+// formats are the string formats a schema can require, and how each is checked
+var formats = map[string]func(string) bool{
+	"date-time": func(s string) bool { _, err := time.Parse(time.RFC3339, s); return err == nil },
+	"date":      func(s string) bool { _, err := time.Parse("2006-01-02", s); return err == nil },
+	"uri":       func(s string) bool { u, err := url.Parse(s); return err == nil && u.IsAbs() },
+	"email":     func(s string) bool { _, err := mail.ParseAddress(s); return err == nil },
+}
+
+// UnmarshalJSON decodes the schema, rejecting keywords outside the supported subset
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	type schema Schema // without the UnmarshalJSON method, to avoid recursing into it
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode((*schema)(s)); err != nil {
+		return fmt.Errorf("unsupported schema: %v", err)
+	}
+
+	return nil
+}
+
+// Check returns what is wrong with the schema itself, which must describe an object of fields
+func (s *Schema) Check() error {
+	if s == nil {
+		return fmt.Errorf("schema is required")
+	}
+	if s.Type != "object" {
+		return fmt.Errorf("schema type must be object")
+	}
+
+	return s.check("fields")
+}
+
+// check returns what is wrong with the schema at the location, or nil when it's usable
+func (s *Schema) check(at string) error {
+	if s.Type != "" && !schemaTypes[s.Type] {
+		return fmt.Errorf("%s: unknown type %q", at, s.Type)
+	}
+	if s.Pattern != "" {
+		if _, err := regexp.Compile(s.Pattern); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %v", at, err)
+		}
+	}
+	if s.Format != "" && formats[s.Format] == nil {
+		return fmt.Errorf("%s: unknown format %q", at, s.Format)
+	}
+	for _, limit := range []*int{s.MinItems, s.MaxItems, s.MinLength, s.MaxLength} {
+		if limit != nil && *limit < 0 {
+			return fmt.Errorf("%s: length limits can't be negative", at)
+		}
+	}
+	for _, name := range s.Required {
+		if s.Properties[name] == nil {
+			return fmt.Errorf("%s: required field %q isn't one of the properties", at, name)
+		}
+	}
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("%s.%s: schema is required", at, name)
+		}
+		if err := property.check(at + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(at + "[]")
+	}
+
+	return nil
+}
+
+// FieldError reports the field that didn't match the schema, and why
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// Validate checks a page's fields against the schema, returning a *FieldError for the first
+// field that doesn't match. Values are expected as decoded from JSON, with numbers as float64.
+func (s *Schema) Validate(fields map[string]interface{}) error {
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+
+	return s.validate("fields", fields)
+}
+
+// validate checks the value at the location against the schema
+func (s *Schema) validate(at string, value interface{}) error {
+	if s.Type != "" && !hasType(value, s.Type) {
+		return &FieldError{Field: at, Reason: "must be " + article(s.Type)}
+	}
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		return &FieldError{Field: at, Reason: "must be one of the enumerated values"}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return s.validateObject(at, v)
+	case []interface{}:
+		return s.validateArray(at, v)
+	case string:
+		return s.validateString(at, v)
+	case float64:
+		return s.validateNumber(at, v)
+	}
+
+	return nil
+}
+
+func (s *Schema) validateObject(at string, object map[string]interface{}) error {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			return &FieldError{Field: at + "." + name, Reason: "is required"}
+		}
+	}
+
+	// go through the names in order, so the same invalid fields always report the same error
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property := s.Properties[name]
+		if property == nil {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return &FieldError{Field: at + "." + name, Reason: "is not a field of the content type"}
+			}
+			continue
+		}
+		if err := property.validate(at+"."+name, object[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateArray(at string, array []interface{}) error {
+	if s.MinItems != nil && len(array) < *s.MinItems {
+		return &FieldError{Field: at, Reason: fmt.Sprintf("must have at least %d items", *s.MinItems)}
+	}
+	if s.MaxItems != nil && len(array) > *s.MaxItems {
+		return &FieldError{Field: at, Reason: fmt.Sprintf("must have at most %d items", *s.MaxItems)}
+	}
+	if s.Items != nil {
+		for i, item := range array {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", at, i), item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateString(at string, value string) error {
+	length := utf8.RuneCountInString(value)
+	if s.MinLength != nil && length < *s.MinLength {
+		return &FieldError{Field: at, Reason: fmt.Sprintf("must be at least %d characters", *s.MinLength)}
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		return &FieldError{Field: at, Reason: fmt.Sprintf("must be at most %d characters", *s.MaxLength)}
+	}
+	if s.Pattern != "" {
+		if matched, _ := regexp.MatchString(s.Pattern, value); !matched {
+			return &FieldError{Field: at, Reason: "must match the pattern " + s.Pattern}
+		}
+	}
+	if s.Format != "" && formats[s.Format] != nil && !formats[s.Format](value) {
+		return &FieldError{Field: at, Reason: "must be " + article(s.Format)}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateNumber(at string, value float64) error {
+	if s.Minimum != nil && value < *s.Minimum {
+		return &FieldError{Field: at, Reason: fmt.Sprintf("must be at least %v", *s.Minimum)}
+	}
+	if s.Maximum != nil && value > *s.Maximum {
+		return &FieldError{Field: at, Reason: fmt.Sprintf("must be at most %v", *s.Maximum)}
+	}
+
+	return nil
+}
+
+// hasType reports whether the decoded JSON value is of the schema type
+func hasType(value interface{}, schemaType string) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return schemaType == "object"
+	case []interface{}:
+		return schemaType == "array"
+	case string:
+		return schemaType == "string"
+	case float64:
+		return schemaType == "number" || schemaType == "integer" && v == math.Trunc(v)
+	case bool:
+		return schemaType == "boolean"
+	case nil:
+		return schemaType == "null"
+	}
+
+	return false
+}
+
+// inEnum reports whether the value is one of the enumerated values
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, allowed := range enum {
+		if reflect.DeepEqual(value, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// article prefixes the word with "a" or "an" for error messages
+func article(word string) string {
+	if strings.IndexAny(word[:1], "aeiou") == 0 {
+		return "an " + word
+	}
+	return "a " + word
+}
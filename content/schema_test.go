@@ -0,0 +1,107 @@
+package content
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const productSchema = `{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"type": "object",
+	"required": ["sku", "price"],
+	"additionalProperties": false,
+	"properties": {
+		"sku": {"type": "string", "pattern": "^[A-Z]{3}-[0-9]+$"},
+		"price": {"type": "number", "minimum": 0},
+		"stock": {"type": "integer"},
+		"color": {"type": "string", "enum": ["red", "green"]},
+		"launched": {"type": "string", "format": "date"},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "minLength": 1}}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	var schema Schema
+	if err := json.Unmarshal([]byte(productSchema), &schema); err != nil {
+		t.Fatalf("Unmarshal schema: %v", err)
+	}
+	if err := schema.Check(); err != nil {
+		t.Fatalf("Check schema: %v", err)
+	}
+
+	var testCases = []struct {
+		name      string
+		in        string
+		wantField string // empty when valid
+	}{
+		{"Required only", `{"sku": "ABC-1", "price": 9.5}`, ""},
+		{"Every field", `{"sku": "ABC-1", "price": 0, "stock": 3, "color": "red", "launched": "2019-04-01", "tags": ["new"]}`, ""},
+		{"No fields", `{}`, "fields.sku"},
+		{"Missing sku", `{"price": 1}`, "fields.sku"},
+		{"Pattern", `{"sku": "abc", "price": 1}`, "fields.sku"},
+		{"Number type", `{"sku": "ABC-1", "price": "free"}`, "fields.price"},
+		{"Minimum", `{"sku": "ABC-1", "price": -1}`, "fields.price"},
+		{"Integer", `{"sku": "ABC-1", "price": 1, "stock": 1.5}`, "fields.stock"},
+		{"Enum", `{"sku": "ABC-1", "price": 1, "color": "blue"}`, "fields.color"},
+		{"Format", `{"sku": "ABC-1", "price": 1, "launched": "April"}`, "fields.launched"},
+		{"Max items", `{"sku": "ABC-1", "price": 1, "tags": ["a", "b", "c"]}`, "fields.tags"},
+		{"Item min length", `{"sku": "ABC-1", "price": 1, "tags": ["a", ""]}`, "fields.tags[1]"},
+		{"Additional field", `{"sku": "ABC-1", "price": 1, "weight": 2}`, "fields.weight"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var fields map[string]interface{}
+			if err := json.Unmarshal([]byte(tc.in), &fields); err != nil {
+				t.Fatalf("Unmarshal fields: %v", err)
+			}
+
+			err := schema.Validate(fields)
+			if tc.wantField == "" {
+				if err != nil {
+					t.Errorf("Validate: got error %v; wanted none", err)
+				}
+				return
+			}
+
+			fieldErr, ok := err.(*FieldError)
+			if !ok {
+				t.Fatalf("Validate: got error %v; wanted a FieldError", err)
+			}
+			if fieldErr.Field != tc.wantField {
+				t.Errorf("Validate: got field %s; wanted %s", fieldErr.Field, tc.wantField)
+			}
+		})
+	}
+}
+
+func TestSchemaCheck(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{"Object", `{"type": "object", "properties": {"title": {"type": "string"}}}`, false},
+		{"Not an object", `{"type": "string"}`, true},
+		{"Unsupported keyword", `{"type": "object", "oneOf": []}`, true},
+		{"Nested unsupported keyword", `{"type": "object", "properties": {"title": {"$ref": "#/title"}}}`, true},
+		{"Unknown type", `{"type": "object", "properties": {"title": {"type": "text"}}}`, true},
+		{"Unknown format", `{"type": "object", "properties": {"title": {"type": "string", "format": "color"}}}`, true},
+		{"Bad pattern", `{"type": "object", "properties": {"title": {"type": "string", "pattern": "("}}}`, true},
+		{"Required not a property", `{"type": "object", "required": ["title"]}`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var schema Schema
+			err := json.Unmarshal([]byte(tc.in), &schema)
+			if err == nil {
+				err = schema.Check()
+			}
+
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Errorf("Check: got error %v; wanted error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
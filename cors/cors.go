@@ -0,0 +1,186 @@
+// Package cors answers cross-origin requests from browsers by the stage's policy. The middleware
+// responds to preflight requests itself, before they reach the handler or its authentication,
+// and gives the handler's responses the headers allowing the request's origin to read them.
+package cors
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+)
+
+// Handler is an API Gateway proxy handler, as the endpoints' Handler functions are
+type Handler = func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// Policy is which origins may make cross-origin requests, and how
+type Policy struct {
+	// Origins are the allowed origins, such as `https://admin.example.com`, or patterns of them
+	// such as `https://*.example.com`. The origin `*` allows every origin, but never with
+	// credentials.
+	Origins []string
+	// Methods are the methods allowed in requests
+	Methods []string
+	// Headers are the headers allowed in requests
+	Headers []string
+	// MaxAge is how long browsers may cache a preflight's response
+	MaxAge time.Duration
+	// Credentials allows requests with credentials, such as cookies & authorization headers, from
+	// the origins listed or matching a pattern
+	Credentials bool
+}
+
+// Default settings, for those the environment leaves out
+var (
+	DefaultMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	DefaultHeaders = []string{"Authorization", "Content-Type", "X-API-Key"}
+	DefaultMaxAge  = 10 * time.Minute
+)
+
+// FromEnv returns the policy configured by the environment:
+//
+//	CORS_ORIGINS      the comma separated origins or patterns of them allowed, none if not set
+//	CORS_METHODS      the comma separated methods allowed, the DefaultMethods if not set
+//	CORS_HEADERS      the comma separated request headers allowed, the DefaultHeaders if not set
+//	CORS_MAX_AGE      the seconds preflight responses may be cached, the DefaultMaxAge if not set
+//	CORS_CREDENTIALS  whether requests with credentials are allowed, unless "false"
+//
+// Without origins no cross-origin request is allowed, so a misconfigured stage fails closed.
+func FromEnv() *Policy {
+	policy := &Policy{
+		Origins:     list(os.Getenv("CORS_ORIGINS")),
+		Methods:     list(strings.ToUpper(os.Getenv("CORS_METHODS"))),
+		Headers:     list(os.Getenv("CORS_HEADERS")),
+		MaxAge:      DefaultMaxAge,
+		Credentials: strings.TrimSpace(os.Getenv("CORS_CREDENTIALS")) != "false",
+	}
+	if len(policy.Methods) == 0 {
+		policy.Methods = DefaultMethods
+	}
+	if len(policy.Headers) == 0 {
+		policy.Headers = DefaultHeaders
+	}
+	if value := strings.TrimSpace(os.Getenv("CORS_MAX_AGE")); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			log.Println("CORS_MAX_AGE isn't a number of seconds, so the default is used:", value)
+		} else {
+			policy.MaxAge = time.Duration(seconds) * time.Second
+		}
+	}
+	for _, origin := range policy.Origins {
+		if _, err := path.Match(origin, ""); err != nil {
+			log.Println("CORS_ORIGINS has a malformed pattern, which matches no origin:", origin)
+		}
+	}
+
+	return policy
+}
+
+// list returns the comma separated values, trimmed, leaving out empty ones
+func list(values string) []string {
+	var result []string
+	for _, value := range strings.Split(values, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
+
+// Allow reports whether the origin may make cross-origin requests, and whether with credentials
+func (p *Policy) Allow(origin string) (allowed bool, credentials bool) {
+	if origin == "" {
+		return false, false
+	}
+
+	wildcard := false
+	for _, pattern := range p.Origins {
+		if pattern == "*" {
+			wildcard = true
+			continue
+		}
+		if matched, _ := path.Match(pattern, origin); matched || strings.EqualFold(pattern, origin) {
+			return true, p.Credentials
+		}
+	}
+
+	return wildcard, false
+}
+
+// Apply wraps the handler so cross-origin requests are answered by the policy. Preflight
+// requests are responded to without calling the handler, with 204 No Content for allowed origins
+// and methods, and 403 Forbidden otherwise. The handler's responses get the headers allowing
+// the request's origin to read them, replacing any it set itself.
+func Apply(policy *Policy, next Handler) Handler {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		origin := auth.Header(request, "Origin")
+		allowed, credentials := policy.Allow(origin)
+
+		method := auth.Header(request, "Access-Control-Request-Method")
+		if request.HTTPMethod == http.MethodOptions && method != "" {
+			return policy.preflight(origin, allowed && policy.allowsMethod(method), credentials), nil
+		}
+
+		response, err := next(ctx, request)
+
+		headers := map[string]string{}
+		for name, value := range response.Headers {
+			if !strings.HasPrefix(strings.ToLower(name), "access-control-") {
+				headers[name] = value
+			}
+		}
+		headers["Vary"] = "Origin"
+		if allowed {
+			headers["Access-Control-Allow-Origin"] = origin
+			if credentials {
+				headers["Access-Control-Allow-Credentials"] = "true"
+			}
+		}
+		response.Headers = headers
+
+		return response, err
+	}
+}
+
+// preflight returns the response to a preflight request from the origin
+func (p *Policy) preflight(origin string, allowed, credentials bool) events.APIGatewayProxyResponse {
+	if !allowed {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusForbidden,
+			Headers:    map[string]string{"Vary": "Origin"},
+		}
+	}
+
+	headers := map[string]string{
+		"Vary":                         "Origin",
+		"Access-Control-Allow-Origin":  origin,
+		"Access-Control-Allow-Methods": strings.Join(p.Methods, ", "),
+		"Access-Control-Allow-Headers": strings.Join(p.Headers, ", "),
+		"Access-Control-Max-Age":       strconv.Itoa(int(p.MaxAge / time.Second)),
+	}
+	if credentials {
+		headers["Access-Control-Allow-Credentials"] = "true"
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusNoContent, Headers: headers}
+}
+
+// allowsMethod reports whether the method is allowed in requests
+func (p *Policy) allowsMethod(method string) bool {
+	for _, allowed := range p.Methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,110 @@
+package cors
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestAllow(t *testing.T) {
+	policy := &Policy{
+		Origins:     []string{"https://admin.example.com", "https://*.preview.example.com"},
+		Credentials: true,
+	}
+	open := &Policy{Origins: []string{"*"}, Credentials: true}
+
+	var testCases = []struct {
+		name        string
+		policy      *Policy
+		origin      string
+		allowed     bool
+		credentials bool
+	}{
+		{"listed", policy, "https://admin.example.com", true, true},
+		{"pattern", policy, "https://pr-12.preview.example.com", true, true},
+		{"pattern's suffix", policy, "https://evilpreview.example.com", false, false},
+		{"other scheme", policy, "http://admin.example.com", false, false},
+		{"other port", policy, "https://admin.example.com:8443", false, false},
+		{"not listed", policy, "https://evil.example.net", false, false},
+		{"no origin", policy, "", false, false},
+		{"wildcard", open, "https://evil.example.net", true, false},
+		{"no origins", &Policy{}, "https://admin.example.com", false, false},
+	}
+
+	for _, tc := range testCases {
+		allowed, credentials := tc.policy.Allow(tc.origin)
+		if allowed != tc.allowed || credentials != tc.credentials {
+			t.Errorf("Allow %s: got %v, %v; wanted %v, %v", tc.name, allowed, credentials, tc.allowed, tc.credentials)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	policy := &Policy{
+		Origins:     []string{"https://admin.example.com"},
+		Methods:     DefaultMethods,
+		Headers:     DefaultHeaders,
+		MaxAge:      10 * time.Minute,
+		Credentials: true,
+	}
+	called := false
+	handler := Apply(policy, func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		called = true
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusOK,
+			Headers: map[string]string{
+				"Content-Type":                "application/json",
+				"Access-Control-Allow-Origin": "*",
+			},
+		}, nil
+	})
+
+	var testCases = []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+		origin  string
+		called  bool
+	}{
+		{"preflight", "OPTIONS", map[string]string{"origin": "https://admin.example.com", "access-control-request-method": "PATCH"}, http.StatusNoContent, "https://admin.example.com", false},
+		{"preflight from other origin", "OPTIONS", map[string]string{"Origin": "https://evil.example.net", "Access-Control-Request-Method": "GET"}, http.StatusForbidden, "", false},
+		{"preflight for other method", "OPTIONS", map[string]string{"Origin": "https://admin.example.com", "Access-Control-Request-Method": "TRACE"}, http.StatusForbidden, "", false},
+		{"request", "GET", map[string]string{"Origin": "https://admin.example.com"}, http.StatusOK, "https://admin.example.com", true},
+		{"request from other origin", "GET", map[string]string{"Origin": "https://evil.example.net"}, http.StatusOK, "", true},
+		{"same origin request", "GET", nil, http.StatusOK, "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			response, err := handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: tc.method, Headers: tc.headers})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != tc.status || called != tc.called {
+				t.Errorf("Apply: got status %d, called %v; wanted %d, %v", response.StatusCode, called, tc.status, tc.called)
+			}
+			if origin := response.Headers["Access-Control-Allow-Origin"]; origin != tc.origin {
+				t.Errorf("Apply: got allowed origin %q; wanted %q", origin, tc.origin)
+			}
+			if credentials := response.Headers["Access-Control-Allow-Credentials"]; credentials != "" && tc.origin == "" {
+				t.Errorf("Apply: got credentials allowed for a refused origin")
+			}
+			if response.Headers["Vary"] != "Origin" {
+				t.Errorf("Apply: got Vary %q; wanted Origin", response.Headers["Vary"])
+			}
+		})
+	}
+
+	preflight, _ := handler(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod: "OPTIONS",
+		Headers:    map[string]string{"Origin": "https://admin.example.com", "Access-Control-Request-Method": "DELETE"},
+	})
+	if preflight.Headers["Access-Control-Max-Age"] != "600" || preflight.Headers["Access-Control-Allow-Credentials"] != "true" {
+		t.Errorf("Apply: got preflight headers %v", preflight.Headers)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// KeyRequest is the body of a request for a key
+type KeyRequest struct {
+	Name       string   `json:"name"`
+	Sites      []string `json:"sites"`
+	Operations []string `json:"operations"`
+}
+
+var db *dynamodb.DynamoDB
+var keys *apikeys.Table
+var policy *access.Policy
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db, key store & access policy & invokes lambda handler. Keys are only
+// managed by users, so API keys aren't accepted.
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		keys = &apikeys.Table{DB: db, Name: table}
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It creates an API key owned by the caller, for the `operations` (read, write and delete) on the
+// `sites` (site paths) of the request body. Keys act as editors when they allow writes or
+// deletes, and as viewers otherwise, so the caller must have that role in each of the sites. This
+// is the only response that includes the key itself, as `token`, since only its hash is kept.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	principal := auth.FromContext(ctx)
+
+	var keyRequest *KeyRequest
+	err := json.Unmarshal([]byte(request.Body), &keyRequest)
+	if keyRequest == nil || err != nil {
+		log.Println("Error unmarshalling request body into key request:", err)
+		return problemResponse(http.StatusBadRequest, "Request body is not a valid API key"), nil
+	}
+
+	key, err := apikeys.New(principal.Subject, strings.TrimSpace(keyRequest.Name), normalize(keyRequest.Sites), normalize(keyRequest.Operations))
+	if err != nil {
+		log.Println("Error generating API key:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing API key"), nil
+	}
+	if detail := apikeys.Validate(key); detail != "" {
+		return problemResponse(http.StatusBadRequest, detail), nil
+	}
+	// keys reach no further than their owner, so are in the owner's tenant
+	key.Tenant = principal.Tenant
+
+	needed := access.Viewer
+	for _, operation := range key.Operations {
+		if operation != apikeys.Read {
+			needed = access.Editor
+		}
+	}
+
+	for _, site := range key.Sites {
+		role, err := policy.Role(principal, site)
+		if err != nil {
+			log.Println("Error getting member from dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+		}
+		if !role.AtLeast(needed) {
+			return problemResponse(http.StatusForbidden, "Keys for "+site+" need the "+string(needed)+" role in it"), nil
+		}
+
+		exists, err := siteExists(site)
+		if err != nil {
+			log.Println("Error querying site in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error getting site"), nil
+		}
+		if !exists {
+			return problemResponse(http.StatusBadRequest, "No site found at "+site), nil
+		}
+	}
+
+	if err := keys.New(key); err != nil {
+		log.Println("Error putting API key into dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing API key"), nil
+	}
+
+	body, err := json.Marshal(key)
+	if err != nil {
+		log.Println("Error marshalling API key into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing API key"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusCreated,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// siteExists reports whether there's a site, not in the trash, at the path
+func siteExists(sitePath string) (bool, error) {
+	key := expression.Key("type").Equal(expression.Value("site")).And(expression.Key("path").Equal(expression.Value(sitePath)))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return aws.Int64Value(result.Count) > 0, nil
+}
+
+// normalize returns the values trimmed, lowercased, deduplicated & sorted
+func normalize(values []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value != "" && !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var keys *apikeys.Table
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the key store & invokes lambda handler. Keys are only
+// managed by users, so API keys aren't accepted.
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		keys = &apikeys.Table{DB: dynamodb.New(session), Name: table}
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It lists the caller's API keys, revoked ones included, with when they were last used. The keys
+// themselves aren't kept, so aren't listed.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	owned, err := keys.Keys(auth.FromContext(ctx).Subject)
+	if err != nil {
+		log.Println("Error querying API keys in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying API keys"), nil
+	}
+
+	if owned == nil {
+		owned = []apikeys.Key{}
+	}
+
+	body, err := json.Marshal(owned)
+	if err != nil {
+		log.Println("Error marshalling API keys into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing API keys"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var keys *apikeys.Table
+var region, stage, table string
+var noContent bool
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// respond with 204 and no body instead of the revoked key
+	noContent = os.Getenv("DELETE_NO_CONTENT") == "true"
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the key store & invokes lambda handler. Keys are only
+// managed by users, so API keys aren't accepted.
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		keys = &apikeys.Table{DB: dynamodb.New(session), Name: table}
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It revokes one of the caller's API keys, which is refused from then on. Revoked keys are kept,
+// so they're still listed with when they were last used. The revoked key is returned, or 204 No
+// Content when DELETE_NO_CONTENT is set.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	key, err := keys.Key(request.PathParameters["keyid"])
+	if err != nil {
+		log.Println("Error getting API key from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting API key"), nil
+	}
+	if key == nil || key.Owner != auth.FromContext(ctx).Subject {
+		return problemResponse(http.StatusNotFound, "No API key found with that id"), nil
+	}
+
+	// revoking a revoked key leaves it as it was
+	if key.RevokedAt == nil {
+		revokedAt := time.Now()
+		if err := keys.Revoke(key.ID, revokedAt); err != nil {
+			log.Println("Error revoking API key in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error revoking API key"), nil
+		}
+		key.RevokedAt = &revokedAt
+	}
+
+	if noContent {
+		response := Response{
+			StatusCode: http.StatusNoContent,
+		}
+
+		return response, nil
+	}
+
+	body, err := json.Marshal(key)
+	if err != nil {
+		log.Println("Error marshalling API key into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing revoked API key"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// defaultLimit & maxLimit bound how many entries are listed
+const defaultLimit, maxLimit = 50, 500
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var trail *audit.Table
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & audit trail & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		trail = &audit.Table{DB: db, Name: table}
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It lists the site's audit trail newest first. The `page` (a page id) & `actor` query parameters
+// narrow it to their entries, and `from` & `to` (RFC 3339 times) to a time range, from inclusive
+// & to exclusive. Up to `limit` entries are listed.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	// any member may read the site's audit trail, and sites of other tenants have none
+	role, err := policy.Role(auth.FromContext(ctx), request.PathParameters["siteid"])
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Viewer) {
+		return problemResponse(http.StatusForbidden, "Only the site's members may read its audit trail"), nil
+	}
+
+	params := request.QueryStringParameters
+	filter := audit.Filter{TargetID: params["page"], Actor: params["actor"]}
+
+	limit := defaultLimit
+	if value, ok := params["limit"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLimit {
+			return problemResponse(http.StatusBadRequest, "Limit must be a number from 1 to "+strconv.Itoa(maxLimit)), nil
+		}
+		limit = n
+	}
+
+	times := []*time.Time{&filter.From, &filter.To}
+	for i, name := range []string{"from", "to"} {
+		value, ok := params[name]
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return problemResponse(http.StatusBadRequest, "Query parameter "+name+" must be an RFC 3339 time"), nil
+		}
+		*times[i] = t
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return problemResponse(http.StatusBadRequest, "Query parameter from must be before to"), nil
+	}
+
+	entries, err := trail.Query(strings.ToLower(request.PathParameters["siteid"]), filter, limit)
+	if err != nil {
+		log.Println("Error querying audit trail in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying audit trail"), nil
+	}
+
+	body, err := json.Marshal(entries)
+	if err != nil {
+		log.Println("Error marshalling audit entries into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing audit trail"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
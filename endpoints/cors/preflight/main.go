@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+var policy *cors.Policy
+var stage string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	stage = os.Getenv("STAGE")
+
+	log.Println("STAGE:", stage)
+}
+
+// main news up the CORS policy & invokes lambda handler. The policy answers preflight requests
+// itself, so only other OPTIONS requests reach the handler.
+func main() {
+	policy = cors.FromEnv()
+
+	lambda.Start(cors.Apply(policy, Handler))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It answers the OPTIONS requests of every path, since API Gateway only routes a path's OPTIONS
+// requests to one function. Preflight requests are answered by the CORS policy before reaching
+// it, which leaves plain OPTIONS requests, answered with the methods the policy allows.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	response := Response{
+		StatusCode: http.StatusNoContent,
+		Headers: map[string]string{
+			"Allow": strings.Join(policy.Methods, ", ") + ", " + http.MethodOptions,
+		},
+	}
+
+	return response, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var members *access.Table
+var policy *access.Policy
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		members = &access.Table{DB: db, Name: table}
+		policy = access.FromEnv(members)
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It lists the members of the site with the path, with their roles. Any of the site's members
+// may see who the others are.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+
+	role, err := policy.Role(auth.FromContext(ctx), sitePath)
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Viewer) {
+		return problemResponse(http.StatusForbidden, "Only the site's members may list its members"), nil
+	}
+
+	siteMembers, err := members.Members(sitePath)
+	if err != nil {
+		log.Println("Error querying members in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying members"), nil
+	}
+
+	if siteMembers == nil {
+		siteMembers = []access.Member{}
+	}
+
+	body, err := json.Marshal(siteMembers)
+	if err != nil {
+		log.Println("Error marshalling members into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing members"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
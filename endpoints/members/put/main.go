@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// MemberRequest is the body of a request to add or change a member
+type MemberRequest struct {
+	Role access.Role `json:"role"`
+}
+
+var db *dynamodb.DynamoDB
+var members *access.Table
+var policy *access.Policy
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		members = &access.Table{DB: db, Name: table}
+		policy = access.FromEnv(members)
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It gives the user with the (escaped) subject the `role` of the request body in the site with
+// the path, adding them to its members if they aren't one already. Only the site's owners may
+// manage its members, and its last owner can't be demoted.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+	subject, err := url.PathUnescape(request.PathParameters["subject"])
+	if err != nil || strings.TrimSpace(subject) == "" {
+		return problemResponse(http.StatusBadRequest, "Subject is not a valid user"), nil
+	}
+
+	role, err := policy.Role(auth.FromContext(ctx), sitePath)
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Owner) {
+		return problemResponse(http.StatusForbidden, "Only the site's owners may manage its members"), nil
+	}
+
+	var memberRequest *MemberRequest
+	err = json.Unmarshal([]byte(request.Body), &memberRequest)
+	if memberRequest == nil || err != nil {
+		log.Println("Error unmarshalling request body into member request:", err)
+		return problemResponse(http.StatusBadRequest, "Request body is not a valid member"), nil
+	}
+	if !memberRequest.Role.Valid() {
+		return problemResponse(http.StatusBadRequest, "Role must be viewer, author, editor or owner"), nil
+	}
+
+	exists, err := siteExists(sitePath)
+	if err != nil {
+		log.Println("Error querying site in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting site"), nil
+	}
+	if !exists {
+		return problemResponse(http.StatusNotFound, "No site found at "+sitePath), nil
+	}
+
+	siteMembers, err := members.Members(sitePath)
+	if err != nil {
+		log.Println("Error querying members in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying members"), nil
+	}
+	if memberRequest.Role != access.Owner && access.LastOwner(siteMembers, subject) {
+		return problemResponse(http.StatusConflict, "Can't demote the site's last owner"), nil
+	}
+
+	currentTime := time.Now()
+	member := &access.Member{Site: sitePath, Subject: subject, Role: memberRequest.Role, CreatedAt: currentTime, UpdatedAt: currentTime}
+	status := http.StatusCreated
+	for _, existing := range siteMembers {
+		if existing.Subject == subject {
+			member.CreatedAt = existing.CreatedAt
+			status = http.StatusOK
+		}
+	}
+
+	if err := members.Put(member); err != nil {
+		log.Println("Error putting member into dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing member"), nil
+	}
+
+	body, err := json.Marshal(member)
+	if err != nil {
+		log.Println("Error marshalling member into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing member"), nil
+	}
+
+	response := Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// siteExists reports whether there's a site, not in the trash, at the path
+func siteExists(sitePath string) (bool, error) {
+	key := expression.Key("type").Equal(expression.Value("site")).And(expression.Key("path").Equal(expression.Value(sitePath)))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return aws.Int64Value(result.Count) > 0, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
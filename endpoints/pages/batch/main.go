@@ -0,0 +1,752 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/tags"
+	"github.com/google/uuid"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// maxOperations bounds the size of a single batch request, so that reading, writing, indexing &
+// auditing every page fits in the time API Gateway waits for a response
+const maxOperations = 100
+
+// Page defines the fields of the page model
+type Page struct {
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Path        string                 `json:"path"`
+	ParentID    *string                `json:"parentId,omitempty"`
+	Order       int                    `json:"order,omitempty"`
+	Type        string                 `json:"type"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Tags        []string               `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Author      *string                `json:"author,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	ContentType *string                `json:"contentType,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Social      *render.Social         `json:"social,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time             `json:"deletedAt,omitempty"`
+	ExpiresAt   int64                  `json:"expiresAt,omitempty"`
+}
+
+// Operation is a single create, update or delete of a page in a batch request. Updates and
+// deletes name the page by id & version, creates and updates carry the page fields.
+type Operation struct {
+	Op      string `json:"op"`
+	ID      string `json:"id,omitempty"`
+	Version string `json:"version,omitempty"`
+	Page    *Page  `json:"page,omitempty"`
+}
+
+// BatchRequest is the request body of a batch. Atomic batches are written in a single
+// transaction, so either every operation succeeds or none do.
+type BatchRequest struct {
+	Atomic     bool        `json:"atomic,omitempty"`
+	Operations []Operation `json:"operations"`
+}
+
+// Result reports the outcome of one operation, in the same order the operations were sent
+type Result struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	Status  int    `json:"status"`
+	ID      string `json:"id,omitempty"`
+	Version string `json:"version,omitempty"`
+	Page    *Page  `json:"page,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchResponse is the response body of a batch
+type BatchResponse struct {
+	Results []Result `json:"results"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// write is a prepared page waiting to be written for the operation at index
+type write struct {
+	index    int
+	op       string
+	page     *Page
+	previous previous
+}
+
+// previous is what a page was before its operation, for updating the tag & search indexes and
+// the audit trail. It's captured before changes are merged in, since merging reuses the page's
+// slices & maps.
+type previous struct {
+	tags     []string
+	document *search.Document
+	snapshot map[string]interface{}
+}
+
+// ContentType defines the fields of the content type model needed to validate page fields
+type ContentType struct {
+	Name   string          `json:"name"`
+	Schema *content.Schema `json:"schema"`
+}
+
+var db dynamodbiface.DynamoDBAPI
+var policy *access.Policy
+var trail audit.Log
+var index search.Index
+var region, stage, table string
+var retention time.Duration
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// deleted items stay in the trash for TRASH_RETENTION_DAYS before the TTL purges them
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	retention = time.Duration(days) * 24 * time.Hour
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		index = &search.Table{DB: db, Name: table}
+		trail = &audit.Table{DB: db, Name: table}
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It runs a list of page creates, updates & deletes, writing them one at a time (or with
+// TransactWriteItems for atomic batches), and returns a result for every operation. Either way a
+// page is only created where none exists, and only changed while it's there & out of the trash.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+
+	// authors & above may write pages, and each operation checks the pages it changes. Sites in the
+	// trash grant no roles, so no pages are written to them.
+	principal := auth.FromContext(ctx)
+	role, err := policy.Role(principal, sitePath)
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Author) {
+		return problemResponse(http.StatusForbidden, "Only the site's authors, editors and owners may change its pages"), nil
+	}
+
+	var batch BatchRequest
+	err = json.Unmarshal([]byte(request.Body), &batch)
+	if err != nil {
+		log.Println("Error unmarshalling request body into batch:", err)
+		return problemResponse(http.StatusBadRequest, "Request body is not a valid batch"), nil
+	}
+	if len(batch.Operations) == 0 {
+		return problemResponse(http.StatusBadRequest, "Batch has no operations"), nil
+	}
+	if len(batch.Operations) > maxOperations {
+		return problemResponse(http.StatusBadRequest, fmt.Sprintf("Batch has more than %d operations", maxOperations)), nil
+	}
+	if batch.Atomic && len(batch.Operations) > store.MaxWrites {
+		return problemResponse(http.StatusBadRequest, fmt.Sprintf("Atomic batch has more than %d operations", store.MaxWrites)), nil
+	}
+
+	currentTime := time.Now()
+	results := make([]Result, len(batch.Operations))
+	var writes []write
+	failed := false
+	seen := map[string]int{}
+
+	for i, op := range batch.Operations {
+		results[i] = Result{Index: i, Op: op.Op}
+
+		page, before, status, err := prepare(op, sitePath, role, principal, currentTime)
+		if err == nil && page != nil {
+			// DynamoDB rejects a batch touching the same item twice
+			key := page.ID + "@" + page.Version
+			if first, ok := seen[key]; ok {
+				status, err = http.StatusBadRequest, fmt.Errorf("Page is already changed by operation %d", first)
+			}
+			seen[key] = i
+		}
+		if err != nil {
+			results[i].Status = status
+			results[i].Error = err.Error()
+			failed = true
+			continue
+		}
+
+		results[i].Status = status
+		results[i].ID = page.ID
+		results[i].Version = page.Version
+		results[i].Page = page
+		writes = append(writes, write{index: i, op: op.Op, page: page, previous: before})
+	}
+
+	if batch.Atomic {
+		if failed {
+			// nothing is written if any operation of an atomic batch is invalid
+			failWrites(results, writes, http.StatusFailedDependency, "Not attempted because another operation failed")
+		} else if err := transactWrite(sitePath, writes); err == store.ErrTooManyWrites {
+			failWrites(results, writes, http.StatusBadRequest, "Atomic batch changes too many pages and tags for a single transaction")
+		} else if err != nil {
+			log.Println("Error writing batch transaction to dynamodb:", err)
+			failWrites(results, writes, http.StatusConflict, "Transaction cancelled: "+err.Error())
+		}
+	} else {
+		writeChunks(sitePath, writes, results)
+	}
+	indexSearch(sitePath, writes, results)
+	auditWrites(request.RequestContext, writes, results)
+
+	body, err := json.Marshal(BatchResponse{Results: results})
+	if err != nil {
+		log.Println("Error marshalling results into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing batch results"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// prepare validates an operation and returns the page as it should be written, what it was
+// before, and the status to report. Pages must be in the site, and the principal with the role
+// allowed to change them.
+func prepare(op Operation, sitePath string, role access.Role, principal *auth.Principal, currentTime time.Time) (*Page, previous, int, error) {
+	switch op.Op {
+	case "create":
+		page := op.Page
+		if page == nil || strings.TrimSpace(page.Path) == "" {
+			return nil, previous{}, http.StatusBadRequest, errors.New("Can't create page without path")
+		}
+		page.Path = strings.ToLower(page.Path)
+		if access.SiteOf(page.Path) != sitePath {
+			return nil, previous{}, http.StatusBadRequest, errors.New("Can't create page outside of its site's path")
+		}
+		if page.Author == nil && role == access.Author {
+			page.Author = aws.String(principal.Subject)
+		}
+		if !access.CanEditPage(role, principal, page.Author) {
+			return nil, previous{}, http.StatusForbidden, errors.New("Authors may only create pages they're the author of")
+		}
+
+		if err := content.Validate(page.Blocks); err != nil {
+			return nil, previous{}, http.StatusBadRequest, err
+		}
+		if err := page.Social.Validate(); err != nil {
+			return nil, previous{}, http.StatusBadRequest, err
+		}
+		if status, err := validateFields(sitePath, page); err != nil {
+			return nil, previous{}, status, err
+		}
+		tagged, err := tags.Normalize(page.Tags, page.Keywords)
+		if err != nil {
+			return nil, previous{}, http.StatusBadRequest, err
+		}
+		// a child page lives directly under its parent's path, like pages/create checks
+		if page.ParentID != nil {
+			parent, err := latestPage(*page.ParentID)
+			if err != nil {
+				log.Println("Error getting parent page from dynamodb:", err)
+				return nil, previous{}, http.StatusInternalServerError, errors.New("Error getting parent page")
+			}
+			if parent == nil {
+				return nil, previous{}, http.StatusBadRequest, errors.New("Can't create page under missing parent")
+			}
+			if path.Dir(page.Path) != parent.Path {
+				return nil, previous{}, http.StatusBadRequest, errors.New("Can't create page outside of its parent's path")
+			}
+		}
+
+		page.ID = uuid.New().String()
+		page.Version = uuid.New().String()
+		page.Type = "page"
+		page.CreatedAt = currentTime
+		page.UpdatedAt = currentTime
+		page.DeletedAt = nil
+		page.ExpiresAt = 0
+		page.Tags = tagged
+		page.Keywords = tags.Keywords(tagged)
+
+		return page, previous{}, http.StatusCreated, nil
+
+	case "update":
+		if op.Page == nil {
+			return nil, previous{}, http.StatusBadRequest, errors.New("Can't update page without changes")
+		}
+		original, status, err := editablePage(op.ID, op.Version, sitePath, role, principal)
+		if err != nil {
+			return nil, previous{}, status, err
+		}
+
+		// combine original page with requested changes
+		changes := op.Page
+		changes.ID = original.ID
+		changes.Version = original.Version //TODO: new version
+		changes.Type = "page"
+		if len(changes.Path) == 0 {
+			changes.Path = original.Path
+		}
+		// changing the path here would break inbound links & child paths, so it goes through move
+		if strings.ToLower(changes.Path) != original.Path {
+			return nil, previous{}, http.StatusBadRequest, errors.New("Can't change page path with update, move the page instead")
+		}
+		changes.CreatedAt = original.CreatedAt
+		changes.UpdatedAt = currentTime
+		changes.DeletedAt = nil // deleting & restoring go through their own endpoints
+		changes.ParentID = nil  // moving goes through its own endpoint, which keeps child paths consistent
+		changes.ExpiresAt = 0
+		// sending tags or keywords replaces the page's tags, with tags taking precedence
+		before := previous{tags: original.Tags, document: document(original), snapshot: audit.Snapshot(original)}
+		changedTags, changedKeywords := changes.Tags, changes.Keywords
+		updated, err := mergePages(original, changes)
+		if err != nil {
+			return nil, previous{}, http.StatusBadRequest, err
+		}
+		if changedTags != nil || changedKeywords != nil {
+			updated.Tags, updated.Keywords = changedTags, changedKeywords
+		}
+		updated.Tags, err = tags.Normalize(updated.Tags, updated.Keywords)
+		if err != nil {
+			return nil, previous{}, http.StatusBadRequest, err
+		}
+		updated.Keywords = tags.Keywords(updated.Tags)
+		// fields set to null in the changes are removed
+		for name, value := range updated.Fields {
+			if value == nil {
+				delete(updated.Fields, name)
+			}
+		}
+		if err := content.Validate(updated.Blocks); err != nil {
+			return nil, previous{}, http.StatusBadRequest, err
+		}
+		if err := updated.Social.Validate(); err != nil {
+			return nil, previous{}, http.StatusBadRequest, err
+		}
+		if status, err := validateFields(sitePath, updated); err != nil {
+			return nil, previous{}, status, err
+		}
+		// authors can't give their pages to someone else
+		if !access.CanEditPage(role, principal, updated.Author) {
+			return nil, previous{}, http.StatusForbidden, errors.New("Authors may only change pages they're the author of")
+		}
+
+		return updated, before, http.StatusOK, nil
+
+	case "delete":
+		page, status, err := editablePage(op.ID, op.Version, sitePath, role, principal)
+		if err != nil {
+			return nil, previous{}, status, err
+		}
+		before := previous{tags: page.Tags, document: document(page), snapshot: audit.Snapshot(page)}
+
+		// deleted pages are moved to the trash, like pages/delete does
+		deletedAt := currentTime
+		page.DeletedAt = &deletedAt
+		page.ExpiresAt = deletedAt.Add(retention).Unix()
+
+		return page, before, http.StatusOK, nil
+	}
+
+	return nil, previous{}, http.StatusBadRequest, fmt.Errorf("Unknown operation %q", op.Op)
+}
+
+// getPage returns the page version, or the status & error to report when it can't be changed
+func getPage(id, version string) (*Page, int, error) {
+	var page Page
+
+	if id == "" || version == "" {
+		return nil, http.StatusBadRequest, errors.New("Page id and version are required")
+	}
+
+	key := map[string]*dynamodb.AttributeValue{
+		"id":      {S: aws.String(id)},
+		"version": {S: aws.String(version)},
+	}
+
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		Key:       key,
+		TableName: aws.String(table),
+	})
+	if err != nil {
+		log.Println("Error getting page from dynamodb:", err)
+		return nil, http.StatusInternalServerError, errors.New("Error getting page")
+	}
+
+	err = dynamodbattribute.UnmarshalMap(result.Item, &page)
+	if err != nil {
+		log.Println("Error unmarshalling into page:", err)
+		return nil, http.StatusInternalServerError, errors.New("Error reading page")
+	}
+
+	if page.ID == "" || page.Type != "page" || page.DeletedAt != nil {
+		return nil, http.StatusNotFound, errors.New("No page found with that id and version")
+	}
+
+	return &page, http.StatusOK, nil
+}
+
+// latestPage returns the most recently updated version of the page that isn't in the trash, or nil
+// when there's none
+func latestPage(id string) (*Page, error) {
+	var pages []Page
+
+	key := expression.Key("id").Equal(expression.Value(id))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("id-updatedAt-index"),
+		ScanIndexForward:          aws.Bool(false),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(results.Items, &pages)
+	if err != nil || len(pages) == 0 || pages[0].Type != "page" {
+		return nil, err
+	}
+
+	return &pages[0], nil
+}
+
+// editablePage returns the page version when it's in the site and the principal with the role
+// may change it, or the status & error to report when it can't be changed
+func editablePage(id, version, sitePath string, role access.Role, principal *auth.Principal) (*Page, int, error) {
+	page, status, err := getPage(id, version)
+	if err != nil {
+		return nil, status, err
+	}
+	if access.SiteOf(page.Path) != sitePath {
+		return nil, http.StatusNotFound, errors.New("No page found with that id and version")
+	}
+	if !access.CanEditPage(role, principal, page.Author) {
+		return nil, http.StatusForbidden, errors.New("Only the site's editors and the page's author may change it")
+	}
+
+	return page, http.StatusOK, nil
+}
+
+// transactWrite puts every page and the changes to its tag index items in a single transaction,
+// which is cancelled if a created page already exists or a changed page was deleted in the meantime
+func transactWrite(sitePath string, writes []write) error {
+	work := store.NewUnitOfWork(db, table)
+	for _, w := range writes {
+		if err := addWrite(work, sitePath, w); err != nil {
+			return err
+		}
+	}
+
+	return work.Commit()
+}
+
+// writeChunks writes the pages of a batch that isn't atomic, together with the changes to their
+// tag index items, in as few transactions as they fit in. When a transaction is cancelled, its
+// pages are written one at a time, so only those whose conditions failed are reported. It fails
+// the results of the writes that couldn't be made.
+func writeChunks(sitePath string, writes []write, results []Result) {
+	var chunk []write
+	work := store.NewUnitOfWork(db, table)
+	pages := map[string]bool{}
+	flush := func() {
+		err := work.Commit()
+		if err == store.ErrConflict {
+			writeEach(sitePath, chunk, results)
+		} else if err != nil {
+			log.Println("Error writing batch chunk to dynamodb:", err)
+			failWrites(results, chunk, http.StatusServiceUnavailable, "Error writing page")
+		}
+		chunk, work, pages = nil, store.NewUnitOfWork(db, table), map[string]bool{}
+	}
+
+	for _, w := range writes {
+		single := store.NewUnitOfWork(db, table)
+		if err := addWrite(single, sitePath, w); err != nil {
+			log.Println("Error marshalling page into dynamodb attribute:", err)
+			failWrites(results, []write{w}, http.StatusInternalServerError, "Error marshalling page")
+			continue
+		}
+		if single.Len() > store.MaxWrites {
+			failWrites(results, []write{w}, http.StatusBadRequest, "Page changes too many tags for a single transaction")
+			continue
+		}
+		// versions of a page share its tag index items, which a transaction can't touch twice
+		if work.Len()+single.Len() > store.MaxWrites || pages[w.page.ID] {
+			flush()
+		}
+		work.Merge(single)
+		chunk = append(chunk, w)
+		pages[w.page.ID] = true
+	}
+	flush()
+}
+
+// writeEach writes the pages one at a time, each with the changes to its tag index items, and
+// fails the results of those that couldn't be written
+func writeEach(sitePath string, writes []write, results []Result) {
+	for _, w := range writes {
+		conflict := "Page was deleted in the meantime"
+		if w.op == "create" {
+			conflict = "Page already exists"
+		}
+
+		work := store.NewUnitOfWork(db, table)
+		err := addWrite(work, sitePath, w)
+		if err == nil {
+			err = work.Commit()
+		}
+		if err == store.ErrConflict {
+			failWrites(results, []write{w}, http.StatusConflict, conflict)
+		} else if err != nil {
+			log.Println("Error writing page to dynamodb:", err)
+			failWrites(results, []write{w}, http.StatusServiceUnavailable, "Error writing page")
+		}
+	}
+}
+
+// addWrite adds the write of the page to the unit of work, along with the changes to its tag
+// index items. A created page mustn't exist yet, and a changed page must still be there & out of
+// the trash, so an update can't bring back a page deleted in the meantime.
+func addWrite(work *store.UnitOfWork, sitePath string, w write) error {
+	var err error
+	if w.op == "create" {
+		err = work.Create(w.page)
+	} else {
+		err = work.Replace(w.page)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tags.Index(work, sitePath, w.page.ID, w.page.Version, w.page.UpdatedAt, w.previous.tags, current(w))
+}
+
+// indexSearch updates the search index for every page that was written. The pages are already
+// written, so failures are logged rather than reported.
+func indexSearch(sitePath string, writes []write, results []Result) {
+	for _, w := range writes {
+		if results[w.index].Error != "" {
+			continue
+		}
+
+		var doc *search.Document
+		if w.op != "delete" {
+			doc = document(w.page)
+		}
+		if err := search.Update(index, sitePath, w.previous.document, doc); err != nil {
+			log.Println("Error indexing page", w.page.ID, "for search:", err)
+		}
+	}
+}
+
+// auditWrites appends an entry to the audit trail for every page that was written. The pages are
+// already written, so failures are logged rather than reported.
+func auditWrites(requestContext events.APIGatewayProxyRequestContext, writes []write, results []Result) {
+	for _, w := range writes {
+		if results[w.index].Error != "" {
+			continue
+		}
+
+		// the batch operations are named for the actions they take
+		target := audit.Target{Type: "page", ID: w.page.ID, Version: w.page.Version, Path: w.page.Path}
+		entry := audit.NewEntry(requestContext, audit.Action(w.op), target, w.previous.snapshot, w.page)
+		if err := trail.Append(entry); err != nil {
+			log.Println("Error appending page", w.page.ID, "to audit trail:", err)
+		}
+	}
+}
+
+// current returns the tags the page should be indexed under once written, which is none for
+// pages going in the trash
+func current(w write) []string {
+	if w.op == "delete" {
+		return nil
+	}
+
+	return w.page.Tags
+}
+
+// failWrites marks the results of the writes as failed
+func failWrites(results []Result, writes []write, status int, message string) {
+	for _, w := range writes {
+		results[w.index].Status = status
+		results[w.index].Error = message
+		results[w.index].Page = nil
+	}
+}
+
+// mergePages merges two structs by serializing the struct with the changes to JSON, then
+// deserializes the changes into the original
+func mergePages(original, changes *Page) (*Page, error) {
+	// serialize changes to JSON
+	changeJSON, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	// deserialize the "changes" page struct into the original
+	err = json.Unmarshal(changeJSON, &original)
+	if err != nil {
+		return nil, err
+	}
+
+	return original, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
+
+// validateFields checks the page's custom fields against the schema of its content type,
+// returning the status to respond with when they don't pass
+func validateFields(sitePath string, page *Page) (int, error) {
+	if page.ContentType == nil {
+		if len(page.Fields) > 0 {
+			return http.StatusBadRequest, errors.New("Page fields need a content type")
+		}
+		return http.StatusOK, nil
+	}
+
+	contentType, err := getContentType(sitePath, *page.ContentType)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if contentType == nil {
+		return http.StatusBadRequest, fmt.Errorf("Unknown content type %q", *page.ContentType)
+	}
+	if err := contentType.Schema.Validate(page.Fields); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// getContentType returns the site's content type with the name, or nil if there isn't one
+func getContentType(sitePath, name string) (*ContentType, error) {
+	var contentTypes []ContentType
+
+	key := expression.Key("type").Equal(expression.Value("contentType")).And(expression.Key("path").Equal(expression.Value(strings.ToLower(sitePath))))
+	// content types in the trash can't be used for pages
+	filter := expression.Name("name").Equal(expression.Value(name)).And(expression.AttributeNotExists(expression.Name("deletedAt")))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &contentTypes)
+	if err != nil || len(contentTypes) == 0 {
+		return nil, err
+	}
+
+	return &contentTypes[0], nil
+}
+
+// document returns the text of the page that is indexed for search
+func document(page *Page) *search.Document {
+	return &search.Document{
+		ID:          page.ID,
+		Version:     page.Version,
+		Name:        aws.StringValue(page.Name),
+		Description: aws.StringValue(page.Description),
+		Keywords:    aws.StringValue(page.Keywords),
+		Content:     search.Text(page.Blocks, page.Fields),
+	}
+}
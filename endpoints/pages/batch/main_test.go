@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+)
+
+func TestAtomicBatch(t *testing.T) {
+	memory := setupSite(t)
+
+	// one invalid operation leaves the whole batch unwritten
+	results := invokeBatchHandler(t, BatchRequest{Atomic: true, Operations: []Operation{
+		{Op: "create", Page: &Page{Path: "acme/new", Name: aws.String("New")}},
+		{Op: "update", ID: "page-1", Version: "p1", Page: &Page{Name: aws.String("Changed")}},
+		{Op: "create", Page: &Page{Path: "globex/new"}},
+	}})
+	wantStatuses(t, results, http.StatusFailedDependency, http.StatusFailedDependency, http.StatusBadRequest)
+	if page := getItem(t, memory, "page-1", "p1"); aws.StringValue(page.Name) != "About" || len(memory.Items()) != 4 {
+		t.Errorf("Batch Handler: got page %+v & %d items; wanted nothing written", page, len(memory.Items()))
+	}
+
+	results = invokeBatchHandler(t, BatchRequest{Atomic: true, Operations: []Operation{
+		{Op: "create", Page: &Page{Path: "acme/new", Name: aws.String("New"), Tags: []string{"News"}}},
+		{Op: "update", ID: "page-1", Version: "p1", Page: &Page{Name: aws.String("Changed")}},
+	}})
+	wantStatuses(t, results, http.StatusCreated, http.StatusOK)
+	if page := getItem(t, memory, "page-1", "p1"); aws.StringValue(page.Name) != "Changed" {
+		t.Errorf("Batch Handler: got page %+v; wanted it changed", page)
+	}
+	if created := getItem(t, memory, results[0].ID, results[0].Version); created.Path != "acme/new" || len(created.Tags) != 1 {
+		t.Errorf("Batch Handler: got created page %+v; wanted it written with its tag", created)
+	}
+	if tagged := countType(memory, "tag"); tagged != 1 {
+		t.Errorf("Batch Handler: got %d tag items; wanted the created page's", tagged)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	memory := setupSite(t)
+
+	// each operation of a batch that isn't atomic is written whatever happens to the rest
+	results := invokeBatchHandler(t, BatchRequest{Operations: []Operation{
+		{Op: "create", Page: &Page{Path: "acme/new", Name: aws.String("New")}},
+		{Op: "delete", ID: "page-1", Version: "missing"},
+		{Op: "create", Page: &Page{Path: "acme/about/child", ParentID: aws.String("missing")}},
+		{Op: "delete", ID: "page-1", Version: "p1"},
+	}})
+	wantStatuses(t, results, http.StatusCreated, http.StatusNotFound, http.StatusBadRequest, http.StatusOK)
+	if created := getItem(t, memory, results[0].ID, results[0].Version); created.Path != "acme/new" {
+		t.Errorf("Batch Handler: got created page %+v; wanted it written", created)
+	}
+	if deleted := getItem(t, memory, "page-1", "p1"); deleted.DeletedAt == nil || deleted.ExpiresAt == 0 {
+		t.Errorf("Batch Handler: got deleted page %+v; wanted it in the trash", deleted)
+	}
+	if postings, _ := index.Postings("acme", "about"); len(postings) != 0 {
+		t.Errorf("Batch Handler: got postings %+v; wanted the deleted page out of the search index", postings)
+	}
+
+	// pages in the trash aren't changed again
+	results = invokeBatchHandler(t, BatchRequest{Operations: []Operation{
+		{Op: "update", ID: "page-1", Version: "p1", Page: &Page{Name: aws.String("Changed")}},
+	}})
+	wantStatuses(t, results, http.StatusNotFound)
+}
+
+// setupSite points the handler at a memory store holding the acme site, edited by user-1, with
+// its about page
+func setupSite(t *testing.T) *store.Memory {
+	memory := store.NewMemory()
+	db = memory
+	table = "go-lambda-dynamo"
+	policy = &access.Policy{Store: &access.Table{DB: memory, Name: table}}
+	trail = &audit.Memory{}
+	index = search.NewMemory()
+
+	put(t, memory, map[string]interface{}{"id": "site-1", "version": "v1", "type": "site", "path": "acme", "tenant": "acme-corp"})
+	put(t, memory, access.Item(&access.Member{Site: "acme", Subject: "user-1", Role: access.Editor}))
+	put(t, memory, access.Reservation("acme", "site-1"))
+	put(t, memory, Page{ID: "page-1", Version: "p1", Type: "page", Path: "acme/about", Name: aws.String("About")})
+	search.Update(index, "acme", nil, &search.Document{ID: "page-1", Version: "p1", Name: "About"})
+
+	return memory
+}
+
+// invokeBatchHandler sends the batch to the handler as user-1, returning the results
+func invokeBatchHandler(t *testing.T, batch BatchRequest) []Result {
+	body, _ := json.Marshal(batch)
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Subject: "user-1", Method: "jwt", Tenant: "acme-corp"})
+	response, err := Handler(ctx, Request{PathParameters: map[string]string{"siteid": "acme"}, Body: string(body)})
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Batch Handler: got code %v, error %v; wanted %v", response.StatusCode, err, http.StatusOK)
+	}
+
+	var out BatchResponse
+	if err := json.Unmarshal([]byte(response.Body), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.Results
+}
+
+// wantStatuses checks the results have the statuses, in order
+func wantStatuses(t *testing.T, results []Result, statuses ...int) {
+	t.Helper()
+	if len(results) != len(statuses) {
+		t.Fatalf("Batch Handler: got %d results; wanted %d", len(results), len(statuses))
+	}
+	for i, status := range statuses {
+		if results[i].Status != status {
+			t.Errorf("Batch Handler: got status %d (%s) for operation %d; wanted %d", results[i].Status, results[i].Error, i, status)
+		}
+	}
+}
+
+// getItem returns the page stored with the id & version
+func getItem(t *testing.T, memory *store.Memory, id, version string) Page {
+	var page Page
+	result, err := memory.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id":      {S: aws.String(id)},
+			"version": {S: aws.String(version)},
+		},
+		TableName: aws.String(table),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamodbattribute.UnmarshalMap(result.Item, &page)
+
+	return page
+}
+
+// countType returns the number of items of the type in the memory store
+func countType(memory *store.Memory, itemType string) int {
+	count := 0
+	for _, item := range memory.Items() {
+		if item["type"] != nil && aws.StringValue(item["type"].S) == itemType {
+			count++
+		}
+	}
+
+	return count
+}
+
+// put adds the item to the memory store
+func put(t *testing.T, memory *store.Memory, item interface{}) {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memory.PutItem(&dynamodb.PutItemInput{Item: av, TableName: aws.String(table)}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"strings"
 	"time"
 
@@ -16,29 +18,65 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/tags"
 	"github.com/google/uuid"
 )
 
-type Response events.APIGatewayProxyResponse
-type Request events.APIGatewayProxyRequest
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
 
 // Page defines the fields of the page model
 type Page struct {
-	ID          string    `json:"id"`
-	Version     string    `json:"version"`
-	Path        string    `json:"path"`
-	Type        string    `json:"type"`
-	Name        *string   `json:"name,omitempty"`
-	Description *string   `json:"description,omitempty"`
-	Keywords    *string   `json:"keywords,omitempty"`
-	Author      *string   `json:"author,omitempty"`
-	CreatedAt   time.Time `json:"createdAt,omitempty"`
-	UpdatedAt   time.Time `json:"updatedAt,omitempty"`
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Path        string                 `json:"path"`
+	ParentID    *string                `json:"parentId,omitempty"`
+	Order       int                    `json:"order,omitempty"`
+	Type        string                 `json:"type"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Tags        []string               `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Author      *string                `json:"author,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	ContentType *string                `json:"contentType,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Social      *render.Social         `json:"social,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time             `json:"deletedAt,omitempty"`
+	ExpiresAt   int64                  `json:"expiresAt,omitempty"`
+}
+
+// ContentType defines the fields of the content type model needed to validate page fields
+type ContentType struct {
+	Name   string          `json:"name"`
+	Schema *content.Schema `json:"schema"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
 }
 
 var db *dynamodb.DynamoDB
+var policy *access.Policy
+var trail audit.Log
+var index search.Index
 var region, stage, table string
-var currentTime time.Time
 
 func init() {
 	// Enable line numbers in log output, but remove date/time
@@ -47,7 +85,6 @@ func init() {
 	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
 	stage = os.Getenv("STAGE")
 	table = os.Getenv("TABLE_NAME")
-	currentTime = time.Now()
 
 	log.Println("AWS_REGION:", region)
 	log.Println("STAGE:", stage)
@@ -56,74 +93,265 @@ func init() {
 	// TODO: validate env vars
 }
 
-// main starts the session, news up the db & invokes lambda handler
+// main starts the session, news up the db & access policy & invokes lambda handler
 func main() {
 	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
 	if err != nil {
 		log.Println("Failed to connect to AWS:", err)
 	} else {
 		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		trail = &audit.Table{DB: db, Name: table}
+		index = &search.Table{DB: db, Name: table}
 	}
 
-	lambda.Start(Handler)
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
 }
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call in main()
 func Handler(ctx context.Context, request Request) (Response, error) {
 	// TODO: what to do with the site id?
-	// siteid := request.PathParameters["siteid"]
+	sitePath := request.PathParameters["siteid"]
 
 	var page *Page
 	err := json.Unmarshal([]byte(request.Body), &page)
-	if err != nil {
-		log.Println("Error unmarshalling request body into page")
-		return Response{StatusCode: http.StatusBadRequest}, err
+	if page == nil || err != nil {
+		log.Println("Error unmarshalling request body into page:", err)
+		return problemResponse(http.StatusBadRequest, "Invalid page in request body"), nil
 	}
-	log.Println(page)
 	if strings.TrimSpace(page.Path) == "" {
 		log.Println("Can't create page without path")
-		return Response{StatusCode: http.StatusBadRequest}, errors.New("Can't create page without path")
+		return problemResponse(http.StatusBadRequest, "Can't create page without path"), nil
+	}
+
+	page.Path = strings.ToLower(page.Path)
+	sitePath = strings.ToLower(sitePath)
+	if page.Path != sitePath && !strings.HasPrefix(page.Path, sitePath+"/") {
+		log.Println("Can't create page outside of its site's path")
+		return problemResponse(http.StatusBadRequest, "Can't create page outside of its site's path"), nil
+	}
+
+	// authors & above may create pages, but authors only pages they're the author of. Sites in the
+	// trash grant no roles, so no pages are created in them.
+	principal := auth.FromContext(ctx)
+	role, err := policy.Role(principal, sitePath)
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Author) {
+		return problemResponse(http.StatusForbidden, "Only the site's authors may create pages"), nil
+	}
+	if page.Author == nil && role == access.Author {
+		page.Author = aws.String(principal.Subject)
+	}
+	if !access.CanEditPage(role, principal, page.Author) {
+		return problemResponse(http.StatusForbidden, "Only the site's editors may create pages for other authors"), nil
+	}
+
+	if err := content.Validate(page.Blocks); err != nil {
+		log.Println("Invalid page content:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if err := page.Social.Validate(); err != nil {
+		log.Println("Invalid social metadata:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if status, err := validateFields(sitePath, page); err != nil {
+		log.Println("Invalid page fields:", err)
+		if status == http.StatusInternalServerError {
+			return problemResponse(status, "Error getting content type"), nil
+		}
+		return problemResponse(status, err.Error()), nil
+	}
+	page.Tags, err = tags.Normalize(page.Tags, page.Keywords)
+	if err != nil {
+		log.Println("Invalid tags:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	page.Keywords = tags.Keywords(page.Tags)
+
+	// a child page lives directly under its parent's path
+	if page.ParentID != nil {
+		parent, err := latestPage(*page.ParentID)
+		if err != nil {
+			log.Println("Error getting parent page from dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error getting parent page"), nil
+		}
+		if parent == nil {
+			log.Println("Can't create page under missing parent")
+			return problemResponse(http.StatusBadRequest, "Can't create page under missing parent"), nil
+		}
+		if path.Dir(page.Path) != parent.Path {
+			log.Println("Can't create page outside of its parent's path")
+			return problemResponse(http.StatusBadRequest, "Can't create page outside of its parent's path"), nil
+		}
 	}
 
+	currentTime := time.Now()
 	page.ID = uuid.New().String()
 	page.Version = uuid.New().String()
-	page.Path = strings.ToLower(page.Path)
 	page.Type = "page"
 	page.CreatedAt = currentTime
 	page.UpdatedAt = currentTime
+	page.DeletedAt = nil
+	page.ExpiresAt = 0
 
-	av, err := dynamodbattribute.MarshalMap(page)
+	// the page is written together with its tag index items, so tag queries find it right away
+	work := store.NewUnitOfWork(db, table)
+	if err = work.Put(page); err == nil {
+		err = tags.Index(work, sitePath, page.ID, page.Version, currentTime, nil, page.Tags)
+	}
+	if err == nil {
+		err = work.Commit()
+	}
 	if err != nil {
-		log.Println("Error marshalling page into dynamodb attribute")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error writing page & tags transaction to DynamoDB:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing page"), nil
 	}
 
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(table),
+	// the page is already written, so failing to index & audit it is logged rather than reported
+	if err := search.Update(index, sitePath, nil, document(page)); err != nil {
+		log.Println("Error indexing page for search:", err)
 	}
-
-	_, err = db.PutItem(input)
-	if err != nil {
-		log.Println("Error putting item into DyanmoDB")
-		return Response{StatusCode: http.StatusBadRequest}, err
+	target := audit.Target{Type: "page", ID: page.ID, Version: page.Version, Path: page.Path}
+	if err := trail.Append(audit.NewEntry(request.RequestContext, audit.Create, target, nil, page)); err != nil {
+		log.Println("Error appending to audit trail:", err)
 	}
 
 	body, err := json.Marshal(page)
 	if err != nil {
-		log.Println("Error marshalling page into json for response body")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error marshalling page into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing page"), nil
 	}
 
 	response := Response{
 		StatusCode: http.StatusOK,
 		Body:       string(body),
 		Headers: map[string]string{
-			"Content-Type":                     "application/json",
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Credentials": "true",
+			"Content-Type": "application/json",
 		},
 	}
 
 	return response, nil
 }
+
+// latestPage returns the most recently updated version of the page that isn't in the trash, or
+// nil when there is none
+func latestPage(id string) (*Page, error) {
+	var pages []Page
+
+	key := expression.Key("id").Equal(expression.Value(id))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("id-updatedAt-index"),
+		ScanIndexForward:          aws.Bool(false),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(results.Items, &pages)
+	if err != nil || len(pages) == 0 || pages[0].Type != "page" {
+		return nil, err
+	}
+
+	return &pages[0], nil
+}
+
+// validateFields checks the page's custom fields against the schema of its content type,
+// returning the status to respond with when they don't pass
+func validateFields(sitePath string, page *Page) (int, error) {
+	if page.ContentType == nil {
+		if len(page.Fields) > 0 {
+			return http.StatusBadRequest, errors.New("Page fields need a content type")
+		}
+		return http.StatusOK, nil
+	}
+
+	contentType, err := getContentType(sitePath, *page.ContentType)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if contentType == nil {
+		return http.StatusBadRequest, fmt.Errorf("Unknown content type %q", *page.ContentType)
+	}
+	if err := contentType.Schema.Validate(page.Fields); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// getContentType returns the site's content type with the name, or nil if there isn't one
+func getContentType(sitePath, name string) (*ContentType, error) {
+	var contentTypes []ContentType
+
+	key := expression.Key("type").Equal(expression.Value("contentType")).And(expression.Key("path").Equal(expression.Value(strings.ToLower(sitePath))))
+	// content types in the trash can't be used for pages
+	filter := expression.Name("name").Equal(expression.Value(name)).And(expression.AttributeNotExists(expression.Name("deletedAt")))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &contentTypes)
+	if err != nil || len(contentTypes) == 0 {
+		return nil, err
+	}
+
+	return &contentTypes[0], nil
+}
+
+// document returns the text of the page that is indexed for search
+func document(page *Page) *search.Document {
+	return &search.Document{
+		ID:          page.ID,
+		Version:     page.Version,
+		Name:        aws.StringValue(page.Name),
+		Description: aws.StringValue(page.Description),
+		Keywords:    aws.StringValue(page.Keywords),
+		Content:     search.Text(page.Blocks, page.Fields),
+	}
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -2,47 +2,76 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/tags"
 )
 
-type Response events.APIGatewayProxyResponse
-type Request events.APIGatewayProxyRequest
-type SiteStatus int
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
 
-const (
-	Unpublished SiteStatus = iota
-	Published
-)
+// Page defines the fields of the page model
+type Page struct {
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Path        string                 `json:"path"`
+	ParentID    *string                `json:"parentId,omitempty"`
+	Order       int                    `json:"order,omitempty"`
+	Type        string                 `json:"type"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Tags        []string               `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Author      *string                `json:"author,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	ContentType *string                `json:"contentType,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Social      *render.Social         `json:"social,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time             `json:"deletedAt,omitempty"`
+	ExpiresAt   int64                  `json:"expiresAt,omitempty"`
+}
 
-// Site defines the fields of the site model
-type Site struct {
-	ID           string     `json:"id"`
-	Version      string     `json:"version"`
-	Path         string     `json:"path"`
-	Type         string     `json:"type"`
-	Status       SiteStatus `json:"status,omitempty"`
-	Name         *string    `json:"name,omitempty"`
-	Description  *string    `json:"description,omitempty"`
-	Keywords     *string    `json:"keywords,omitempty"`
-	URL          *string    `json:"url,omitempty"`
-	TagManagerID *string    `json:"tagManagerId,omitempty"`
-	CardImageURL *string    `json:"cardImageUrl,omitempty"`
-	CreatedAt    time.Time  `json:"createdAt,omitempty"`
-	UpdatedAt    time.Time  `json:"updatedAt,omitempty"`
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
 }
 
 var db *dynamodb.DynamoDB
+var policy *access.Policy
+var trail audit.Log
+var index search.Index
 var region, stage, table string
 var currentTime time.Time
+var retention time.Duration
+var noContent bool
 
 func init() {
 	// Enable line numbers in log output, but remove date/time
@@ -56,24 +85,42 @@ func init() {
 	log.Println("STAGE:", stage)
 	log.Println("TABLE_NAME:", table)
 
+	// deleted items stay in the trash for TRASH_RETENTION_DAYS before the TTL purges them
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	retention = time.Duration(days) * 24 * time.Hour
+
+	// respond with 204 and no body instead of the deleted page
+	noContent = os.Getenv("DELETE_NO_CONTENT") == "true"
+
 	// TODO: validate env vars
 }
 
-// main starts the session, news up the db & invokes lambda handler
+// main starts the session, news up the db & access policy & invokes lambda handler
 func main() {
 	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
 	if err != nil {
 		log.Println("Failed to connect to AWS:", err)
 	} else {
 		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		trail = &audit.Table{DB: db, Name: table}
+		index = &search.Table{DB: db, Name: table}
 	}
 
-	lambda.Start(Handler)
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
 }
 
-// Handler is our lambda handler invoked by the `lambda.Start` function call in main()
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// The page version is moved to the trash by stamping it with `deletedAt`, and is purged by
+// the table's TTL on `expiresAt` once the retention period has passed. The deleted page is
+// returned, or 204 No Content when DELETE_NO_CONTENT is set.
 func Handler(ctx context.Context, request Request) (Response, error) {
-	// siteid := aws.String(request.PathParameters["siteid"])
+	var page Page
+
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
 	pageid := aws.String(request.PathParameters["pageid"])
 	version := aws.String(request.QueryStringParameters["version"])
 
@@ -84,25 +131,141 @@ func Handler(ctx context.Context, request Request) (Response, error) {
 		"version": {S: version},
 	}
 
-	log.Println(key)
-
-	_, err := db.DeleteItem(&dynamodb.DeleteItemInput{
+	// editors may delete any page, and authors their own
+	current, err := db.GetItem(&dynamodb.GetItemInput{
 		Key:       key,
 		TableName: aws.String(table),
 	})
 	if err != nil {
-		return Response{StatusCode: 500}, err // TODO: decide what's the correct status
+		log.Println("Error getting page from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting page"), nil
+	}
+	if err := dynamodbattribute.UnmarshalMap(current.Item, &page); err != nil {
+		log.Println("Error unmarshalling into page:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading page"), nil
+	}
+	if page.ID == "" || page.Type != "page" || page.DeletedAt != nil || access.SiteOf(page.Path) != sitePath {
+		return problemResponse(http.StatusNotFound, "No page found with that id and version"), nil
+	}
+	principal := auth.FromContext(ctx)
+	role, err := policy.Role(principal, access.SiteOf(page.Path))
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !access.CanEditPage(role, principal, page.Author) {
+		return problemResponse(http.StatusForbidden, "Only the site's editors and the page's author may delete it"), nil
+	}
+
+	deletedAt := time.Now()
+	expiresAt := deletedAt.Add(retention).Unix()
+	update := expression.Set(expression.Name("deletedAt"), expression.Value(deletedAt)).
+		Set(expression.Name("expiresAt"), expression.Value(expiresAt))
+	// only delete pages that exist and aren't already in the trash, since UpdateItem would otherwise create them
+	condition := expression.AttributeExists(expression.Name("id")).And(expression.AttributeNotExists(expression.Name("deletedAt")))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		log.Println("Error building dynamodb expression:", err)
+		return problemResponse(http.StatusInternalServerError, "Error building dynamodb expression"), nil
+	}
+
+	result, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       key,
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllOld),
+		TableName:                 aws.String(table),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		log.Println("No page to delete")
+		return problemResponse(http.StatusNotFound, "No page found with that id and version"), nil
+	}
+	if err != nil {
+		log.Println("Error deleting page in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error deleting page"), nil
+	}
+
+	page = Page{}
+	err = dynamodbattribute.UnmarshalMap(result.Attributes, &page)
+	if err != nil {
+		log.Println("Error unmarshalling into page:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading deleted page"), nil
 	}
+	// the old values predate the delete, so stamp them the way they are now stored
+	before := audit.Snapshot(&page)
+	page.DeletedAt = &deletedAt
+	page.ExpiresAt = expiresAt
 
-	// TODO: consider returning body with status
+	// pages in the trash drop out of their tags, and restoring the page puts them back
+	work := store.NewUnitOfWork(db, table)
+	if err = tags.Index(work, sitePath, page.ID, page.Version, deletedAt, page.Tags, nil); err == nil {
+		err = work.Commit()
+	}
+	if err != nil {
+		log.Println("Error removing page from its tags:", err)
+	}
+	if err := search.Update(index, sitePath, document(&page), nil); err != nil {
+		log.Println("Error removing page from search:", err)
+	}
+	target := audit.Target{Type: "page", ID: page.ID, Version: page.Version, Path: page.Path}
+	if err := trail.Append(audit.NewEntry(request.RequestContext, audit.Delete, target, before, &page)); err != nil {
+		log.Println("Error appending to audit trail:", err)
+	}
+
+	if noContent {
+		response := Response{
+			StatusCode: http.StatusNoContent,
+		}
+
+		return response, nil
+	}
+
+	body, err := json.Marshal(page)
+	if err != nil {
+		log.Println("Error marshalling page into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing deleted page"), nil
+	}
 
 	response := Response{
-		StatusCode: 200,
+		StatusCode: http.StatusOK,
+		Body:       string(body),
 		Headers: map[string]string{
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Credentials": "true",
+			"Content-Type": "application/json",
 		},
 	}
 
 	return response, nil
 }
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
+
+// document returns the text of the page that is indexed for search
+func document(page *Page) *search.Document {
+	return &search.Document{
+		ID:          page.ID,
+		Version:     page.Version,
+		Name:        aws.StringValue(page.Name),
+		Description: aws.StringValue(page.Description),
+		Keywords:    aws.StringValue(page.Keywords),
+		Content:     search.Text(page.Blocks, page.Fields),
+	}
+}
@@ -15,26 +15,50 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
 )
 
-type Response events.APIGatewayProxyResponse
-type Request events.APIGatewayProxyRequest
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
 
 // Page defines the fields of the page model
 type Page struct {
-	ID          string    `json:"id"`
-	Version     string    `json:"version"`
-	Path        string    `json:"path"`
-	Type        string    `json:"type"`
-	Name        *string   `json:"name,omitempty"`
-	Description *string   `json:"description,omitempty"`
-	Keywords    *string   `json:"keywords,omitempty"`
-	Author      *string   `json:"author,omitempty"`
-	CreatedAt   time.Time `json:"createdAt,omitempty"`
-	UpdatedAt   time.Time `json:"updatedAt,omitempty"`
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Path        string                 `json:"path"`
+	ParentID    *string                `json:"parentId,omitempty"`
+	Order       int                    `json:"order,omitempty"`
+	Type        string                 `json:"type"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Tags        []string               `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Author      *string                `json:"author,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	ContentType *string                `json:"contentType,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Social      *render.Social         `json:"social,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time             `json:"deletedAt,omitempty"`
+	ExpiresAt   int64                  `json:"expiresAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
 }
 
 var db *dynamodb.DynamoDB
+var policy *access.Policy
 var region, stage, table string
 var currentTime time.Time
 
@@ -53,23 +77,24 @@ func init() {
 	// TODO: validate env vars
 }
 
-// main starts the session, news up the db & invokes lambda handler
+// main starts the session, news up the db & access policy & invokes lambda handler
 func main() {
 	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
 	if err != nil {
 		log.Println("Failed to connect to AWS:", err)
 	} else {
 		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
 	}
 
-	lambda.Start(Handler)
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
 }
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call in main()
 func Handler(ctx context.Context, request Request) (Response, error) {
 	var page Page
 
-	// siteid := aws.String(request.PathParameters["siteid"])
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
 	pageid := aws.String(request.PathParameters["pageid"])
 	version := aws.String(request.QueryStringParameters["version"])
 
@@ -85,37 +110,65 @@ func Handler(ctx context.Context, request Request) (Response, error) {
 		TableName: aws.String(table),
 	})
 	if err != nil {
-		log.Println("Error getting page from dynamodb")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error getting page from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting page"), nil
 	}
 
 	err = dynamodbattribute.UnmarshalMap(result.Item, &page)
 	if err != nil {
-		log.Println("Error unmarshalling into page")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error unmarshalling into page:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading page"), nil
 	}
 
 	body, err := json.Marshal(page)
 	if err != nil {
-		log.Println("Error marshalling page into json for response body")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error marshalling page into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing page"), nil
 	}
 
-	// make sure there's a valid page returned
-	if page.ID == "" {
-		// TODO: consider returning body with status
-		return Response{StatusCode: http.StatusNotFound}, err
+	// make sure a page of the site is returned, rather than another kind of item with the id, hiding
+	// pages in the trash unless asked for
+	includeDeleted := request.QueryStringParameters["includeDeleted"] == "true"
+	if page.ID == "" || page.Type != "page" || (page.DeletedAt != nil && !includeDeleted) || access.SiteOf(page.Path) != sitePath {
+		return problemResponse(http.StatusNotFound, "No page found with that id and version"), nil
+	}
+
+	// only the site's members may read its pages
+	role, err := policy.Role(auth.FromContext(ctx), access.SiteOf(page.Path))
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Viewer) {
+		return problemResponse(http.StatusNotFound, "No page found with that id and version"), nil
 	}
 
 	response := Response{
 		StatusCode: http.StatusOK,
 		Body:       string(body),
 		Headers: map[string]string{
-			"Content-Type":                     "application/json",
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Credentials": "true",
+			"Content-Type": "application/json",
 		},
 	}
 
 	return response, nil
 }
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
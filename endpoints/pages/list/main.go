@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -16,26 +18,51 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/tags"
 )
 
-type Response events.APIGatewayProxyResponse
-type Request events.APIGatewayProxyRequest
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// batchGetSize is the maximum number of keys DynamoDB accepts in one BatchGetItem call
+const batchGetSize = 100
+
+// maxRetries bounds how many times unprocessed keys are resubmitted before giving up
+const maxRetries = 5
 
 // Page defines the fields of the page model
 type Page struct {
-	ID          string    `json:"id"`
-	Version     string    `json:"version"`
-	Path        string    `json:"path"`
-	Type        string    `json:"type,omitempty"`
-	Name        *string   `json:"name,omitempty"`
-	Description *string   `json:"description,omitempty"`
-	Keywords    *string   `json:"keywords,omitempty"`
-	Author      *string   `json:"author,omitempty"`
-	CreatedAt   time.Time `json:"createdAt,omitempty"`
-	UpdatedAt   time.Time `json:"updatedAt,omitempty"`
+	ID          string     `json:"id"`
+	Version     string     `json:"version"`
+	Path        string     `json:"path"`
+	ParentID    *string    `json:"parentId,omitempty"`
+	Order       int        `json:"order,omitempty"`
+	Type        string     `json:"type,omitempty"`
+	Name        *string    `json:"name,omitempty"`
+	Description *string    `json:"description,omitempty"`
+	Keywords    *string    `json:"keywords,omitempty"`
+	Tags        []string   `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Author      *string    `json:"author,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time  `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+	ExpiresAt   int64      `json:"expiresAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
 }
 
 var db *dynamodb.DynamoDB
+var policy *access.Policy
 var region, stage, table string
 var currentTime time.Time
 
@@ -54,41 +81,74 @@ func init() {
 	// TODO: validate env vars
 }
 
-// main starts the session, news up the db & invokes lambda handler
+// main starts the session, news up the db & access policy & invokes lambda handler
 func main() {
 	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
 	if err != nil {
 		log.Println("Failed to connect to AWS:", err)
 	} else {
 		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
 	}
 
-	lambda.Start(Handler)
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
 }
 
-// Handler is our lambda handler invoked by the `lambda.Start` function call in main()
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// Passing `tag` lists only the pages with that tag, read through the tag's index items.
 func Handler(ctx context.Context, request Request) (Response, error) {
-	sitePath := request.PathParameters["siteid"]
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
 	// TODO: validate site path
 
+	// only the site's members may read its pages
+	role, err := policy.Role(auth.FromContext(ctx), sitePath)
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Viewer) {
+		return problemResponse(http.StatusForbidden, "Only the site's members may list its pages"), nil
+	}
+
 	var pages []Page
 
+	if tag, ok := request.QueryStringParameters["tag"]; ok {
+		tagged, err := tags.Normalize([]string{tag}, nil)
+		if err != nil || len(tagged) == 0 {
+			log.Println("Invalid tag:", err)
+			return problemResponse(http.StatusBadRequest, "Invalid tag"), nil
+		}
+
+		pages, err = taggedPages(sitePath, tagged[0])
+		if err != nil {
+			log.Println("Error getting tagged pages from dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error getting tagged pages"), nil
+		}
+
+		return pagesResponse(pages)
+	}
+
 	// define key condition for sort to begin with
 	sortCondition := expression.Key("path").BeginsWith(sitePath)
 	// "And" the sort key with partition key
 	key := expression.Key("type").Equal(expression.Value("page")).And(sortCondition)
 	// projection represents the list of attribute names
-	projection := expression.NamesList(expression.Name("id"), expression.Name("version"), expression.Name("path"), expression.Name("createdAt"), expression.Name("updatedAt"), expression.Name("name"))
+	projection := listProjection()
 
 	builder := expression.NewBuilder().WithKeyCondition(key).WithProjection(projection)
+	// pages in the trash are hidden unless asked for
+	if request.QueryStringParameters["includeDeleted"] != "true" {
+		builder = builder.WithFilter(expression.AttributeNotExists(expression.Name("deletedAt")))
+	}
 	expr, err := builder.Build()
 	if err != nil {
-		log.Println("Error building dynamodb expression")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error building dynamodb expression:", err)
+		return problemResponse(http.StatusInternalServerError, "Error building dynamodb expression"), nil
 	}
 
 	queryInput := dynamodb.QueryInput{
 		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
 		ProjectionExpression:      expr.Projection(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
@@ -96,33 +156,162 @@ func Handler(ctx context.Context, request Request) (Response, error) {
 		TableName:                 aws.String(table),
 	}
 
-	results, err := db.Query(&queryInput)
+	queried, err := db.Query(&queryInput)
 	if err != nil {
-		log.Println("Error querying pages in dynamodb")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error querying pages in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying pages"), nil
 	}
 
-	err = dynamodbattribute.UnmarshalListOfMaps(results.Items, &pages)
+	var results []Page
+	err = dynamodbattribute.UnmarshalListOfMaps(queried.Items, &results)
 	if err != nil {
-		log.Println("Error unmarshalling into pages slice")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error unmarshalling into pages slice:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading pages"), nil
+	}
+	// the prefix also matches other sites whose paths start with this one's
+	for _, page := range results {
+		if page.Path == sitePath || strings.HasPrefix(page.Path, sitePath+"/") {
+			pages = append(pages, page)
+		}
+	}
+
+	return pagesResponse(pages)
+}
+
+// pagesResponse returns the pages as the JSON response body
+func pagesResponse(pages []Page) (Response, error) {
+	if pages == nil {
+		pages = []Page{}
 	}
 
 	body, err := json.Marshal(pages)
 	if err != nil {
-		log.Println("Error marshalling pages into json for response body")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error marshalling pages into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing pages"), nil
 	}
 
 	response := Response{
 		StatusCode: http.StatusOK,
 		Body:       string(body),
 		Headers: map[string]string{
-			"Content-Type":                     "application/json",
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Credentials": "true",
+			"Content-Type": "application/json",
 		},
 	}
 
 	return response, nil
 }
+
+// listProjection returns the attributes of pages included in the list
+func listProjection() expression.ProjectionBuilder {
+	return expression.NamesList(expression.Name("id"), expression.Name("version"), expression.Name("path"), expression.Name("createdAt"), expression.Name("updatedAt"), expression.Name("name"), expression.Name("parentId"), expression.Name("order"), expression.Name("deletedAt"), expression.Name("tags"))
+}
+
+// taggedPages returns the site's pages with the tag, ordered by path. It queries the tag's index
+// items, then gets the pages they point to in batches. Index items can briefly outlive a tag
+// being taken off a page, so pages are only listed if they're still the site's, still have the
+// tag and aren't in the trash.
+func taggedPages(sitePath, tag string) ([]Page, error) {
+	var items []tags.Item
+	var pages []Page
+
+	key := expression.Key("id").Equal(expression.Value(tags.ID(sitePath, tag)))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(table),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var batch []tags.Item
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &batch); unmarshalErr != nil {
+			return false
+		}
+		items = append(items, batch...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	projection, err := expression.NewBuilder().WithProjection(listProjection()).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(items); start += batchGetSize {
+		end := start + batchGetSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		var keys []map[string]*dynamodb.AttributeValue
+		for _, item := range items[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				"id":      {S: aws.String(item.PageID)},
+				"version": {S: aws.String(item.PageVersion)},
+			})
+		}
+
+		pending := map[string]*dynamodb.KeysAndAttributes{table: {
+			Keys:                     keys,
+			ProjectionExpression:     projection.Projection(),
+			ExpressionAttributeNames: projection.Names(),
+		}}
+		for attempt := 0; pending[table] != nil && len(pending[table].Keys) > 0; attempt++ {
+			if attempt > maxRetries {
+				return nil, errors.New("unprocessed keys after retries")
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(1<<uint(attempt-1)) * 100 * time.Millisecond)
+			}
+
+			result, err := db.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				return nil, err
+			}
+
+			var batch []Page
+			if err := dynamodbattribute.UnmarshalListOfMaps(result.Responses[table], &batch); err != nil {
+				return nil, err
+			}
+			for _, page := range batch {
+				inSite := page.Path == sitePath || strings.HasPrefix(page.Path, sitePath+"/")
+				if inSite && page.DeletedAt == nil && tags.Contains(page.Tags, tag) {
+					pages = append(pages, page)
+				}
+			}
+			pending = result.UnprocessedKeys
+		}
+	}
+
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Path < pages[j].Path })
+
+	return pages, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
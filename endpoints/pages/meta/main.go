@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+type SiteStatus int
+
+const (
+	Unpublished SiteStatus = iota
+	Published
+)
+
+// Site defines the fields of the site model needed to resolve its pages' metadata
+type Site struct {
+	render.Site
+	Status    SiteStatus `json:"status,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Optional(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It returns the resolved title, canonical URL & meta tags of the page at the `path` query
+// parameter, or the site's home page without one, with the page inheriting the site's social
+// metadata. They're the same tags the render endpoint puts in the page's head.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+	pagePath := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(request.QueryStringParameters["path"])), "/")
+	if pagePath == "" {
+		pagePath = sitePath
+	}
+	if pagePath != sitePath && !strings.HasPrefix(pagePath, sitePath+"/") {
+		return problemResponse(http.StatusBadRequest, "Path must be a path under the site"), nil
+	}
+
+	site, err := publishedSite(sitePath)
+	if err != nil {
+		log.Println("Error getting site from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting site"), nil
+	}
+	if site == nil {
+		return problemResponse(http.StatusNotFound, "No published site found at "+sitePath), nil
+	}
+
+	page, err := latestPage(pagePath)
+	if err != nil {
+		log.Println("Error getting page from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting page"), nil
+	}
+	if page == nil {
+		return problemResponse(http.StatusNotFound, "No page found at "+pagePath), nil
+	}
+
+	body, err := json.Marshal(render.Meta(&site.Site, page))
+	if err != nil {
+		log.Println("Error marshalling metadata into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing metadata"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// publishedSite returns the latest version of the site at the path when it's published and
+// outside the trash, or nil otherwise
+func publishedSite(sitePath string) (*Site, error) {
+	var sites []Site
+
+	key := expression.Key("type").Equal(expression.Value("site")).And(expression.Key("path").Equal(expression.Value(sitePath)))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &sites)
+	if err != nil || len(sites) == 0 {
+		return nil, err
+	}
+
+	latest := sites[0]
+	for _, site := range sites[1:] {
+		if site.UpdatedAt.After(latest.UpdatedAt) {
+			latest = site
+		}
+	}
+	if latest.Status != Published {
+		return nil, nil
+	}
+
+	return &latest, nil
+}
+
+// latestPage returns the latest version of the page at the path that's outside the trash, or
+// nil if there isn't one
+func latestPage(pagePath string) (*render.Page, error) {
+	var pages []render.Page
+
+	key := expression.Key("type").Equal(expression.Value("page")).And(expression.Key("path").Equal(expression.Value(pagePath)))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	queryInput := dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var results []render.Page
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &results); unmarshalErr != nil {
+			return false
+		}
+		pages = append(pages, results...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil || len(pages) == 0 {
+		return nil, err
+	}
+
+	latest := pages[0]
+	for _, page := range pages[1:] {
+		if page.UpdatedAt.After(latest.UpdatedAt) {
+			latest = page
+		}
+	}
+
+	return &latest, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/tags"
+	"github.com/google/uuid"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Page defines the fields of the page model
+type Page struct {
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Path        string                 `json:"path"`
+	ParentID    *string                `json:"parentId,omitempty"`
+	Order       int                    `json:"order,omitempty"`
+	Type        string                 `json:"type"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Tags        []string               `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Author      *string                `json:"author,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	ContentType *string                `json:"contentType,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Social      *render.Social         `json:"social,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time             `json:"deletedAt,omitempty"`
+	ExpiresAt   int64                  `json:"expiresAt,omitempty"`
+}
+
+// Move is the request body of a move or rename. Fields left out keep their current value, and a
+// null parent moves the page to the top of its site. The slug renames the last segment of the path.
+type Move struct {
+	ParentID *string `json:"parentId"`
+	Order    *int    `json:"order,omitempty"`
+	Slug     *string `json:"slug,omitempty"`
+}
+
+// Redirect sends requests for a path that no longer has a page on to where the page is now
+type Redirect struct {
+	ID        string    `json:"id"`
+	Version   string    `json:"version"`
+	Path      string    `json:"path"`
+	Type      string    `json:"type"`
+	Target    string    `json:"target"`
+	Status    int       `json:"status"`
+	ExpiresAt int64     `json:"expiresAt,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// MoveResult is the response body of a move, with the redirects left behind at the old paths
+type MoveResult struct {
+	Page      *Page      `json:"page"`
+	Redirects []Redirect `json:"redirects"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db dynamodbiface.DynamoDBAPI
+var policy *access.Policy
+var trail audit.Log
+var index search.Index
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		trail = &audit.Table{DB: db, Name: table}
+		index = &search.Table{DB: db, Name: table}
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It moves the page under a new parent, to a new position among its siblings and/or renames it,
+// rewriting the paths of every version of the page and of its descendants, reindexing their tags
+// and leaving a 301 redirect at every old path, all in a single transaction. The moved pages are
+// reindexed for search once the transaction is written.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	pageid := request.PathParameters["pageid"]
+	version := request.QueryStringParameters["version"]
+
+	var move Move
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal([]byte(request.Body), &move)
+	if err == nil {
+		err = json.Unmarshal([]byte(request.Body), &fields)
+	}
+	if err != nil {
+		log.Println("Error unmarshalling request body into move:", err)
+		return problemResponse(http.StatusBadRequest, "Request body is not a valid move"), nil
+	}
+
+	slug := ""
+	if move.Slug != nil {
+		slug = strings.ToLower(strings.TrimSpace(*move.Slug))
+		if slug == "" || strings.Contains(slug, "/") || slug == "." || slug == ".." {
+			return problemResponse(http.StatusBadRequest, "Slug must be a single, non-empty path segment"), nil
+		}
+	}
+
+	page, err := getPage(pageid, version)
+	if err != nil {
+		log.Println("Error getting page from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting page"), nil
+	}
+	if page == nil {
+		return problemResponse(http.StatusNotFound, "No page found with that id and version"), nil
+	}
+
+	// editors may move any page, and authors their own
+	principal := auth.FromContext(ctx)
+	role, err := policy.Role(principal, access.SiteOf(page.Path))
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !access.CanEditPage(role, principal, page.Author) {
+		return problemResponse(http.StatusForbidden, "Only the site's editors and the page's author may move it"), nil
+	}
+
+	// without a parent in the request the page stays where it is, while a null parent moves it to
+	// the top of the site, directly under the site's path
+	if _, ok := fields["parentId"]; !ok {
+		move.ParentID = page.ParentID
+	}
+	if move.Order == nil {
+		move.Order = &page.Order
+	}
+	if slug == "" {
+		slug = path.Base(page.Path)
+	}
+
+	parentPath := strings.SplitN(page.Path, "/", 2)[0]
+	if move.ParentID != nil {
+		if *move.ParentID == page.ID {
+			return problemResponse(http.StatusBadRequest, "Can't move a page under itself"), nil
+		}
+
+		parent, err := latestPage(*move.ParentID)
+		if err != nil {
+			log.Println("Error getting parent page from dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error getting parent page"), nil
+		}
+		if parent == nil {
+			return problemResponse(http.StatusBadRequest, "No parent page found with that id"), nil
+		}
+		if strings.HasPrefix(parent.Path, page.Path+"/") {
+			return problemResponse(http.StatusBadRequest, "Can't move a page under one of its descendants"), nil
+		}
+		if access.SiteOf(parent.Path) != access.SiteOf(page.Path) {
+			return problemResponse(http.StatusBadRequest, "Can't move a page to another site"), nil
+		}
+		parentPath = parent.Path
+	}
+
+	oldPath := page.Path
+	newPath := parentPath + "/" + slug
+	sitePath := access.SiteOf(oldPath)
+
+	// every version of the page moves with it, not only the one asked for, so none is left
+	// behind at the old path
+	versions, err := pageVersions(page.ID)
+	if err != nil {
+		log.Println("Error querying page versions in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying page versions"), nil
+	}
+
+	descendants, err := queryPages(oldPath + "/")
+	if err != nil {
+		log.Println("Error querying descendant pages in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying descendant pages"), nil
+	}
+	// the descendants move along with the page, so authors may only move pages whose
+	// descendants are theirs too
+	for _, descendant := range descendants {
+		if !access.CanEditPage(role, principal, descendant.Author) {
+			return problemResponse(http.StatusForbidden, "Only the site's editors may move pages with descendants by other authors"), nil
+		}
+	}
+
+	currentTime := time.Now()
+	before := audit.Snapshot(page)
+
+	// the index items & postings are of each page's latest version, which the move makes the
+	// version asked for, and for descendants keeps the one they had
+	indexed := map[string]*Page{page.ID: page}
+	if newest := latest(versions); newest != nil {
+		indexed[page.ID] = newest
+	}
+	for i := range descendants {
+		descendant := &descendants[i]
+		if other, ok := indexed[descendant.ID]; !ok || descendant.UpdatedAt.After(other.UpdatedAt) {
+			indexed[descendant.ID] = descendant
+		}
+	}
+	previous := map[string]*Page{}
+	for id, version := range indexed {
+		copied := *version
+		previous[id] = &copied
+	}
+
+	page.ParentID = move.ParentID
+	page.Order = *move.Order
+	page.Path = newPath
+	page.UpdatedAt = currentTime
+	indexed[page.ID] = page
+
+	// the page & its other versions, then every version of its descendants, each with the path
+	// it had before the move
+	moved, sources := []*Page{page}, []string{oldPath}
+	for i := range versions {
+		if versions[i].Version != page.Version {
+			versions[i].Path = newPath
+			moved, sources = append(moved, &versions[i]), append(sources, oldPath)
+		}
+	}
+	for i := range descendants {
+		descendant := &descendants[i]
+		sources = append(sources, descendant.Path)
+		descendant.Path = newPath + strings.TrimPrefix(descendant.Path, oldPath)
+		if indexed[descendant.ID].Version == descendant.Version {
+			descendant.UpdatedAt = currentTime
+		}
+		moved = append(moved, descendant)
+	}
+
+	if newPath != oldPath {
+		// any page already at one of the paths the pages move to would end up sharing it
+		targets := map[string]bool{}
+		movedIDs := map[string]bool{}
+		for _, p := range moved {
+			targets[p.Path] = true
+			movedIDs[p.ID] = true
+		}
+		existing, err := queryPages(newPath)
+		if err != nil {
+			log.Println("Error querying pages in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error querying pages"), nil
+		}
+		for _, other := range existing {
+			if !movedIDs[other.ID] && targets[other.Path] {
+				return problemResponse(http.StatusConflict, "A page already exists at "+other.Path), nil
+			}
+		}
+	}
+
+	// the pages, their tag index items and their redirects are written together, so no child is
+	// left under the old path, no old path is left without a redirect and no tag is left
+	// pointing at a version the move changed
+	work := store.NewUnitOfWork(db, table)
+	result := MoveResult{Page: page, Redirects: []Redirect{}}
+	for _, p := range moved {
+		if newPath == oldPath && p != page {
+			continue
+		}
+		if err := work.Replace(p); err != nil {
+			log.Println("Error marshalling page into dynamodb attribute:", err)
+			return problemResponse(http.StatusInternalServerError, "Error writing page"), nil
+		}
+	}
+	for id, current := range indexed {
+		if newPath == oldPath && id != page.ID {
+			continue
+		}
+		if err := tags.Index(work, sitePath, id, current.Version, current.UpdatedAt, previous[id].Tags, current.Tags); err != nil {
+			log.Println("Error marshalling tag into dynamodb attribute:", err)
+			return problemResponse(http.StatusInternalServerError, "Error writing tags"), nil
+		}
+	}
+
+	if newPath != oldPath {
+		// redirects pointing away from the new paths would hide the pages now living there
+		stale, err := queryRedirects(newPath)
+		if err != nil {
+			log.Println("Error querying redirects in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error querying redirects"), nil
+		}
+		for _, redirect := range stale {
+			work.Delete(redirect.ID, redirect.Version)
+		}
+
+		// every path that changed gets a redirect, the page's own and each of its descendants',
+		// once however many versions were at it
+		redirected := map[string]bool{}
+		for i, p := range moved {
+			if redirected[sources[i]] {
+				continue
+			}
+			redirected[sources[i]] = true
+
+			redirect := Redirect{
+				ID:        uuid.New().String(),
+				Version:   uuid.New().String(),
+				Path:      sources[i],
+				Type:      "redirect",
+				Target:    p.Path,
+				Status:    http.StatusMovedPermanently,
+				CreatedAt: currentTime,
+				UpdatedAt: currentTime,
+			}
+			if err := work.Create(redirect); err != nil {
+				log.Println("Error marshalling redirect into dynamodb attribute:", err)
+				return problemResponse(http.StatusInternalServerError, "Error writing redirect"), nil
+			}
+			result.Redirects = append(result.Redirects, redirect)
+		}
+	}
+
+	err = work.Commit()
+	if err == store.ErrTooManyWrites {
+		return problemResponse(http.StatusRequestEntityTooLarge, "Page has too many descendants to move at once"), nil
+	}
+	if err == store.ErrConflict {
+		return problemResponse(http.StatusConflict, "Pages changed while being moved, try again"), nil
+	}
+	if err != nil {
+		log.Println("Error writing move transaction to dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error moving page"), nil
+	}
+
+	// the search index can't be written in the transaction, and the pages are already moved, so
+	// failing to index them is logged rather than reported
+	for id, current := range indexed {
+		if newPath == oldPath && id != page.ID {
+			continue
+		}
+		if err := search.Update(index, sitePath, document(previous[id]), document(current)); err != nil {
+			log.Println("Error indexing page for search:", err)
+		}
+	}
+
+	// failing to audit the move is logged rather than reported too. The page's
+	// descendants & redirects went with it, so the page is all that's recorded.
+	target := audit.Target{Type: "page", ID: page.ID, Version: page.Version, Path: page.Path}
+	if err := trail.Append(audit.NewEntry(request.RequestContext, audit.Move, target, before, page)); err != nil {
+		log.Println("Error appending to audit trail:", err)
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		log.Println("Error marshalling move into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing moved page"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// getPage returns the page version, or nil when it doesn't exist or is in the trash
+func getPage(id, version string) (*Page, error) {
+	var page Page
+
+	key := map[string]*dynamodb.AttributeValue{
+		"id":      {S: aws.String(id)},
+		"version": {S: aws.String(version)},
+	}
+
+	result, err := db.GetItem(&dynamodb.GetItemInput{
+		Key:       key,
+		TableName: aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalMap(result.Item, &page)
+	if err != nil || page.ID == "" || page.Type != "page" || page.DeletedAt != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// pageVersions returns every version of the page that isn't in the trash
+func pageVersions(id string) ([]Page, error) {
+	var pages []Page
+
+	key := expression.Key("id").Equal(expression.Value(id))
+	filter := expression.Name("type").Equal(expression.Value("page")).And(expression.AttributeNotExists(expression.Name("deletedAt")))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(table),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var results []Page
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &results); unmarshalErr != nil {
+			return false
+		}
+		pages = append(pages, results...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pages, unmarshalErr
+}
+
+// latest returns the most recently updated of the versions
+func latest(versions []Page) *Page {
+	var newest *Page
+	for i := range versions {
+		if newest == nil || versions[i].UpdatedAt.After(newest.UpdatedAt) {
+			newest = &versions[i]
+		}
+	}
+
+	return newest
+}
+
+// latestPage returns the most recently updated version of the page that isn't in the trash, or
+// nil when there is none
+func latestPage(id string) (*Page, error) {
+	var pages []Page
+
+	key := expression.Key("id").Equal(expression.Value(id))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("id-updatedAt-index"),
+		ScanIndexForward:          aws.Bool(false),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(results.Items, &pages)
+	if err != nil || len(pages) == 0 || pages[0].Type != "page" {
+		return nil, err
+	}
+
+	return &pages[0], nil
+}
+
+// queryPages returns every page whose path begins with the prefix and isn't in the trash
+func queryPages(prefix string) ([]Page, error) {
+	var pages []Page
+
+	// define key condition for sort to begin with
+	sortCondition := expression.Key("path").BeginsWith(prefix)
+	// "And" the sort key with partition key
+	key := expression.Key("type").Equal(expression.Value("page")).And(sortCondition)
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	queryInput := dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var results []Page
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &results); unmarshalErr != nil {
+			return false
+		}
+		pages = append(pages, results...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pages, unmarshalErr
+}
+
+// queryRedirects returns the redirects from the path or any path beneath it
+func queryRedirects(prefix string) ([]Redirect, error) {
+	var redirects []Redirect
+
+	// define key condition for sort to begin with
+	sortCondition := expression.Key("path").BeginsWith(prefix)
+	// "And" the sort key with partition key
+	key := expression.Key("type").Equal(expression.Value("redirect")).And(sortCondition)
+
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	queryInput := dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var results []Redirect
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &results); unmarshalErr != nil {
+			return false
+		}
+		for _, redirect := range results {
+			// begins_with("a/b") also matches "a/bc", so only keep the path itself and beneath it
+			if redirect.Path == prefix || strings.HasPrefix(redirect.Path, prefix+"/") {
+				redirects = append(redirects, redirect)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return redirects, unmarshalErr
+}
+
+// document returns the text of the page that is indexed for search
+func document(page *Page) *search.Document {
+	return &search.Document{
+		ID:          page.ID,
+		Version:     page.Version,
+		Name:        aws.StringValue(page.Name),
+		Description: aws.StringValue(page.Description),
+		Keywords:    aws.StringValue(page.Keywords),
+		Content:     search.Text(page.Blocks, page.Fields),
+	}
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
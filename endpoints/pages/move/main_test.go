@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+)
+
+func TestMovePage(t *testing.T) {
+	memory := setupSite(t)
+
+	// the page's descendants move with it, and each old path is left redirecting to the new one
+	response, err := Handler(callerContext(), moveRequest("post", `{"parentId": "blog"}`))
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("MovePage Handler: got code %v, error %v, body %v; wanted %v", response.StatusCode, err, response.Body, http.StatusOK)
+	}
+	var result MoveResult
+	json.Unmarshal([]byte(response.Body), &result)
+	if result.Page == nil || result.Page.Path != "acme/blog/post" || aws.StringValue(result.Page.ParentID) != "blog" {
+		t.Errorf("MovePage Handler: got page %+v; wanted it under the blog", result.Page)
+	}
+
+	paths := map[string]string{}
+	redirects := map[string]Redirect{}
+	for _, item := range memory.Items() {
+		switch aws.StringValue(item["type"].S) {
+		case "page":
+			var page Page
+			dynamodbattribute.UnmarshalMap(item, &page)
+			paths[page.ID+"/"+page.Version] = page.Path
+		case "redirect":
+			var redirect Redirect
+			dynamodbattribute.UnmarshalMap(item, &redirect)
+			redirects[redirect.Path] = redirect
+		}
+	}
+	wanted := map[string]string{
+		"blog/b1":  "acme/blog",
+		"post/p1":  "acme/blog/post",
+		"post/p2":  "acme/blog/post",
+		"child/c1": "acme/blog/post/child",
+	}
+	for version, path := range wanted {
+		if paths[version] != path {
+			t.Errorf("MovePage Handler: got path %q for %v; wanted %q", paths[version], version, path)
+		}
+	}
+
+	// one redirect per old path, however many versions were at it, and the stale redirect from
+	// the new path is gone
+	targets := map[string]string{"acme/post": "acme/blog/post", "acme/post/child": "acme/blog/post/child"}
+	if len(redirects) != len(targets) || len(result.Redirects) != len(targets) {
+		t.Errorf("MovePage Handler: got redirects %+v; wanted one from each old path", redirects)
+	}
+	for source, target := range targets {
+		if redirect := redirects[source]; redirect.Target != target || redirect.Status != http.StatusMovedPermanently {
+			t.Errorf("MovePage Handler: got redirect %+v from %v; wanted a 301 to %v", redirect, source, target)
+		}
+	}
+
+	var indexed []string
+	for _, term := range []string{"post", "child"} {
+		postings, _ := index.Postings("acme", term)
+		for _, posting := range postings {
+			indexed = append(indexed, posting.ID)
+		}
+	}
+	sort.Strings(indexed)
+	if len(indexed) != 2 || indexed[0] != "child" || indexed[1] != "post" {
+		t.Errorf("MovePage Handler: got postings for %v; wanted the moved pages kept in the search index", indexed)
+	}
+}
+
+func TestMovePageRefused(t *testing.T) {
+	setupSite(t)
+
+	tests := []struct {
+		name   string
+		pageid string
+		body   string
+		want   int
+	}{
+		{"under itself", "post", `{"parentId": "post"}`, http.StatusBadRequest},
+		{"under a descendant", "post", `{"parentId": "child"}`, http.StatusBadRequest},
+		{"missing parent", "post", `{"parentId": "missing"}`, http.StatusBadRequest},
+		{"onto another page", "post", `{"slug": "blog", "parentId": null}`, http.StatusConflict},
+		{"bad slug", "post", `{"slug": "a/b"}`, http.StatusBadRequest},
+		{"missing page", "missing", `{}`, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, _ := Handler(callerContext(), moveRequest(test.pageid, test.body))
+			if response.StatusCode != test.want {
+				t.Errorf("MovePage Handler: got code %v (%v); wanted %v", response.StatusCode, response.Body, test.want)
+			}
+		})
+	}
+}
+
+// setupSite points the handler at a memory store holding the acme site, edited by user-1, with a
+// blog page and a post page of two versions with a child, and a stale redirect from the path the
+// post moves to
+func setupSite(t *testing.T) *store.Memory {
+	memory := store.NewMemory()
+	db = memory
+	table = "go-lambda-dynamo"
+	policy = &access.Policy{Store: &access.Table{DB: memory, Name: table}}
+	trail = &audit.Memory{}
+	index = search.NewMemory()
+
+	updatedAt := time.Now().Add(-time.Hour)
+	put(t, memory, map[string]interface{}{"id": "site-1", "version": "v1", "type": "site", "path": "acme", "tenant": "acme-corp"})
+	put(t, memory, access.Item(&access.Member{Site: "acme", Subject: "user-1", Role: access.Editor}))
+	put(t, memory, Page{ID: "blog", Version: "b1", Type: "page", Path: "acme/blog", Name: aws.String("Blog"), UpdatedAt: updatedAt})
+	put(t, memory, Page{ID: "post", Version: "p1", Type: "page", Path: "acme/post", Name: aws.String("Post"), UpdatedAt: updatedAt})
+	put(t, memory, Page{ID: "post", Version: "p2", Type: "page", Path: "acme/post", Name: aws.String("Post"), UpdatedAt: updatedAt.Add(time.Minute)})
+	put(t, memory, Page{ID: "child", Version: "c1", Type: "page", Path: "acme/post/child", ParentID: aws.String("post"), Name: aws.String("Child"), UpdatedAt: updatedAt})
+	put(t, memory, Redirect{ID: "stale", Version: "r1", Type: "redirect", Path: "acme/blog/post", Target: "acme/elsewhere", Status: http.StatusMovedPermanently})
+
+	search.Update(index, "acme", nil, &search.Document{ID: "post", Version: "p2", Name: "Post"})
+	search.Update(index, "acme", nil, &search.Document{ID: "child", Version: "c1", Name: "Child"})
+
+	return memory
+}
+
+// put adds the item to the memory store
+func put(t *testing.T, memory *store.Memory, item interface{}) {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memory.PutItem(&dynamodb.PutItemInput{Item: av, TableName: aws.String(table)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// callerContext returns a context with the principal of user-1
+func callerContext() context.Context {
+	return auth.NewContext(context.Background(), &auth.Principal{Subject: "user-1", Method: "jwt", Tenant: "acme-corp"})
+}
+
+// moveRequest returns the request moving the first version of the page
+func moveRequest(pageid, body string) Request {
+	return Request{
+		PathParameters:        map[string]string{"pageid": pageid},
+		QueryStringParameters: map[string]string{"version": pageid[:1] + "1"},
+		Body:                  body,
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/tags"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Page defines the fields of the page model
+type Page struct {
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Path        string                 `json:"path"`
+	ParentID    *string                `json:"parentId,omitempty"`
+	Order       int                    `json:"order,omitempty"`
+	Type        string                 `json:"type"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Tags        []string               `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Author      *string                `json:"author,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	ContentType *string                `json:"contentType,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Social      *render.Social         `json:"social,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time             `json:"deletedAt,omitempty"`
+	ExpiresAt   int64                  `json:"expiresAt,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var trail audit.Log
+var index search.Index
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		trail = &audit.Table{DB: db, Name: table}
+		index = &search.Table{DB: db, Name: table}
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It takes the page version back out of the trash by removing `deletedAt` and the TTL `expiresAt`.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	var page Page
+
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+	pageid := aws.String(request.PathParameters["pageid"])
+	version := aws.String(request.QueryStringParameters["version"])
+
+	key := map[string]*dynamodb.AttributeValue{
+		"id":      {S: pageid},
+		"version": {S: version},
+	}
+
+	// editors may restore any page, and authors their own
+	current, err := db.GetItem(&dynamodb.GetItemInput{
+		Key:       key,
+		TableName: aws.String(table),
+	})
+	if err != nil {
+		log.Println("Error getting page from dynamodb")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+	if err := dynamodbattribute.UnmarshalMap(current.Item, &page); err != nil {
+		log.Println("Error unmarshalling into page")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+	if page.ID == "" || page.Type != "page" || page.DeletedAt == nil || access.SiteOf(page.Path) != sitePath {
+		log.Println("No page in the trash to restore")
+		return Response{StatusCode: http.StatusNotFound}, nil
+	}
+	principal := auth.FromContext(ctx)
+	role, err := policy.Role(principal, access.SiteOf(page.Path))
+	if err != nil {
+		log.Println("Error getting member from dynamodb")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+	if !access.CanEditPage(role, principal, page.Author) {
+		return Response{StatusCode: http.StatusForbidden}, nil
+	}
+
+	update := expression.Remove(expression.Name("deletedAt")).Remove(expression.Name("expiresAt"))
+	condition := expression.AttributeExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		log.Println("Error building dynamodb expression")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	result, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                       key,
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllOld),
+		TableName:                 aws.String(table),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		log.Println("No page in the trash to restore")
+		return Response{StatusCode: http.StatusNotFound}, nil
+	}
+	if err != nil {
+		log.Println("Error restoring page in dynamodb")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	page = Page{}
+	err = dynamodbattribute.UnmarshalMap(result.Attributes, &page)
+	if err != nil {
+		log.Println("Error unmarshalling into page")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+	// the old values are the page in the trash, so take it out the way it is now stored
+	before := audit.Snapshot(&page)
+	page.DeletedAt = nil
+	page.ExpiresAt = 0
+
+	// the page was taken out of its tags when it went in the trash
+	work := store.NewUnitOfWork(db, table)
+	if err = tags.Index(work, sitePath, page.ID, page.Version, page.UpdatedAt, nil, page.Tags); err == nil {
+		err = work.Commit()
+	}
+	if err != nil {
+		log.Println("Error adding page back to its tags:", err)
+	}
+	if err := search.Update(index, sitePath, nil, document(&page)); err != nil {
+		log.Println("Error adding page back to search:", err)
+	}
+	target := audit.Target{Type: "page", ID: page.ID, Version: page.Version, Path: page.Path}
+	if err := trail.Append(audit.NewEntry(request.RequestContext, audit.Restore, target, before, &page)); err != nil {
+		log.Println("Error appending to audit trail:", err)
+	}
+
+	body, err := json.Marshal(page)
+	if err != nil {
+		log.Println("Error marshalling page into json for response body")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// document returns the text of the page that is indexed for search
+func document(page *Page) *search.Document {
+	return &search.Document{
+		ID:          page.ID,
+		Version:     page.Version,
+		Name:        aws.StringValue(page.Name),
+		Description: aws.StringValue(page.Description),
+		Keywords:    aws.StringValue(page.Keywords),
+		Content:     search.Text(page.Blocks, page.Fields),
+	}
+}
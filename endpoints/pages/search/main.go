@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/search"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// defaultLimit & maxLimit bound how many pages a search returns
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// batchGetSize is the maximum number of keys DynamoDB accepts in one BatchGetItem call
+const batchGetSize = 100
+
+// maxRetries bounds how many times unprocessed keys are resubmitted before giving up
+const maxRetries = 5
+
+// Page defines the fields of the page model returned in search results
+type Page struct {
+	ID          string     `json:"id"`
+	Version     string     `json:"version"`
+	Path        string     `json:"path"`
+	Name        *string    `json:"name,omitempty"`
+	Description *string    `json:"description,omitempty"`
+	UpdatedAt   time.Time  `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Result is a page matching the search, with its relevance score
+type Result struct {
+	Page
+	Score float64 `json:"score"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var index search.Index
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		index = &search.Table{DB: db, Name: table}
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It returns the site's pages with every word of `q` in their name, description, keywords or
+// content, most relevant first, up to `limit` of them. The matches come from the search index,
+// and the pages themselves are read to return their current path & name.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+	params := request.QueryStringParameters
+
+	// only the site's members may search its pages
+	role, err := policy.Role(auth.FromContext(ctx), sitePath)
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Viewer) {
+		return problemResponse(http.StatusForbidden, "Only the site's members may search its pages"), nil
+	}
+
+	query := strings.TrimSpace(params["q"])
+	if len(search.Tokenize(query)) == 0 {
+		return problemResponse(http.StatusBadRequest, "Query needs at least one word to search for"), nil
+	}
+
+	limit := defaultLimit
+	if params["limit"] != "" {
+		n, err := strconv.Atoi(params["limit"])
+		if err != nil || n < 1 || n > maxLimit {
+			return problemResponse(http.StatusBadRequest, "Limit must be from 1 to "+strconv.Itoa(maxLimit)), nil
+		}
+		limit = n
+	}
+
+	matches, err := search.Search(index, sitePath, query)
+	if err != nil {
+		log.Println("Error searching pages:", err)
+		return problemResponse(http.StatusInternalServerError, "Error searching pages"), nil
+	}
+
+	results, err := matchedPages(sitePath, matches, limit)
+	if err != nil {
+		log.Println("Error getting matched pages from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting matched pages"), nil
+	}
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		log.Println("Error marshalling results into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing results"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// matchedPages gets the site's pages of the matches in batches, in order of relevance, until there
+// are enough results. Pages that are gone, in the trash or no longer the site's are skipped, since
+// the search index can briefly lag behind them.
+func matchedPages(sitePath string, matches []search.Match, limit int) ([]Result, error) {
+	results := []Result{}
+
+	projection := expression.NamesList(expression.Name("id"), expression.Name("version"), expression.Name("path"), expression.Name("name"), expression.Name("description"), expression.Name("updatedAt"), expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithProjection(projection).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(matches) && len(results) < limit; start += batchGetSize {
+		end := start + batchGetSize
+		if end > len(matches) {
+			end = len(matches)
+		}
+
+		var keys []map[string]*dynamodb.AttributeValue
+		for _, match := range matches[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				"id":      {S: aws.String(match.ID)},
+				"version": {S: aws.String(match.Version)},
+			})
+		}
+
+		// BatchGetItem returns pages in any order, so they're put back in order of relevance
+		pages := map[string]Page{}
+		pending := map[string]*dynamodb.KeysAndAttributes{table: {
+			Keys:                     keys,
+			ProjectionExpression:     expr.Projection(),
+			ExpressionAttributeNames: expr.Names(),
+		}}
+		for attempt := 0; pending[table] != nil && len(pending[table].Keys) > 0; attempt++ {
+			if attempt > maxRetries {
+				return nil, errors.New("unprocessed keys after retries")
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(1<<uint(attempt-1)) * 100 * time.Millisecond)
+			}
+
+			result, err := db.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				return nil, err
+			}
+
+			var batch []Page
+			if err := dynamodbattribute.UnmarshalListOfMaps(result.Responses[table], &batch); err != nil {
+				return nil, err
+			}
+			for _, page := range batch {
+				pages[page.ID] = page
+			}
+			pending = result.UnprocessedKeys
+		}
+
+		for _, match := range matches[start:end] {
+			page, ok := pages[match.ID]
+			if !ok || page.DeletedAt != nil || len(results) == limit {
+				continue
+			}
+			if page.Path != sitePath && !strings.HasPrefix(page.Path, sitePath+"/") {
+				continue
+			}
+			results = append(results, Result{Page: page, Score: match.Score})
+		}
+	}
+
+	return results, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
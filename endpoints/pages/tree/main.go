@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Page defines the fields of the page model needed to build the tree
+type Page struct {
+	ID        string    `json:"id"`
+	Version   string    `json:"version"`
+	Path      string    `json:"path"`
+	ParentID  *string   `json:"parentId,omitempty"`
+	Order     int       `json:"order,omitempty"`
+	Name      *string   `json:"name,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Node is a page in the navigation tree, along with its children in sibling order
+type Node struct {
+	ID       string  `json:"id"`
+	Version  string  `json:"version"`
+	Path     string  `json:"path"`
+	Name     *string `json:"name,omitempty"`
+	Order    int     `json:"order"`
+	Children []*Node `json:"children"`
+}
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It returns the site's pages nested under their parents, with siblings sorted by order then path.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+	// TODO: validate site path
+
+	// only the site's members may read its pages
+	role, err := policy.Role(auth.FromContext(ctx), sitePath)
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+	if !role.AtLeast(access.Viewer) {
+		return Response{StatusCode: http.StatusForbidden}, nil
+	}
+
+	var pages []Page
+
+	// define key condition for sort to begin with
+	sortCondition := expression.Key("path").BeginsWith(sitePath)
+	// "And" the sort key with partition key
+	key := expression.Key("type").Equal(expression.Value("page")).And(sortCondition)
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	// projection represents the list of attribute names
+	projection := expression.NamesList(expression.Name("id"), expression.Name("version"), expression.Name("path"), expression.Name("parentId"), expression.Name("order"), expression.Name("name"), expression.Name("updatedAt"))
+
+	builder := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).WithProjection(projection)
+	expr, err := builder.Build()
+	if err != nil {
+		log.Println("Error building dynamodb expression")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	queryInput := dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var results []Page
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &results); unmarshalErr != nil {
+			return false
+		}
+		// the prefix also matches other sites whose paths start with this one's
+		for _, result := range results {
+			if result.Path == sitePath || strings.HasPrefix(result.Path, sitePath+"/") {
+				pages = append(pages, result)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		log.Println("Error querying pages in dynamodb")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+	if unmarshalErr != nil {
+		log.Println("Error unmarshalling into pages slice")
+		return Response{StatusCode: http.StatusInternalServerError}, unmarshalErr
+	}
+
+	body, err := json.Marshal(buildTree(pages))
+	if err != nil {
+		log.Println("Error marshalling tree into json for response body")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// buildTree nests the pages under their parents, using the latest version of each page. Pages
+// without a parent, or whose parent isn't among the pages, are returned as the roots.
+func buildTree(pages []Page) []*Node {
+	latest := map[string]Page{}
+	for _, page := range pages {
+		if current, ok := latest[page.ID]; !ok || page.UpdatedAt.After(current.UpdatedAt) {
+			latest[page.ID] = page
+		}
+	}
+
+	nodes := map[string]*Node{}
+	for id, page := range latest {
+		nodes[id] = &Node{ID: page.ID, Version: page.Version, Path: page.Path, Name: page.Name, Order: page.Order, Children: []*Node{}}
+	}
+
+	roots := []*Node{}
+	for id, page := range latest {
+		var parent *Node
+		if page.ParentID != nil && !inCycle(id, latest) {
+			parent = nodes[*page.ParentID]
+		}
+		if parent != nil {
+			parent.Children = append(parent.Children, nodes[id])
+		} else {
+			roots = append(roots, nodes[id])
+		}
+	}
+
+	sortNodes(roots)
+	return roots
+}
+
+// inCycle reports whether following the parents up from the page leads back to it, which moves
+// prevent but would otherwise make the tree endless
+func inCycle(id string, pages map[string]Page) bool {
+	seen := map[string]bool{}
+	for current := id; ; {
+		page, ok := pages[current]
+		if !ok || page.ParentID == nil {
+			return false
+		}
+		if *page.ParentID == id {
+			return true
+		}
+		if seen[current] {
+			// a cycle further up, which the pages on it will detect for themselves
+			return false
+		}
+		seen[current] = true
+		current = *page.ParentID
+	}
+}
+
+// sortNodes orders siblings by order then path, all the way down the tree
+func sortNodes(nodes []*Node) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Order != nodes[j].Order {
+			return nodes[i].Order < nodes[j].Order
+		}
+		return nodes[i].Path < nodes[j].Path
+	})
+
+	for _, node := range nodes {
+		sortNodes(node.Children)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -15,26 +17,63 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/tags"
 )
 
-type Response events.APIGatewayProxyResponse
-type Request events.APIGatewayProxyRequest
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
 
 // Page defines the fields of the page model
 type Page struct {
-	ID          string    `json:"id"`
-	Version     string    `json:"version"`
-	Path        string    `json:"path"`
-	Type        string    `json:"type"`
-	Name        *string   `json:"name,omitempty"`
-	Description *string   `json:"description,omitempty"`
-	Keywords    *string   `json:"keywords,omitempty"`
-	Author      *string   `json:"author,omitempty"`
-	CreatedAt   time.Time `json:"createdAt,omitempty"`
-	UpdatedAt   time.Time `json:"updatedAt,omitempty"`
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Path        string                 `json:"path"`
+	ParentID    *string                `json:"parentId,omitempty"`
+	Order       int                    `json:"order,omitempty"`
+	Type        string                 `json:"type"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Tags        []string               `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Author      *string                `json:"author,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	ContentType *string                `json:"contentType,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Social      *render.Social         `json:"social,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time             `json:"deletedAt,omitempty"`
+	ExpiresAt   int64                  `json:"expiresAt,omitempty"`
+}
+
+// ContentType defines the fields of the content type model needed to validate page fields
+type ContentType struct {
+	Name   string          `json:"name"`
+	Schema *content.Schema `json:"schema"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
 }
 
 var db *dynamodb.DynamoDB
+var policy *access.Policy
+var trail audit.Log
+var index search.Index
 var region, stage, table string
 var currentTime time.Time
 
@@ -53,20 +92,25 @@ func init() {
 	// TODO: validate env vars
 }
 
-// main starts the session, news up the db & invokes lambda handler
+// main starts the session, news up the db & access policy & invokes lambda handler
 func main() {
 	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
 	if err != nil {
 		log.Println("Failed to connect to AWS:", err)
 	} else {
 		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		trail = &audit.Table{DB: db, Name: table}
+		index = &search.Table{DB: db, Name: table}
 	}
 
-	lambda.Start(Handler)
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
 }
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call in main()
 func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+
 	// Get existing page from datbase
 	var original Page
 	pageid := aws.String(request.PathParameters["pageid"])
@@ -84,28 +128,39 @@ func Handler(ctx context.Context, request Request) (Response, error) {
 		TableName: aws.String(table),
 	})
 	if err != nil {
-		log.Println("Error getting page from dynamodb")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error getting page from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting page"), nil
 	}
 
 	err = dynamodbattribute.UnmarshalMap(result.Item, &original)
 	if err != nil {
-		log.Println("Error unmarshalling into page")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error unmarshalling into page:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading page"), nil
 	}
 
-	// make sure there's a valid page returned from the database
-	if original.ID == "" {
+	// make sure a page of the site is returned from the database, rather than another kind of item
+	if original.ID == "" || original.Type != "page" || original.DeletedAt != nil || access.SiteOf(original.Path) != sitePath {
 		log.Println("No page returned from database query")
-		return Response{StatusCode: http.StatusNotFound}, err
+		return problemResponse(http.StatusNotFound, "No page found with that id and version"), nil
+	}
+
+	// editors may change any page, and authors their own
+	principal := auth.FromContext(ctx)
+	role, err := policy.Role(principal, access.SiteOf(original.Path))
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !access.CanEditPage(role, principal, original.Author) {
+		return problemResponse(http.StatusForbidden, "Only the site's editors and the page's author may update it"), nil
 	}
 
 	// Get page changes from request body
 	var changes Page
 	err = json.Unmarshal([]byte(request.Body), &changes)
 	if err != nil {
-		log.Println("Error unmarshalling request body into page")
-		return Response{StatusCode: http.StatusBadRequest}, err
+		log.Println("Error unmarshalling request body into page:", err)
+		return problemResponse(http.StatusBadRequest, "Invalid page in request body"), nil
 	}
 
 	// combine original page with requested changes
@@ -115,44 +170,99 @@ func Handler(ctx context.Context, request Request) (Response, error) {
 	if len(changes.Path) == 0 {
 		changes.Path = original.Path
 	}
+	// changing the path here would break inbound links & child paths, so it goes through move
+	if strings.ToLower(changes.Path) != original.Path {
+		log.Println("Can't change page path with update, move the page instead")
+		return problemResponse(http.StatusBadRequest, "Can't change page path with update, move the page instead"), nil
+	}
 	changes.CreatedAt = original.CreatedAt
+	changes.DeletedAt = nil // deleting & restoring go through their own endpoints
+	changes.ParentID = nil  // moving goes through its own endpoint, which keeps child paths consistent
+	changes.ExpiresAt = 0
 	changes.UpdatedAt = time.Now()
+	// sending tags or keywords replaces the page's tags, with tags taking precedence
+	previousTags := original.Tags
+	previousDocument := document(&original)
+	before := audit.Snapshot(&original)
+	changedTags, changedKeywords := changes.Tags, changes.Keywords
 	updated, err := mergePages(&original, &changes)
 	if err != nil {
-		log.Println("Error merging page attributes")
-		return Response{StatusCode: http.StatusBadRequest}, err
+		log.Println("Error merging page attributes:", err)
+		return problemResponse(http.StatusBadRequest, "Invalid page changes"), nil
 	}
-
-	av, err := dynamodbattribute.MarshalMap(updated)
+	// authors can't give their pages to someone else
+	if !access.CanEditPage(role, principal, updated.Author) {
+		return problemResponse(http.StatusForbidden, "Authors can't give their pages to other authors"), nil
+	}
+	if changedTags != nil || changedKeywords != nil {
+		updated.Tags, updated.Keywords = changedTags, changedKeywords
+	}
+	updated.Tags, err = tags.Normalize(updated.Tags, updated.Keywords)
 	if err != nil {
-		log.Println("Error marshalling page into dynamodb attribute")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Invalid tags:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
 	}
-
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(table),
+	updated.Keywords = tags.Keywords(updated.Tags)
+	// fields set to null in the changes are removed
+	for name, value := range updated.Fields {
+		if value == nil {
+			delete(updated.Fields, name)
+		}
+	}
+	if err := content.Validate(updated.Blocks); err != nil {
+		log.Println("Invalid page content:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if err := updated.Social.Validate(); err != nil {
+		log.Println("Invalid social metadata:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if status, err := validateFields(sitePath, updated); err != nil {
+		log.Println("Invalid page fields:", err)
+		if status == http.StatusInternalServerError {
+			return problemResponse(status, "Error getting content type"), nil
+		}
+		return problemResponse(status, err.Error()), nil
 	}
 
-	_, err = db.PutItem(input)
+	// the page is written together with the changes to its tag index items, and only over a page
+	// that's still there, so one deleted since it was read isn't brought back
+	work := store.NewUnitOfWork(db, table)
+	if err = work.Replace(updated); err == nil {
+		err = tags.Index(work, sitePath, updated.ID, updated.Version, updated.UpdatedAt, previousTags, updated.Tags)
+	}
+	if err == nil {
+		err = work.Commit()
+	}
+	if err == store.ErrConflict {
+		log.Println("Page deleted or changed while being updated")
+		return problemResponse(http.StatusConflict, "Page was deleted or changed in the meantime"), nil
+	}
 	if err != nil {
-		log.Println("Error putting item into DyanmoDB")
-		return Response{StatusCode: http.StatusBadRequest}, err
+		log.Println("Error writing page & tags transaction to DynamoDB:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing page"), nil
+	}
+
+	// the page is already written, so failing to index & audit it is logged rather than reported
+	if err := search.Update(index, sitePath, previousDocument, document(updated)); err != nil {
+		log.Println("Error indexing page for search:", err)
+	}
+	target := audit.Target{Type: "page", ID: updated.ID, Version: updated.Version, Path: updated.Path}
+	if err := trail.Append(audit.NewEntry(request.RequestContext, audit.Update, target, before, updated)); err != nil {
+		log.Println("Error appending to audit trail:", err)
 	}
 
 	body, err := json.Marshal(updated)
 	if err != nil {
-		log.Println("Error marshalling page into json for response body")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error marshalling page into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing page"), nil
 	}
 
 	response := Response{
 		StatusCode: http.StatusOK,
 		Body:       string(body),
 		Headers: map[string]string{
-			"Content-Type":                     "application/json",
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Credentials": "true",
+			"Content-Type": "application/json",
 		},
 	}
 
@@ -177,3 +287,90 @@ func mergePages(original, changes *Page) (*Page, error) {
 
 	return original, nil
 }
+
+// validateFields checks the page's custom fields against the schema of its content type,
+// returning the status to respond with when they don't pass
+func validateFields(sitePath string, page *Page) (int, error) {
+	if page.ContentType == nil {
+		if len(page.Fields) > 0 {
+			return http.StatusBadRequest, errors.New("Page fields need a content type")
+		}
+		return http.StatusOK, nil
+	}
+
+	contentType, err := getContentType(sitePath, *page.ContentType)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if contentType == nil {
+		return http.StatusBadRequest, fmt.Errorf("Unknown content type %q", *page.ContentType)
+	}
+	if err := contentType.Schema.Validate(page.Fields); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// getContentType returns the site's content type with the name, or nil if there isn't one
+func getContentType(sitePath, name string) (*ContentType, error) {
+	var contentTypes []ContentType
+
+	key := expression.Key("type").Equal(expression.Value("contentType")).And(expression.Key("path").Equal(expression.Value(strings.ToLower(sitePath))))
+	// content types in the trash can't be used for pages
+	filter := expression.Name("name").Equal(expression.Value(name)).And(expression.AttributeNotExists(expression.Name("deletedAt")))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &contentTypes)
+	if err != nil || len(contentTypes) == 0 {
+		return nil, err
+	}
+
+	return &contentTypes[0], nil
+}
+
+// document returns the text of the page that is indexed for search
+func document(page *Page) *search.Document {
+	return &search.Document{
+		ID:          page.ID,
+		Version:     page.Version,
+		Name:        aws.StringValue(page.Name),
+		Description: aws.StringValue(page.Description),
+		Keywords:    aws.StringValue(page.Keywords),
+		Content:     search.Text(page.Blocks, page.Fields),
+	}
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/google/uuid"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Redirect sends requests for a source path on to a target path or URL. Redirects with an
+// expiry stop applying once it has passed, and are then purged by the table's TTL.
+type Redirect struct {
+	ID        string    `json:"id"`
+	Version   string    `json:"version"`
+	Path      string    `json:"path"`
+	Type      string    `json:"type"`
+	Target    string    `json:"target"`
+	Status    int       `json:"status"`
+	ExpiresAt int64     `json:"expiresAt,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It creates a redirect from a source path under the site, which must not already redirect.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	// editors & owners may change the site's redirects
+	role, err := policy.Role(auth.FromContext(ctx), request.PathParameters["siteid"])
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Editor) {
+		return problemResponse(http.StatusForbidden, "Only the site's editors and owners may change its redirects"), nil
+	}
+
+	sitePath := request.PathParameters["siteid"]
+
+	var redirect *Redirect
+	err = json.Unmarshal([]byte(request.Body), &redirect)
+	if redirect == nil || err != nil {
+		log.Println("Error unmarshalling request body into redirect:", err)
+		return problemResponse(http.StatusBadRequest, "Request body is not a valid redirect"), nil
+	}
+
+	currentTime := time.Now()
+	redirect.ID = uuid.New().String()
+	redirect.Version = uuid.New().String()
+	redirect.Type = "redirect"
+	redirect.Path = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(redirect.Path)), "/")
+	redirect.Target = strings.TrimSpace(redirect.Target)
+	if redirect.Status == 0 {
+		redirect.Status = http.StatusMovedPermanently
+	}
+	redirect.CreatedAt = currentTime
+	redirect.UpdatedAt = currentTime
+
+	if detail := validateRedirect(redirect, sitePath, currentTime); detail != "" {
+		return problemResponse(http.StatusBadRequest, detail), nil
+	}
+
+	existing, err := countRedirects(redirect.Path)
+	if err != nil {
+		log.Println("Error querying redirects in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying redirects"), nil
+	}
+	if existing > 0 {
+		return problemResponse(http.StatusConflict, "A redirect from "+redirect.Path+" already exists"), nil
+	}
+
+	av, err := dynamodbattribute.MarshalMap(redirect)
+	if err != nil {
+		log.Println("Error marshalling redirect into dynamodb attribute:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing redirect"), nil
+	}
+
+	_, err = db.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(table),
+	})
+	if err != nil {
+		log.Println("Error putting item into DyanmoDB:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing redirect"), nil
+	}
+
+	body, err := json.Marshal(redirect)
+	if err != nil {
+		log.Println("Error marshalling redirect into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing redirect"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusCreated,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// validateRedirect returns what is wrong with the redirect, or an empty string when it is valid
+func validateRedirect(redirect *Redirect, sitePath string, currentTime time.Time) string {
+	switch {
+	case !strings.HasPrefix(redirect.Path, strings.ToLower(sitePath)+"/"):
+		return "Redirect path must be a path under the site"
+	case redirect.Target == "":
+		return "Redirect target must be a path or URL"
+	case strings.TrimSuffix(strings.ToLower(redirect.Target), "/") == redirect.Path:
+		return "Redirect can't target its own path"
+	case redirect.Status != http.StatusMovedPermanently && redirect.Status != http.StatusFound:
+		return "Redirect status must be 301 or 302"
+	case redirect.ExpiresAt != 0 && redirect.ExpiresAt <= currentTime.Unix():
+		return "Redirect expiry must be in the future"
+	}
+
+	return ""
+}
+
+// countRedirects returns how many redirects there are from exactly the path
+func countRedirects(path string) (int64, error) {
+	key := expression.Key("type").Equal(expression.Value("redirect")).And(expression.Key("path").Equal(expression.Value(path)))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		Select:                    aws.String(dynamodb.SelectCount),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return aws.Int64Value(result.Count), nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Redirect sends requests for a source path on to a target path or URL. Redirects with an
+// expiry stop applying once it has passed, and are then purged by the table's TTL.
+type Redirect struct {
+	ID        string    `json:"id"`
+	Version   string    `json:"version"`
+	Path      string    `json:"path"`
+	Type      string    `json:"type"`
+	Target    string    `json:"target"`
+	Status    int       `json:"status"`
+	ExpiresAt int64     `json:"expiresAt,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var region, stage, table string
+var noContent bool
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// respond with 204 and no body instead of the deleted redirect
+	noContent = os.Getenv("DELETE_NO_CONTENT") == "true"
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// Redirects are deleted permanently rather than moved to the trash. The deleted redirect is
+// returned, or 204 No Content when DELETE_NO_CONTENT is set.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	// editors & owners may change the site's redirects
+	role, err := policy.Role(auth.FromContext(ctx), request.PathParameters["siteid"])
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Editor) {
+		return problemResponse(http.StatusForbidden, "Only the site's editors and owners may change its redirects"), nil
+	}
+
+	var deleted Redirect
+
+	redirect, err := getRedirect(request.PathParameters["redirectid"], request.PathParameters["siteid"])
+	if err != nil {
+		log.Println("Error getting redirect from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting redirect"), nil
+	}
+	if redirect == nil {
+		return problemResponse(http.StatusNotFound, "No redirect found with that id"), nil
+	}
+
+	key := map[string]*dynamodb.AttributeValue{
+		"id":      {S: aws.String(redirect.ID)},
+		"version": {S: aws.String(redirect.Version)},
+	}
+
+	result, err := db.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:                 key,
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ReturnValues:        aws.String(dynamodb.ReturnValueAllOld),
+		TableName:           aws.String(table),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return problemResponse(http.StatusNotFound, "No redirect found with that id"), nil
+	}
+	if err != nil {
+		log.Println("Error deleting redirect in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error deleting redirect"), nil
+	}
+
+	if noContent {
+		response := Response{
+			StatusCode: http.StatusNoContent,
+		}
+
+		return response, nil
+	}
+
+	err = dynamodbattribute.UnmarshalMap(result.Attributes, &deleted)
+	if err != nil {
+		log.Println("Error unmarshalling into redirect:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading deleted redirect"), nil
+	}
+
+	body, err := json.Marshal(deleted)
+	if err != nil {
+		log.Println("Error marshalling redirect into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing deleted redirect"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// getRedirect returns the redirect with the id when it is one of the site's, or nil otherwise
+func getRedirect(id, sitePath string) (*Redirect, error) {
+	var redirects []Redirect
+
+	key := expression.Key("id").Equal(expression.Value(id))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &redirects)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, redirect := range redirects {
+		if redirect.Type == "redirect" && strings.HasPrefix(redirect.Path, strings.ToLower(sitePath)+"/") {
+			return &redirect, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Redirect sends requests for a source path on to a target path or URL. Redirects with an
+// expiry stop applying once it has passed, and are then purged by the table's TTL.
+type Redirect struct {
+	ID        string    `json:"id"`
+	Version   string    `json:"version"`
+	Path      string    `json:"path"`
+	Type      string    `json:"type"`
+	Target    string    `json:"target"`
+	Status    int       `json:"status"`
+	ExpiresAt int64     `json:"expiresAt,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Page defines the fields of the page model the renderer needs to serve it
+type Page struct {
+	ID        string    `json:"id"`
+	Version   string    `json:"version"`
+	Path      string    `json:"path"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Resolution tells the edge renderer what to do with a request for the path: either serve the
+// page, or redirect to the location with the status
+type Resolution struct {
+	Path     string `json:"path"`
+	Action   string `json:"action"`
+	Status   int    `json:"status"`
+	Location string `json:"location,omitempty"`
+	Page     *Page  `json:"page,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Optional(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// maxHops is how many redirects in a chain are followed before giving up on it
+const maxHops = 10
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It resolves the `path` query parameter: a page at the path is served, otherwise any chain of
+// unexpired redirects from it is followed to its end and collapsed into a single redirect.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+	requested := normalizePath(request.QueryStringParameters["path"])
+	if requested != sitePath && !strings.HasPrefix(requested, sitePath+"/") {
+		return problemResponse(http.StatusBadRequest, "Path must be a path under the site"), nil
+	}
+
+	currentTime := time.Now()
+	resolution := Resolution{Path: requested, Action: "serve", Status: http.StatusOK}
+	seen := map[string]bool{}
+	for current := requested; ; {
+		if len(seen) > maxHops {
+			return problemResponse(http.StatusLoopDetected, "Too many redirects from "+requested), nil
+		}
+		if seen[current] {
+			return problemResponse(http.StatusLoopDetected, "Redirect loop from "+requested), nil
+		}
+		seen[current] = true
+
+		// a page at the path takes precedence over any stale redirect from it
+		page, err := findPage(current)
+		if err != nil {
+			log.Println("Error querying pages in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error querying pages"), nil
+		}
+		if page != nil {
+			if resolution.Action == "serve" {
+				resolution.Page = page
+			}
+			break
+		}
+
+		redirect, err := findRedirect(current, currentTime)
+		if err != nil {
+			log.Println("Error querying redirects in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error querying redirects"), nil
+		}
+		if redirect == nil {
+			break
+		}
+
+		// the chain is only permanent when every redirect on it is
+		resolution.Action = "redirect"
+		if resolution.Status != http.StatusFound {
+			resolution.Status = redirect.Status
+		}
+		resolution.Location = redirect.Target
+		if isURL(redirect.Target) {
+			break
+		}
+		current = normalizePath(redirect.Target)
+	}
+
+	if resolution.Action == "serve" && resolution.Page == nil {
+		return problemResponse(http.StatusNotFound, "No page or redirect found at "+requested), nil
+	}
+
+	body, err := json.Marshal(resolution)
+	if err != nil {
+		log.Println("Error marshalling resolution into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing resolution"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// findPage returns the latest version of the page at exactly the path, or nil if there isn't one
+func findPage(path string) (*Page, error) {
+	var pages []Page
+
+	key := expression.Key("type").Equal(expression.Value("page")).And(expression.Key("path").Equal(expression.Value(path)))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	projection := expression.NamesList(expression.Name("id"), expression.Name("version"), expression.Name("path"), expression.Name("updatedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).WithProjection(projection).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	queryInput := dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var results []Page
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &results); unmarshalErr != nil {
+			return false
+		}
+		pages = append(pages, results...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil || len(pages) == 0 {
+		return nil, err
+	}
+
+	latest := pages[0]
+	for _, page := range pages[1:] {
+		if page.UpdatedAt.After(latest.UpdatedAt) {
+			latest = page
+		}
+	}
+
+	return &latest, nil
+}
+
+// findRedirect returns the unexpired redirect from exactly the path, or nil if there isn't one.
+// Expired redirects are filtered out here since the TTL can take a while to purge them.
+func findRedirect(path string, currentTime time.Time) (*Redirect, error) {
+	var redirects []Redirect
+
+	key := expression.Key("type").Equal(expression.Value("redirect")).And(expression.Key("path").Equal(expression.Value(path)))
+	filter := expression.AttributeNotExists(expression.Name("expiresAt")).Or(expression.Name("expiresAt").GreaterThan(expression.Value(currentTime.Unix())))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &redirects)
+	if err != nil || len(redirects) == 0 {
+		return nil, err
+	}
+
+	return &redirects[0], nil
+}
+
+// normalizePath lowercases the path and drops any trailing slash, the way paths are stored
+func normalizePath(path string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(path)), "/")
+}
+
+// isURL reports whether the redirect target is an absolute URL rather than a path on the site
+func isURL(target string) bool {
+	return strings.Contains(target, "://")
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Redirect sends requests for a source path on to a target path or URL. Redirects with an
+// expiry stop applying once it has passed, and are then purged by the table's TTL.
+type Redirect struct {
+	ID        string    `json:"id"`
+	Version   string    `json:"version"`
+	Path      string    `json:"path"`
+	Type      string    `json:"type"`
+	Target    string    `json:"target"`
+	Status    int       `json:"status"`
+	ExpiresAt int64     `json:"expiresAt,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It merges the changes in the request body into the redirect.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	// editors & owners may change the site's redirects
+	role, err := policy.Role(auth.FromContext(ctx), request.PathParameters["siteid"])
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Editor) {
+		return problemResponse(http.StatusForbidden, "Only the site's editors and owners may change its redirects"), nil
+	}
+
+	sitePath := request.PathParameters["siteid"]
+
+	original, err := getRedirect(request.PathParameters["redirectid"], sitePath)
+	if err != nil {
+		log.Println("Error getting redirect from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting redirect"), nil
+	}
+	if original == nil {
+		return problemResponse(http.StatusNotFound, "No redirect found with that id"), nil
+	}
+	originalPath := original.Path
+
+	// Get redirect changes from request body
+	var changes Redirect
+	err = json.Unmarshal([]byte(request.Body), &changes)
+	if err != nil {
+		log.Println("Error unmarshalling request body into redirect:", err)
+		return problemResponse(http.StatusBadRequest, "Request body is not a valid redirect"), nil
+	}
+
+	// combine original redirect with requested changes
+	currentTime := time.Now()
+	changes.ID = original.ID
+	changes.Version = original.Version
+	changes.Type = "redirect"
+	changes.Path = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(changes.Path)), "/")
+	changes.Target = strings.TrimSpace(changes.Target)
+	changes.CreatedAt = original.CreatedAt
+	changes.UpdatedAt = currentTime
+	updated, err := mergeRedirects(original, &changes)
+	if err != nil {
+		log.Println("Error merging redirect attributes:", err)
+		return problemResponse(http.StatusBadRequest, "Request body is not a valid redirect"), nil
+	}
+
+	if detail := validateRedirect(updated, sitePath, currentTime); detail != "" {
+		return problemResponse(http.StatusBadRequest, detail), nil
+	}
+
+	if updated.Path != originalPath {
+		existing, err := countRedirects(updated.Path)
+		if err != nil {
+			log.Println("Error querying redirects in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error querying redirects"), nil
+		}
+		if existing > 0 {
+			return problemResponse(http.StatusConflict, "A redirect from "+updated.Path+" already exists"), nil
+		}
+	}
+
+	av, err := dynamodbattribute.MarshalMap(updated)
+	if err != nil {
+		log.Println("Error marshalling redirect into dynamodb attribute:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing redirect"), nil
+	}
+
+	_, err = db.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(table),
+	})
+	if err != nil {
+		log.Println("Error putting item into DyanmoDB:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing redirect"), nil
+	}
+
+	body, err := json.Marshal(updated)
+	if err != nil {
+		log.Println("Error marshalling redirect into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing redirect"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// mergeRedirects merges two structs by serializing the struct with the changes to JSON, then
+// deserializes the changes into the original
+func mergeRedirects(original, changes *Redirect) (*Redirect, error) {
+	// serialize changes to JSON, leaving out the fields that weren't changed
+	changeJSON, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	err = json.Unmarshal(changeJSON, &fields)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		if value == "" || value == float64(0) {
+			delete(fields, name)
+		}
+	}
+	changeJSON, err = json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// deserialize the "changes" redirect struct into the original
+	err = json.Unmarshal(changeJSON, &original)
+	if err != nil {
+		return nil, err
+	}
+
+	return original, nil
+}
+
+// validateRedirect returns what is wrong with the redirect, or an empty string when it is valid
+func validateRedirect(redirect *Redirect, sitePath string, currentTime time.Time) string {
+	switch {
+	case !strings.HasPrefix(redirect.Path, strings.ToLower(sitePath)+"/"):
+		return "Redirect path must be a path under the site"
+	case redirect.Target == "":
+		return "Redirect target must be a path or URL"
+	case strings.TrimSuffix(strings.ToLower(redirect.Target), "/") == redirect.Path:
+		return "Redirect can't target its own path"
+	case redirect.Status != http.StatusMovedPermanently && redirect.Status != http.StatusFound:
+		return "Redirect status must be 301 or 302"
+	case redirect.ExpiresAt != 0 && redirect.ExpiresAt <= currentTime.Unix():
+		return "Redirect expiry must be in the future"
+	}
+
+	return ""
+}
+
+// countRedirects returns how many redirects there are from exactly the path
+func countRedirects(path string) (int64, error) {
+	key := expression.Key("type").Equal(expression.Value("redirect")).And(expression.Key("path").Equal(expression.Value(path)))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		Select:                    aws.String(dynamodb.SelectCount),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return aws.Int64Value(result.Count), nil
+}
+
+// getRedirect returns the redirect with the id when it is one of the site's, or nil otherwise
+func getRedirect(id, sitePath string) (*Redirect, error) {
+	var redirects []Redirect
+
+	key := expression.Key("id").Equal(expression.Value(id))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &redirects)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, redirect := range redirects {
+		if redirect.Type == "redirect" && strings.HasPrefix(redirect.Path, strings.ToLower(sitePath)+"/") {
+			return &redirect, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -15,12 +14,22 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/sitemap"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/tags"
 	"github.com/google/uuid"
 )
 
-type Response events.APIGatewayProxyResponse
-type Request events.APIGatewayProxyRequest
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
 type SiteStatus int
 
 const (
@@ -30,22 +39,57 @@ const (
 
 // Site defines the fields of the site model
 type Site struct {
-	ID           string     `json:"id"`
-	Version      string     `json:"version"`
-	Path         string     `json:"path"`
-	Type         string     `json:"type"`
-	Status       SiteStatus `json:"status,omitempty"`
-	Name         *string    `json:"name,omitempty"`
-	Description  *string    `json:"description,omitempty"`
-	Keywords     *string    `json:"keywords,omitempty"`
-	URL          *string    `json:"url,omitempty"`
-	TagManagerID *string    `json:"tagManagerId,omitempty"`
-	CardImageURL *string    `json:"cardImageUrl,omitempty"`
-	CreatedAt    time.Time  `json:"createdAt,omitempty"`
-	UpdatedAt    time.Time  `json:"updatedAt,omitempty"`
+	ID           string                `json:"id"`
+	Version      string                `json:"version"`
+	Path         string                `json:"path"`
+	Type         string                `json:"type"`
+	Tenant       string                `json:"tenant,omitempty"`
+	Status       SiteStatus            `json:"status,omitempty"`
+	Name         *string               `json:"name,omitempty"`
+	Description  *string               `json:"description,omitempty"`
+	Keywords     *string               `json:"keywords,omitempty"`
+	Tags         []string              `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	URL          *string               `json:"url,omitempty"`
+	TagManagerID *string               `json:"tagManagerId,omitempty"`
+	CardImageURL *string               `json:"cardImageUrl,omitempty"`
+	Theme        *string               `json:"theme,omitempty"`
+	Robots       []sitemap.RobotsGroup `json:"robots,omitempty"`
+	Social       *render.Social        `json:"social,omitempty"`
+	CreatedAt    time.Time             `json:"createdAt,omitempty"`
+	UpdatedAt    time.Time             `json:"updatedAt,omitempty"`
+	DeletedAt    *time.Time            `json:"deletedAt,omitempty"`
+	ExpiresAt    int64                 `json:"expiresAt,omitempty"`
 }
 
-var db *dynamodb.DynamoDB
+// Page defines the fields of the page model
+type Page struct {
+	ID          string     `json:"id"`
+	Version     string     `json:"version"`
+	Path        string     `json:"path"`
+	ParentID    *string    `json:"parentId,omitempty"`
+	Order       int        `json:"order,omitempty"`
+	Type        string     `json:"type"`
+	Name        *string    `json:"name,omitempty"`
+	Description *string    `json:"description,omitempty"`
+	Keywords    *string    `json:"keywords,omitempty"`
+	Tags        []string   `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Author      *string    `json:"author,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time  `json:"updatedAt,omitempty"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+	ExpiresAt   int64      `json:"expiresAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db dynamodbiface.DynamoDBAPI
+var trail audit.Log
 var region, stage, table string
 
 func init() {
@@ -66,71 +110,207 @@ func main() {
 		log.Println("Failed to connect to AWS:", err)
 	} else {
 		db = dynamodb.New(session)
+		trail = &audit.Table{DB: db, Name: table}
 	}
 
-	lambda.Start(Handler)
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
 }
 
-// Handler is our lambda handler invoked by the `lambda.Start` function call in main()
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// The site is in the caller's tenant, and the caller becomes its owner. Passing `homePage=true`
+// also creates the site's home page, in the same transaction as the site.
 func Handler(ctx context.Context, request Request) (Response, error) {
 	var site *Site
 	err := json.Unmarshal([]byte(request.Body), &site)
 	if site == nil || err != nil {
-		log.Println("Error unmarshalling request body into site")
-		return Response{StatusCode: http.StatusBadRequest}, err
+		log.Println("Error unmarshalling request body into site:", err)
+		return problemResponse(http.StatusBadRequest, "Invalid site in request body"), nil
 	}
 
 	if strings.TrimSpace(site.Path) == "" {
 		log.Println("Can't create site without path")
-		return Response{StatusCode: http.StatusBadRequest}, errors.New("Can't create site without path")
+		return problemResponse(http.StatusBadRequest, "Can't create site without path"), nil
+	}
+	// page paths are the site's path & the page's below it, and tag index paths are prefixed by
+	// the site's path & a #, so site paths can contain neither
+	if strings.ContainsAny(site.Path, "/#") {
+		log.Println("Can't create site with / or # in its path")
+		return problemResponse(http.StatusBadRequest, "Can't create site with / or # in its path"), nil
 	}
 	if site.Name == nil || strings.TrimSpace(*site.Name) == "" {
 		log.Println("Can't create site without name")
-		return Response{StatusCode: http.StatusBadRequest}, errors.New("Can't create site without name")
+		return problemResponse(http.StatusBadRequest, "Can't create site without name"), nil
+	}
+
+	if err := sitemap.ValidateRobots(site.Robots); err != nil {
+		log.Println("Invalid robots.txt rules:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if err := site.Social.Validate(); err != nil {
+		log.Println("Invalid social metadata:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	site.Tags, err = tags.Normalize(site.Tags, site.Keywords)
+	if err != nil {
+		log.Println("Invalid tags:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	site.Keywords = tags.Keywords(site.Tags)
+
+	principal := auth.FromContext(ctx)
+	if principal == nil {
+		log.Println("Can't create site without a caller to own it")
+		return problemResponse(http.StatusUnauthorized, "Can't create site without a caller to own it"), nil
 	}
 
 	currentTime := time.Now()
 	site.ID = uuid.New().String()
 	site.Version = uuid.New().String()
 	site.Type = "site"
+	site.Tenant = principal.Tenant
 	site.Path = strings.ToLower(site.Path)
 	status := Unpublished
 	site.Status = status
 	site.CreatedAt = currentTime
 	site.UpdatedAt = currentTime
+	site.DeletedAt = nil
+	site.ExpiresAt = 0
 
-	av, err := dynamodbattribute.MarshalMap(site)
+	// members are kept by site path, so creating a site at a taken path would make the caller a
+	// member of the site already there. Sites created before paths were reserved only have their
+	// site item to tell their path is taken.
+	taken, err := pathTaken(site.Path)
 	if err != nil {
-		log.Println("Error marshalling site into dynamodb attribute")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error querying sites in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site path"), nil
+	}
+	if taken {
+		return pathConflict(), nil
 	}
 
-	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(table),
+	// the caller owns the site they create
+	owner := &access.Member{Site: site.Path, Subject: principal.Subject, Role: access.Owner, CreatedAt: currentTime, UpdatedAt: currentTime}
+
+	var home *Page
+	if request.QueryStringParameters["homePage"] == "true" {
+		home = &Page{
+			ID:          uuid.New().String(),
+			Version:     uuid.New().String(),
+			Path:        site.Path,
+			Type:        "page",
+			Name:        site.Name,
+			Description: site.Description,
+			Keywords:    site.Keywords,
+			Tags:        site.Tags,
+			CreatedAt:   currentTime,
+			UpdatedAt:   currentTime,
+		}
 	}
 
-	_, err = db.PutItem(input)
+	// the site, its path, its owner and its home page are written together, so none exists without
+	// the others, and creating the path's reservation fails when another site holds it
+	work := store.NewUnitOfWork(db, table)
+	if err = work.Create(site); err == nil {
+		err = work.Create(access.Reservation(site.Path, site.ID))
+	}
+	if err == nil {
+		err = work.Create(access.Item(owner))
+	}
+	if err == nil && home != nil {
+		if err = work.Create(home); err == nil {
+			err = tags.Index(work, site.Path, home.ID, home.Version, currentTime, nil, home.Tags)
+		}
+	}
+	if err == nil {
+		err = work.Commit()
+	}
+	if err == store.ErrConflict {
+		return pathConflict(), nil
+	}
 	if err != nil {
-		log.Println("Error putting item into DyanmoDB")
-		return Response{StatusCode: http.StatusBadRequest}, err
+		log.Println("Error writing site transaction to DynamoDB:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing site"), nil
+	}
+
+	// the site is already written, so failing to audit it is logged rather than reported
+	entries := []*audit.Entry{audit.NewEntry(request.RequestContext, audit.Create, audit.Target{Type: "site", ID: site.ID, Version: site.Version, Path: site.Path}, nil, site)}
+	if home != nil {
+		entries = append(entries, audit.NewEntry(request.RequestContext, audit.Create, audit.Target{Type: "page", ID: home.ID, Version: home.Version, Path: home.Path}, nil, home))
+	}
+	for _, entry := range entries {
+		if err := trail.Append(entry); err != nil {
+			log.Println("Error appending to audit trail:", err)
+		}
 	}
 
 	body, err := json.Marshal(site)
 	if err != nil {
-		log.Println("Error marshalling site into json for response body")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error marshalling site into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing site"), nil
 	}
 
 	response := Response{
 		StatusCode: http.StatusOK,
 		Body:       string(body),
 		Headers: map[string]string{
-			"Content-Type":                     "application/json",
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Credentials": "true",
+			"Content-Type": "application/json",
 		},
 	}
 
 	return response, nil
 }
+
+// pathConflict returns the response to creating a site at a taken path. It's the same whichever
+// tenant's site has the path, so it doesn't tell the caller whose it is.
+func pathConflict() Response {
+	log.Println("Can't create site at a path another site has")
+	return problemResponse(http.StatusConflict, "Another site already has that path")
+}
+
+// pathTaken reports whether there's a site at the path, including in the trash, or members left
+// of one the table's TTL is still purging, who would become members of the new site
+func pathTaken(sitePath string) (bool, error) {
+	members, err := (&access.Table{DB: db, Name: table}).Members(sitePath)
+	if err != nil || len(members) > 0 {
+		return len(members) > 0, err
+	}
+
+	key := expression.Key("type").Equal(expression.Value("site")).And(expression.Key("path").Equal(expression.Value(sitePath)))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		Limit:                     aws.Int64(1),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(result.Items) > 0, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -15,6 +15,11 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/store"
 	"github.com/google/uuid"
 )
 
@@ -52,6 +57,77 @@ func TestCreateSite(t *testing.T) {
 	}
 }
 
+func TestCreateSiteWithHomePage(t *testing.T) {
+	memory := store.NewMemory()
+	db = memory
+	table = "go-lambda-dynamo"
+	entries := &audit.Memory{}
+	trail = entries
+
+	request := createSiteRequest(&Site{Name: aws.String("name"), Path: "Path"})
+	request.QueryStringParameters = map[string]string{"homePage": "true"}
+	response, err := Handler(callerContext(), request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("CreateSite Handler: got code %v, error %v; wanted %v", response.StatusCode, err, http.StatusOK)
+	}
+
+	var site Site
+	json.Unmarshal([]byte(response.Body), &site)
+
+	items := memory.Items()
+	if len(items) != 4 {
+		t.Fatalf("CreateSite Handler: got %d items; wanted site, path, owner & home page", len(items))
+	}
+	for _, item := range items {
+		switch *item["type"].S {
+		case "member":
+			if *item["id"].S != "member#path" || *item["version"].S != "user-1" || *item["member"].M["role"].S != "owner" {
+				t.Errorf("CreateSite Handler: got member %v; wanted user-1 owning the site", item)
+			}
+			continue
+		case "sitePath":
+			if *item["id"].S != "path#path" || *item["siteId"].S != site.ID {
+				t.Errorf("CreateSite Handler: got path reservation %v; wanted the site's", item)
+			}
+			continue
+		case "site":
+			if *item["id"].S != site.ID {
+				t.Errorf("CreateSite Handler: got site id %v; wanted %v", *item["id"].S, site.ID)
+			}
+			if item["tenant"] == nil || *item["tenant"].S != "acme-corp" {
+				t.Errorf("CreateSite Handler: got site tenant %v; wanted the caller's", item["tenant"])
+			}
+		}
+		if *item["path"].S != "path" {
+			t.Errorf("CreateSite Handler: got path %v; wanted %v", *item["path"].S, "path")
+		}
+	}
+
+	// the path is taken now
+	response, _ = Handler(callerContext(), createSiteRequest(&Site{Name: aws.String("other"), Path: "path"}))
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("CreateSite Handler: got code %v creating a site at a taken path; wanted %v", response.StatusCode, http.StatusConflict)
+	}
+
+	// a path reserved by a site being created, not yet in the type-path index, is taken too
+	reserved, _ := dynamodbattribute.MarshalMap(access.Reservation("reserved", uuid.New().String()))
+	memory.PutItem(&dynamodb.PutItemInput{Item: reserved, TableName: aws.String(table)})
+	response, _ = Handler(callerContext(), createSiteRequest(&Site{Name: aws.String("other"), Path: "Reserved"}))
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("CreateSite Handler: got code %v creating a site at a reserved path; wanted %v", response.StatusCode, http.StatusConflict)
+	}
+
+	response, _ = Handler(callerContext(), createSiteRequest(&Site{Name: aws.String("other"), Path: "path/child"}))
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("CreateSite Handler: got code %v creating a site with / in its path; wanted %v", response.StatusCode, http.StatusBadRequest)
+	}
+
+	appended := entries.Entries()
+	if len(appended) != 2 || appended[0].Target.ID != site.ID || appended[1].Target.Type != "page" || appended[0].Action != audit.Create {
+		t.Errorf("CreateSite Handler: got audit entries %+v; wanted creating the site & home page", appended)
+	}
+}
+
 // ************************************
 // internal testing helper functions... several could be moved to centralized location
 
@@ -66,15 +142,16 @@ func setup(t *testing.T) {
 	} else {
 		db = dynamodb.New(session)
 	}
+	trail = &audit.Memory{}
 }
 
 // invokeCreateSiteHandler marshals the input site to json, sends it to lambda in the request,
 // then unmarshals & returns the result
 func invokeCreateSiteHandler(in *Site) (*Site, int, error) {
 	request := createSiteRequest(in)
-	ctx := context.Background()
-	response, err := Handler(ctx, request)
-	if len(response.Body) == 0 {
+	response, err := Handler(callerContext(), request)
+	// error responses carry problem details rather than a site
+	if len(response.Body) == 0 || response.StatusCode != http.StatusOK {
 		return nil, response.StatusCode, err
 	}
 
@@ -87,6 +164,11 @@ func invokeCreateSiteHandler(in *Site) (*Site, int, error) {
 	return &out, response.StatusCode, err
 }
 
+// callerContext returns a context with the principal of the authenticated caller
+func callerContext() context.Context {
+	return auth.NewContext(context.Background(), &auth.Principal{Subject: "user-1", Method: "jwt", Tenant: "acme-corp"})
+}
+
 // createSiteRequest takes a Site struct and returns a lambda request object containing the site as the body
 func createSiteRequest(site *Site) Request {
 	body, _ := json.Marshal(site)
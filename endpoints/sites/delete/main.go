@@ -2,20 +2,40 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/sitemap"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/tags"
+	"github.com/feckmore/go-lambda-dynamo/webhooks"
 )
 
-type Response events.APIGatewayProxyResponse
-type Request events.APIGatewayProxyRequest
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
 type SiteStatus int
 
 const (
@@ -25,24 +45,78 @@ const (
 
 // Site defines the fields of the site model
 type Site struct {
-	ID           string     `json:"id"`
-	Version      string     `json:"version"`
-	Path         string     `json:"path"`
-	Type         string     `json:"type"`
-	Status       SiteStatus `json:"status,omitempty"`
-	Name         *string    `json:"name,omitempty"`
-	Description  *string    `json:"description,omitempty"`
-	Keywords     *string    `json:"keywords,omitempty"`
-	URL          *string    `json:"url,omitempty"`
-	TagManagerID *string    `json:"tagManagerId,omitempty"`
-	CardImageURL *string    `json:"cardImageUrl,omitempty"`
-	CreatedAt    time.Time  `json:"createdAt,omitempty"`
-	UpdatedAt    time.Time  `json:"updatedAt,omitempty"`
-}
-
-var db *dynamodb.DynamoDB
+	ID           string                `json:"id"`
+	Version      string                `json:"version"`
+	Path         string                `json:"path"`
+	Type         string                `json:"type"`
+	Tenant       string                `json:"tenant,omitempty"`
+	Status       SiteStatus            `json:"status,omitempty"`
+	Name         *string               `json:"name,omitempty"`
+	Description  *string               `json:"description,omitempty"`
+	Keywords     *string               `json:"keywords,omitempty"`
+	Tags         []string              `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	URL          *string               `json:"url,omitempty"`
+	TagManagerID *string               `json:"tagManagerId,omitempty"`
+	CardImageURL *string               `json:"cardImageUrl,omitempty"`
+	Theme        *string               `json:"theme,omitempty"`
+	Robots       []sitemap.RobotsGroup `json:"robots,omitempty"`
+	Social       *render.Social        `json:"social,omitempty"`
+	CreatedAt    time.Time             `json:"createdAt,omitempty"`
+	UpdatedAt    time.Time             `json:"updatedAt,omitempty"`
+	DeletedAt    *time.Time            `json:"deletedAt,omitempty"`
+	ExpiresAt    int64                 `json:"expiresAt,omitempty"`
+}
+
+// Page defines the fields of the page model that are indexed for search
+type Page struct {
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Type        string                 `json:"type"`
+	Path        string                 `json:"path"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Item identifies a single table item removed (or to be removed) by a cascading delete
+type Item struct {
+	ID        string     `json:"id"`
+	Version   string     `json:"version"`
+	Type      string     `json:"type"`
+	Path      string     `json:"path"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// DeleteReport is the response body of a cascading delete, listing every item it touched
+type DeleteReport struct {
+	SiteID  string `json:"siteId"`
+	DryRun  bool   `json:"dryRun"`
+	Total   int    `json:"total"`
+	Deleted int    `json:"deleted"`
+	Items   []Item `json:"items"`
+	// SharedKeys are the ids of the API keys for other sites too, which aren't deleted but
+	// no longer allow this one until it's restored
+	SharedKeys []string `json:"sharedKeys,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db dynamodbiface.DynamoDBAPI
+var policy *access.Policy
+var trail audit.Log
+var index search.Index
 var region, stage, table string
 var currentTime time.Time
+var retention time.Duration
+var noContent bool
 
 func init() {
 	// Enable line numbers in log output, but remove date/time
@@ -56,50 +130,551 @@ func init() {
 	log.Println("STAGE:", stage)
 	log.Println("TABLE_NAME:", table)
 
+	// deleted items stay in the trash for TRASH_RETENTION_DAYS before the TTL purges them
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	retention = time.Duration(days) * 24 * time.Hour
+
+	// respond with 204 and no body instead of the delete report, except for dry runs
+	noContent = os.Getenv("DELETE_NO_CONTENT") == "true"
+
 	// TODO: validate env vars
 }
 
-// main starts the session, news up the db & invokes lambda handler
+// main starts the session, news up the db & access policy & invokes lambda handler
 func main() {
 	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
 	if err != nil {
 		log.Println("Failed to connect to AWS:", err)
 	} else {
 		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		trail = &audit.Table{DB: db, Name: table}
+		index = &search.Table{DB: db, Name: table}
 	}
 
-	lambda.Start(Handler)
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
 }
 
-// Handler is our lambda handler invoked by the `lambda.Start` function call in main()
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// Deleted items are moved to the trash by stamping them with `deletedAt`, and are purged by
+// the table's TTL on `expiresAt` once the retention period has passed. Without a `version`
+// query parameter every version of the site is deleted, along with everything kept under its
+// path: its pages, redirects, tags, content types, webhooks & their delivery logs, members, the
+// reservation of its path and the API keys for it alone. API keys for other sites as well only
+// lose this one, and the site's pages are taken out of the search index. Passing
+// `dryRun=true` reports what would be deleted without deleting anything. The report is also
+// returned after deleting, unless DELETE_NO_CONTENT is set to respond with 204 No Content.
 func Handler(ctx context.Context, request Request) (Response, error) {
-	id := aws.String(request.PathParameters["siteid"])
-	version := aws.String(request.QueryStringParameters["version"])
+	id := request.PathParameters["siteid"]
+	version := request.QueryStringParameters["version"]
+	dryRun := request.QueryStringParameters["dryRun"] == "true"
+
+	items, err := querySiteVersions(id, version)
+	if err != nil {
+		log.Println("Error querying site versions in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying site versions"), nil
+	}
+	if len(items) == 0 {
+		log.Println("No site returned from database query")
+		return problemResponse(http.StatusNotFound, "No site found with that id and version"), nil
+	}
+
+	var sites []Site
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &sites)
+	if err != nil {
+		log.Println("Error unmarshalling into sites slice:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading site versions"), nil
+	}
 
-	// TODO: also option to delete all versions?
+	// sites of other tenants aren't found, rather than forbidden, so their ids aren't confirmed
+	principal := auth.FromContext(ctx)
+	for _, site := range sites {
+		if principal != nil && site.Tenant != principal.Tenant {
+			return problemResponse(http.StatusNotFound, "No site found with that id and version"), nil
+		}
+	}
 
-	key := map[string]*dynamodb.AttributeValue{
-		"id":      {S: id},
-		"version": {S: version},
+	// only owners may delete a site, of every path its versions have had
+	for _, sitePath := range sitePaths(sites) {
+		role, err := policy.Role(principal, sitePath)
+		if err != nil {
+			log.Println("Error getting member from dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+		}
+		if !role.AtLeast(access.Owner) {
+			return problemResponse(http.StatusForbidden, "Only the site's owners may delete it"), nil
+		}
 	}
 
-	_, err := db.DeleteItem(&dynamodb.DeleteItemInput{
-		Key:       key,
-		TableName: aws.String(table),
-	})
+	// pages & everything else kept under the site's path are only removed along with the whole
+	// site, not with a single version of it
+	var cascaded, logs []map[string]*dynamodb.AttributeValue
+	var shared []string
+	if version == "" {
+		kept, err := querySiteItems(sitePaths(sites))
+		if err != nil {
+			log.Println("Error querying site items in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error querying the site's pages"), nil
+		}
+		cascaded = append(cascaded, kept...)
+
+		logs, err = queryDeliveries(kept)
+		if err != nil {
+			log.Println("Error querying webhook deliveries in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error querying the site's webhook deliveries"), nil
+		}
+
+		var keys []map[string]*dynamodb.AttributeValue
+		keys, shared, err = queryKeys(sitePaths(sites))
+		if err != nil {
+			log.Println("Error querying API keys in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error querying the site's API keys"), nil
+		}
+		cascaded = append(cascaded, keys...)
+	}
+
+	report := DeleteReport{SiteID: id, DryRun: dryRun, Total: len(items) + len(cascaded) + len(logs), SharedKeys: shared}
+	err = dynamodbattribute.UnmarshalListOfMaps(append(append(items, cascaded...), logs...), &report.Items)
 	if err != nil {
-		return Response{StatusCode: 500}, err // TODO: decide what's the correct status
+		log.Println("Error unmarshalling into items slice:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading the site's items"), nil
 	}
 
-	// TODO: consider returning body with status
+	if !dryRun {
+		// the site's versions go in the trash last, so a delete that fails part way still finds
+		// the site when it's retried, and carries on with the items that are left
+		deletedAt := time.Now()
+		report.Deleted, err = tombstone(cascaded, trash(deletedAt))
+		if err == nil {
+			// delivery logs already expire on their own, sooner than the trash would purge them
+			var deliveries int
+			deliveries, err = tombstone(logs, expression.Set(expression.Name("deletedAt"), expression.Value(deletedAt)))
+			report.Deleted += deliveries
+		}
+		if err == nil {
+			// the shared keys losing the site are recorded on it, for a restore to give it back
+			update := trash(deletedAt)
+			if len(shared) > 0 {
+				update = update.Set(expression.Name("sharedKeys"), expression.Value(shared))
+			}
+			var versions int
+			versions, err = tombstone(items, update)
+			report.Deleted += versions
+		}
+		if err == nil {
+			err = removeSites(shared, sitePaths(sites))
+		}
+		if err != nil {
+			log.Printf("Error deleting site %s after %d of %d items: %v\n", id, report.Deleted, report.Total, err)
+			return problemResponse(http.StatusInternalServerError, fmt.Sprintf("Error deleting site after %d of %d items, try again to delete the rest", report.Deleted, report.Total)), nil
+		}
+
+		// the site is already in the trash, so failing to take its pages out of the search index
+		// is logged rather than reported, and the stream's indexer catches up with them
+		unindex(cascaded)
+
+		// the site is already in the trash, so failing to audit it is logged rather than
+		// reported. Its pages went with it, so the site's versions are all that's recorded.
+		for _, site := range sites {
+			before := audit.Snapshot(&site)
+			site.DeletedAt, site.ExpiresAt = &deletedAt, deletedAt.Add(retention).Unix()
+			target := audit.Target{Type: "site", ID: site.ID, Version: site.Version, Path: site.Path}
+			if err := trail.Append(audit.NewEntry(request.RequestContext, audit.Delete, target, before, &site)); err != nil {
+				log.Println("Error appending to audit trail:", err)
+			}
+		}
+
+		if noContent {
+			response := Response{
+				StatusCode: http.StatusNoContent,
+			}
+
+			return response, nil
+		}
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		log.Println("Error marshalling delete report into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing delete report"), nil
+	}
 
 	response := Response{
-		StatusCode: 200,
+		StatusCode: http.StatusOK,
+		Body:       string(body),
 		Headers: map[string]string{
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Credentials": "true",
+			"Content-Type": "application/json",
 		},
 	}
 
 	return response, nil
 }
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
+
+// querySiteVersions returns the site versions stored under the given id that are not
+// already in the trash, or only the given version when one is passed
+func querySiteVersions(id, version string) ([]map[string]*dynamodb.AttributeValue, error) {
+	key := expression.Key("id").Equal(expression.Value(id))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	queryInput := dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(table),
+	}
+
+	return queryItems(&queryInput, func(item Item) bool {
+		return version == "" || item.Version == version
+	})
+}
+
+// querySiteItems returns every item kept under one of the given site paths that is not already
+// in the trash: the versions of its pages, the redirects from paths under it, its tag index
+// items, content types & webhooks, its members and the reservation of its path
+func querySiteItems(paths []string) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+
+	for _, sitePath := range paths {
+		// begins_with("acme") also matches "acme2/...", so only keep the site's own items
+		under := func(item Item) bool {
+			return item.Path == sitePath || strings.HasPrefix(item.Path, sitePath+"/")
+		}
+		all := func(item Item) bool { return true }
+
+		byType := func(itemType string, path expression.KeyConditionBuilder) expression.KeyConditionBuilder {
+			return expression.Key("type").Equal(expression.Value(itemType)).And(path)
+		}
+
+		queries := []struct {
+			index string
+			key   expression.KeyConditionBuilder
+			keep  func(Item) bool
+		}{
+			{"type-path-index", byType("page", expression.Key("path").BeginsWith(sitePath)), under},
+			{"type-path-index", byType("redirect", expression.Key("path").BeginsWith(sitePath)), under},
+			{"type-path-index", byType("tag", expression.Key("path").BeginsWith(tags.Prefix(sitePath))), all},
+			{"type-path-index", byType("contentType", expression.Key("path").Equal(expression.Value(sitePath))), all},
+			{"type-path-index", byType("webhook", expression.Key("path").Equal(expression.Value(sitePath))), all},
+			{"", expression.Key("id").Equal(expression.Value(access.MembersID(sitePath))), all},
+			{"", expression.Key("id").Equal(expression.Value(access.ReservationID(sitePath))), all},
+		}
+		for _, q := range queries {
+			expr, err := expression.NewBuilder().WithKeyCondition(q.key).Build()
+			if err != nil {
+				return nil, err
+			}
+
+			queryInput := dynamodb.QueryInput{
+				KeyConditionExpression:    expr.KeyCondition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+				TableName:                 aws.String(table),
+			}
+			if q.index != "" {
+				queryInput.IndexName = aws.String(q.index)
+			}
+
+			found, err := queryItems(&queryInput, q.keep)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, found...)
+		}
+	}
+
+	return items, nil
+}
+
+// queryDeliveries returns the delivery logs of the webhooks among the items that are not already
+// in the trash
+func queryDeliveries(items []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, error) {
+	var logs []map[string]*dynamodb.AttributeValue
+
+	for _, av := range items {
+		var item Item
+		if err := dynamodbattribute.UnmarshalMap(av, &item); err != nil {
+			return nil, err
+		}
+		if item.Type != "webhook" {
+			continue
+		}
+
+		key := expression.Key("id").Equal(expression.Value(webhooks.DeliveryID(item.ID)))
+		expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+		if err != nil {
+			return nil, err
+		}
+
+		found, err := queryItems(&dynamodb.QueryInput{
+			KeyConditionExpression:    expr.KeyCondition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			TableName:                 aws.String(table),
+		}, func(Item) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, found...)
+	}
+
+	return logs, nil
+}
+
+// queryItems runs the query through every page of results, returning the raw items that are
+// not in the trash and are accepted by keep
+func queryItems(queryInput *dynamodb.QueryInput, keep func(Item) bool) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+
+	var unmarshalErr error
+	err := db.QueryPages(queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, av := range page.Items {
+			var item Item
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(av, &item); unmarshalErr != nil {
+				return false
+			}
+			if item.DeletedAt == nil && keep(item) {
+				items = append(items, av)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, unmarshalErr
+}
+
+// queryKeys returns the API keys for none but the given site paths, which go in the trash along
+// with the site, and the ids of the keys for other sites as well, which only lose these ones
+func queryKeys(paths []string) ([]map[string]*dynamodb.AttributeValue, []string, error) {
+	var keys []map[string]*dynamodb.AttributeValue
+	var shared []string
+	if len(paths) == 0 {
+		return nil, nil, nil
+	}
+
+	key := expression.Key("type").Equal(expression.Value("apikey"))
+	filter := expression.Contains(expression.Name("key.sites"), paths[0])
+	for _, sitePath := range paths[1:] {
+		filter = filter.Or(expression.Contains(expression.Name("key.sites"), sitePath))
+	}
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, av := range page.Items {
+			var stored struct {
+				Item
+				Key struct {
+					Sites []string `json:"sites"`
+				} `json:"key"`
+			}
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(av, &stored); unmarshalErr != nil {
+				return false
+			}
+			if stored.DeletedAt != nil {
+				continue
+			}
+
+			only := true
+			for _, site := range stored.Key.Sites {
+				only = only && contains(paths, site)
+			}
+			if only {
+				keys = append(keys, av)
+			} else {
+				shared = append(shared, stored.ID)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return keys, shared, unmarshalErr
+}
+
+// trash returns the update moving an item to the trash at deletedAt, with the TTL `expiresAt` at
+// which the table purges it
+func trash(deletedAt time.Time) expression.UpdateBuilder {
+	return expression.Set(expression.Name("deletedAt"), expression.Value(deletedAt)).
+		Set(expression.Name("expiresAt"), expression.Value(deletedAt.Add(retention).Unix()))
+}
+
+// tombstone makes the update to the items, in transactions of up to store.MaxWrites items. Only
+// the attributes of the update are set, and only while the item is there and not in the trash,
+// so changes made since the items were read aren't lost. A transaction is cancelled when one of
+// its items was deleted meanwhile, and its items are then updated one at a time, skipping those.
+// It returns the number of items moved to the trash so far, even on error.
+func tombstone(items []map[string]*dynamodb.AttributeValue, update expression.UpdateBuilder) (int, error) {
+	written := 0
+
+	condition := expression.AttributeExists(expression.Name("id")).And(expression.AttributeNotExists(expression.Name("deletedAt")))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return written, err
+	}
+
+	for start := 0; start < len(items); start += store.MaxWrites {
+		end := start + store.MaxWrites
+		if end > len(items) {
+			end = len(items)
+		}
+
+		work := store.NewUnitOfWork(db, table)
+		for _, item := range items[start:end] {
+			work.Update(aws.StringValue(item["id"].S), aws.StringValue(item["version"].S), expr)
+		}
+		err := work.Commit()
+		if err == store.ErrConflict {
+			var each int
+			each, err = tombstoneEach(items[start:end], expr)
+			written += each
+		} else if err == nil {
+			written += end - start
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	log.Printf("Moved %d of %d items to the trash\n", written, len(items))
+
+	return written, nil
+}
+
+// tombstoneEach makes the update to the items one at a time, skipping those deleted meanwhile.
+// It returns the number of items moved to the trash so far, even on error.
+func tombstoneEach(items []map[string]*dynamodb.AttributeValue, expr expression.Expression) (int, error) {
+	written := 0
+
+	for _, item := range items {
+		_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+			Key: map[string]*dynamodb.AttributeValue{
+				"id":      item["id"],
+				"version": item["version"],
+			},
+			UpdateExpression:          expr.Update(),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			TableName:                 aws.String(table),
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+
+		written++
+	}
+
+	return written, nil
+}
+
+// unindex takes the pages among the items out of the search index, logging failures
+func unindex(items []map[string]*dynamodb.AttributeValue) {
+	for _, av := range items {
+		var page Page
+		if err := dynamodbattribute.UnmarshalMap(av, &page); err != nil || page.Type != "page" {
+			continue
+		}
+
+		doc := &search.Document{
+			ID:          page.ID,
+			Version:     page.Version,
+			Name:        aws.StringValue(page.Name),
+			Description: aws.StringValue(page.Description),
+			Keywords:    aws.StringValue(page.Keywords),
+			Content:     search.Text(page.Blocks, page.Fields),
+		}
+		if err := search.Update(index, access.SiteOf(page.Path), doc, nil); err != nil {
+			log.Println("Error removing page", page.ID, "from search index:", err)
+		}
+	}
+}
+
+// removeSites takes the site paths out of the sites of the API keys with the ids
+func removeSites(ids, paths []string) error {
+	for _, id := range ids {
+		_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+			Key: map[string]*dynamodb.AttributeValue{
+				"id":      {S: aws.String(id)},
+				"version": {S: aws.String("apikey")},
+			},
+			UpdateExpression:          aws.String("DELETE #key.#sites :paths"),
+			ConditionExpression:       aws.String("attribute_exists(id)"),
+			ExpressionAttributeNames:  map[string]*string{"#key": aws.String("key"), "#sites": aws.String("sites")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":paths": {SS: aws.StringSlice(paths)}},
+			TableName:                 aws.String(table),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// contains reports whether the values include the value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sitePaths returns the distinct paths used across the versions of a site
+func sitePaths(sites []Site) []string {
+	var paths []string
+	seen := map[string]bool{}
+	for _, site := range sites {
+		if site.Path == "" || seen[site.Path] {
+			continue
+		}
+		seen[site.Path] = true
+		paths = append(paths, site.Path)
+	}
+
+	return paths
+}
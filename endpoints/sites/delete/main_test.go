@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/webhooks"
+)
+
+func TestDeleteSite(t *testing.T) {
+	memory := setupSite(t)
+
+	// a dry run reports everything kept under the site's path, but leaves it all in place
+	response, err := Handler(callerContext("user-1"), deleteRequest(true))
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("DeleteSite Handler: got code %v, error %v on a dry run; wanted %v", response.StatusCode, err, http.StatusOK)
+	}
+	var report DeleteReport
+	json.Unmarshal([]byte(response.Body), &report)
+	if !report.DryRun || report.Total != 8 || report.Deleted != 0 || len(report.SharedKeys) != 1 || report.SharedKeys[0] != "key-2" {
+		t.Errorf("DeleteSite Handler: got dry run report %+v; wanted 8 items & the shared key", report)
+	}
+	for _, item := range memory.Items() {
+		if item["deletedAt"] != nil {
+			t.Errorf("DeleteSite Handler: dry run moved %v to the trash", item)
+		}
+	}
+
+	// only owners may delete the site
+	response, _ = Handler(callerContext("user-2"), deleteRequest(false))
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("DeleteSite Handler: got code %v deleting as an editor; wanted %v", response.StatusCode, http.StatusForbidden)
+	}
+
+	response, err = Handler(callerContext("user-1"), deleteRequest(false))
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("DeleteSite Handler: got code %v, error %v; wanted %v", response.StatusCode, err, http.StatusOK)
+	}
+	report = DeleteReport{}
+	json.Unmarshal([]byte(response.Body), &report)
+	if report.DryRun || report.Total != 8 || report.Deleted != 8 {
+		t.Errorf("DeleteSite Handler: got report %+v; wanted 8 items deleted", report)
+	}
+
+	for _, item := range memory.Items() {
+		id := *item["id"].S
+		switch id {
+		case "other-page", "key-2":
+			if item["deletedAt"] != nil {
+				t.Errorf("DeleteSite Handler: moved %v to the trash; wanted it kept", id)
+			}
+		default:
+			if item["deletedAt"] == nil {
+				t.Errorf("DeleteSite Handler: left %v out of the trash", id)
+			}
+		}
+
+		switch id {
+		case "site-1":
+			var shared []string
+			dynamodbattribute.Unmarshal(item["sharedKeys"], &shared)
+			if len(shared) != 1 || shared[0] != "key-2" {
+				t.Errorf("DeleteSite Handler: got shared keys %v on the site; wanted key-2", shared)
+			}
+			if item["expiresAt"] == nil {
+				t.Errorf("DeleteSite Handler: got no expiry on the site in the trash")
+			}
+		case "key-2":
+			if sites := aws.StringValueSlice(item["key"].M["sites"].SS); len(sites) != 1 || sites[0] != "globex" {
+				t.Errorf("DeleteSite Handler: got shared key sites %v; wanted globex alone", sites)
+			}
+		case webhooks.DeliveryID("hook-1"):
+			// delivery logs keep their own expiry
+			if aws.StringValue(item["expiresAt"].N) != "42" {
+				t.Errorf("DeleteSite Handler: got delivery expiry %v; wanted it kept", item["expiresAt"])
+			}
+		}
+	}
+
+	if postings, _ := index.Postings("acme", "about"); len(postings) != 0 {
+		t.Errorf("DeleteSite Handler: got postings %+v; wanted the page out of the search index", postings)
+	}
+
+	// the site is in the trash, so it's not found again
+	response, _ = Handler(callerContext("user-1"), deleteRequest(false))
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("DeleteSite Handler: got code %v deleting again; wanted %v", response.StatusCode, http.StatusNotFound)
+	}
+}
+
+// setupSite points the handler at a memory store holding the acme site, owned by user-1 & edited
+// by user-2, with a page, a webhook & its delivery log, an API key for it alone and one shared
+// with another site, and a page of the acme2 site whose path starts with acme's
+func setupSite(t *testing.T) *store.Memory {
+	memory := store.NewMemory()
+	db = memory
+	table = "go-lambda-dynamo"
+	policy = &access.Policy{Store: &access.Table{DB: memory, Name: table}}
+	trail = &audit.Memory{}
+	index = search.NewMemory()
+
+	put(t, memory, map[string]interface{}{"id": "site-1", "version": "v1", "type": "site", "path": "acme", "tenant": "acme-corp", "name": "Acme"})
+	put(t, memory, access.Item(&access.Member{Site: "acme", Subject: "user-1", Role: access.Owner}))
+	put(t, memory, access.Item(&access.Member{Site: "acme", Subject: "user-2", Role: access.Editor}))
+	put(t, memory, access.Reservation("acme", "site-1"))
+	put(t, memory, map[string]interface{}{"id": "page-1", "version": "p1", "type": "page", "path": "acme/about", "name": "About"})
+	put(t, memory, map[string]interface{}{"id": "other-page", "version": "p1", "type": "page", "path": "acme2/about", "name": "About"})
+	put(t, memory, map[string]interface{}{"id": "hook-1", "version": "webhook", "type": "webhook", "path": "acme", "url": "https://example.com"})
+	put(t, memory, map[string]interface{}{"id": webhooks.DeliveryID("hook-1"), "version": "1", "expiresAt": 42})
+	put(t, memory, apiKey("key-1", "acme"))
+	put(t, memory, apiKey("key-2", "acme", "globex"))
+
+	search.Update(index, "acme", nil, &search.Document{ID: "page-1", Version: "p1", Name: "About"})
+
+	return memory
+}
+
+// apiKey returns the API key item with the id, for the sites
+func apiKey(id string, sites ...string) interface{} {
+	type key struct {
+		ID    string   `json:"id"`
+		Sites []string `json:"sites" dynamodbav:"sites,stringset"`
+	}
+
+	return map[string]interface{}{
+		"id":      id,
+		"version": "apikey",
+		"type":    "apikey",
+		"path":    "apikey#user-1",
+		"key":     key{ID: id, Sites: sites},
+	}
+}
+
+// put adds the item to the memory store
+func put(t *testing.T, memory *store.Memory, item interface{}) {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memory.PutItem(&dynamodb.PutItemInput{Item: av, TableName: aws.String(table)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// callerContext returns a context with the principal of the authenticated caller
+func callerContext(subject string) context.Context {
+	return auth.NewContext(context.Background(), &auth.Principal{Subject: subject, Method: "jwt", Tenant: "acme-corp"})
+}
+
+// deleteRequest returns the request deleting every version of the site, or a dry run of it
+func deleteRequest(dryRun bool) Request {
+	request := Request{
+		PathParameters: map[string]string{"siteid": "site-1"},
+	}
+	if dryRun {
+		request.QueryStringParameters = map[string]string{"dryRun": "true"}
+	}
+
+	return request
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/feed"
+	"github.com/feckmore/go-lambda-dynamo/render"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+type SiteStatus int
+
+const (
+	Unpublished SiteStatus = iota
+	Published
+)
+
+// defaultLimit & maxLimit bound how many pages a feed lists
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// Site defines the fields of the site model needed for its feed
+type Site struct {
+	render.Site
+	Status    SiteStatus `json:"status,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Optional(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It returns an Atom feed of the published site's most recently updated pages, or RSS 2.0 with
+// `format=rss`. A `path` limits the feed to the pages under it, such as a blog section, and
+// `limit` sets how many pages are listed.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+	params := request.QueryStringParameters
+
+	sectionPath := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(params["path"])), "/")
+	if sectionPath == "" {
+		sectionPath = sitePath
+	}
+	if sectionPath != sitePath && !strings.HasPrefix(sectionPath, sitePath+"/") {
+		return problemResponse(http.StatusBadRequest, "Path must be a path under the site"), nil
+	}
+
+	format := params["format"]
+	if format == "" {
+		format = "atom"
+	}
+	if format != "atom" && format != "rss" {
+		return problemResponse(http.StatusBadRequest, "Format must be atom or rss"), nil
+	}
+
+	limit := defaultLimit
+	if params["limit"] != "" {
+		n, err := strconv.Atoi(params["limit"])
+		if err != nil || n < 1 || n > maxLimit {
+			return problemResponse(http.StatusBadRequest, "Limit must be from 1 to "+strconv.Itoa(maxLimit)), nil
+		}
+		limit = n
+	}
+
+	site, err := latestSite(sitePath)
+	if err != nil {
+		log.Println("Error getting site from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting site"), nil
+	}
+	if site == nil || site.Status != Published {
+		return problemResponse(http.StatusNotFound, "No published site found at "+sitePath), nil
+	}
+	if site.URL == nil || *site.URL == "" {
+		return problemResponse(http.StatusNotFound, "Site has no URL to link its pages to"), nil
+	}
+
+	pages, err := recentPages(sectionPath, limit)
+	if err != nil {
+		log.Println("Error querying pages in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying pages"), nil
+	}
+
+	siteFeed := feed.Feed{
+		Title:       value(site.Name, sitePath),
+		Description: value(site.Description, ""),
+		Link:        render.URL(&site.Site, sectionPath),
+		Updated:     site.UpdatedAt,
+		Entries:     make([]feed.Entry, len(pages)),
+	}
+	for i, page := range pages {
+		link := render.URL(&site.Site, page.Path)
+		siteFeed.Entries[i] = feed.Entry{
+			// the page id outlives moves, which change the link
+			ID:      "urn:uuid:" + page.ID,
+			Title:   value(page.Name, render.Relative(&site.Site, page.Path)),
+			Summary: value(page.Description, ""),
+			Author:  value(page.Author, ""),
+			Link:    link,
+			Updated: page.UpdatedAt,
+		}
+		if page.UpdatedAt.After(siteFeed.Updated) {
+			siteFeed.Updated = page.UpdatedAt
+		}
+	}
+
+	var body bytes.Buffer
+	contentType := "application/atom+xml; charset=utf-8"
+	if format == "rss" {
+		contentType = "application/rss+xml; charset=utf-8"
+		err = feed.WriteRSS(&body, siteFeed)
+	} else {
+		err = feed.WriteAtom(&body, siteFeed)
+	}
+	if err != nil {
+		log.Println("Error writing feed:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing feed"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       body.String(),
+		Headers: map[string]string{
+			"Content-Type": contentType,
+		},
+	}
+
+	return response, nil
+}
+
+// latestSite returns the latest version of the site at the path that's outside the trash, or
+// nil if there isn't one
+func latestSite(sitePath string) (*Site, error) {
+	var sites []Site
+
+	key := expression.Key("type").Equal(expression.Value("site")).And(expression.Key("path").Equal(expression.Value(sitePath)))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &sites)
+	if err != nil || len(sites) == 0 {
+		return nil, err
+	}
+
+	latest := sites[0]
+	for _, site := range sites[1:] {
+		if site.UpdatedAt.After(latest.UpdatedAt) {
+			latest = site
+		}
+	}
+
+	return &latest, nil
+}
+
+// recentPages returns the latest versions of the pages under the path that are outside the
+// trash, most recently updated first. The path-updatedAt-index is keyed on a single path, so it
+// only orders the versions of one page; the pages under a path are instead read in path order
+// from the type-path-index and sorted by updatedAt here.
+func recentPages(sectionPath string, limit int) ([]render.Page, error) {
+	latest := map[string]render.Page{}
+
+	key := expression.Key("type").Equal(expression.Value("page")).And(expression.Key("path").BeginsWith(sectionPath))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	projection := expression.NamesList(expression.Name("id"), expression.Name("version"), expression.Name("path"), expression.Name("name"), expression.Name("description"), expression.Name("author"), expression.Name("updatedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).WithProjection(projection).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	queryInput := dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var results []render.Page
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &results); unmarshalErr != nil {
+			return false
+		}
+		for _, result := range results {
+			// the prefix also matches paths that merely start with the same characters
+			if result.Path != sectionPath && !strings.HasPrefix(result.Path, sectionPath+"/") {
+				continue
+			}
+			if current, ok := latest[result.Path]; !ok || result.UpdatedAt.After(current.UpdatedAt) {
+				latest[result.Path] = result
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]render.Page, 0, len(latest))
+	for _, page := range latest {
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].UpdatedAt.After(pages[j].UpdatedAt) })
+	if len(pages) > limit {
+		pages = pages[:limit]
+	}
+
+	return pages, nil
+}
+
+// value returns the string pointed to, or the fallback when it's missing or blank
+func value(s *string, fallback string) string {
+	if s == nil || strings.TrimSpace(*s) == "" {
+		return fallback
+	}
+	return *s
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -15,10 +15,16 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/sitemap"
 )
 
-type Response events.APIGatewayProxyResponse
-type Request events.APIGatewayProxyRequest
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
 type SiteStatus int
 
 const (
@@ -28,22 +34,38 @@ const (
 
 // Site defines the fields of the site model
 type Site struct {
-	ID           string     `json:"id"`
-	Version      string     `json:"version"`
-	Path         string     `json:"path"`
-	Type         string     `json:"type"`
-	Status       SiteStatus `json:"status,omitempty"`
-	Name         *string    `json:"name,omitempty"`
-	Description  *string    `json:"description,omitempty"`
-	Keywords     *string    `json:"keywords,omitempty"`
-	URL          *string    `json:"url,omitempty"`
-	TagManagerID *string    `json:"tagManagerId,omitempty"`
-	CardImageURL *string    `json:"cardImageUrl,omitempty"`
-	CreatedAt    time.Time  `json:"createdAt,omitempty"`
-	UpdatedAt    time.Time  `json:"updatedAt,omitempty"`
+	ID           string                `json:"id"`
+	Version      string                `json:"version"`
+	Path         string                `json:"path"`
+	Type         string                `json:"type"`
+	Tenant       string                `json:"tenant,omitempty"`
+	Status       SiteStatus            `json:"status,omitempty"`
+	Name         *string               `json:"name,omitempty"`
+	Description  *string               `json:"description,omitempty"`
+	Keywords     *string               `json:"keywords,omitempty"`
+	Tags         []string              `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	URL          *string               `json:"url,omitempty"`
+	TagManagerID *string               `json:"tagManagerId,omitempty"`
+	CardImageURL *string               `json:"cardImageUrl,omitempty"`
+	Theme        *string               `json:"theme,omitempty"`
+	Robots       []sitemap.RobotsGroup `json:"robots,omitempty"`
+	Social       *render.Social        `json:"social,omitempty"`
+	CreatedAt    time.Time             `json:"createdAt,omitempty"`
+	UpdatedAt    time.Time             `json:"updatedAt,omitempty"`
+	DeletedAt    *time.Time            `json:"deletedAt,omitempty"`
+	ExpiresAt    int64                 `json:"expiresAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
 }
 
 var db *dynamodb.DynamoDB
+var policy *access.Policy
 var region, stage, table string
 var currentTime time.Time
 
@@ -62,16 +84,17 @@ func init() {
 	// TODO: validate env vars
 }
 
-// main starts the session, news up the db & invokes lambda handler
+// main starts the session, news up the db & access policy & invokes lambda handler
 func main() {
 	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
 	if err != nil {
 		log.Println("Failed to connect to AWS:", err)
 	} else {
 		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
 	}
 
-	lambda.Start(Handler)
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
 }
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call in main()
@@ -93,37 +116,70 @@ func Handler(ctx context.Context, request Request) (Response, error) {
 		TableName: aws.String(table),
 	})
 	if err != nil {
-		log.Println("Error getting site from dynamodb")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error getting site from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting site"), nil
 	}
 
 	err = dynamodbattribute.UnmarshalMap(result.Item, &site)
 	if err != nil {
-		log.Println("Error unmarshalling into site")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error unmarshalling into site:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading site"), nil
 	}
 
 	body, err := json.Marshal(site)
 	if err != nil {
-		log.Println("Error marshalling site into json for response body")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error marshalling site into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing site"), nil
+	}
+
+	// make sure there's a valid site returned, hiding sites in the trash unless asked for
+	includeDeleted := request.QueryStringParameters["includeDeleted"] == "true"
+	if site.ID == "" || (site.DeletedAt != nil && !includeDeleted) {
+		return problemResponse(http.StatusNotFound, "No site found with that id and version"), nil
 	}
 
-	// make sure there's a valid site returned
-	if site.ID == "" {
-		// TODO: consider returning body with status
-		return Response{StatusCode: http.StatusNotFound}, err
+	// sites of other tenants aren't found, rather than forbidden, so their ids aren't confirmed
+	principal := auth.FromContext(ctx)
+	if principal != nil && site.Tenant != principal.Tenant {
+		return problemResponse(http.StatusNotFound, "No site found with that id and version"), nil
+	}
+
+	// any member may read the site
+	role, err := policy.Role(principal, site.Path)
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Viewer) {
+		return problemResponse(http.StatusForbidden, "Only the site's members may read it"), nil
 	}
 
 	response := Response{
 		StatusCode: http.StatusOK,
 		Body:       string(body),
 		Headers: map[string]string{
-			"Content-Type":                     "application/json",
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Credentials": "true",
+			"Content-Type": "application/json",
 		},
 	}
 
 	return response, nil
 }
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
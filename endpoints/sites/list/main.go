@@ -16,10 +16,16 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/sitemap"
 )
 
-type Response events.APIGatewayProxyResponse
-type Request events.APIGatewayProxyRequest
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
 type SiteStatus int
 
 const (
@@ -29,22 +35,30 @@ const (
 
 // Site defines the fields of the site model
 type Site struct {
-	ID           string     `json:"id"`
-	Version      string     `json:"version"`
-	Path         string     `json:"path"`
-	Type         string     `json:"type"`
-	Status       SiteStatus `json:"status,omitempty"`
-	Name         *string    `json:"name,omitempty"`
-	Description  *string    `json:"description,omitempty"`
-	Keywords     *string    `json:"keywords,omitempty"`
-	URL          *string    `json:"url,omitempty"`
-	TagManagerID *string    `json:"tagManagerId,omitempty"`
-	CardImageURL *string    `json:"cardImageUrl,omitempty"`
-	CreatedAt    time.Time  `json:"createdAt,omitempty"`
-	UpdatedAt    time.Time  `json:"updatedAt,omitempty"`
+	ID           string                `json:"id"`
+	Version      string                `json:"version"`
+	Path         string                `json:"path"`
+	Type         string                `json:"type"`
+	Tenant       string                `json:"tenant,omitempty"`
+	Status       SiteStatus            `json:"status,omitempty"`
+	Name         *string               `json:"name,omitempty"`
+	Description  *string               `json:"description,omitempty"`
+	Keywords     *string               `json:"keywords,omitempty"`
+	Tags         []string              `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	URL          *string               `json:"url,omitempty"`
+	TagManagerID *string               `json:"tagManagerId,omitempty"`
+	CardImageURL *string               `json:"cardImageUrl,omitempty"`
+	Theme        *string               `json:"theme,omitempty"`
+	Robots       []sitemap.RobotsGroup `json:"robots,omitempty"`
+	Social       *render.Social        `json:"social,omitempty"`
+	CreatedAt    time.Time             `json:"createdAt,omitempty"`
+	UpdatedAt    time.Time             `json:"updatedAt,omitempty"`
+	DeletedAt    *time.Time            `json:"deletedAt,omitempty"`
+	ExpiresAt    int64                 `json:"expiresAt,omitempty"`
 }
 
 var db *dynamodb.DynamoDB
+var policy *access.Policy
 var region, stage, table string
 var currentTime time.Time
 
@@ -63,25 +77,32 @@ func init() {
 	// TODO: validate env vars
 }
 
-// main starts the session, news up the db & invokes lambda handler
+// main starts the session, news up the db & access policy & invokes lambda handler
 func main() {
 	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
 	if err != nil {
 		log.Println("Failed to connect to AWS:", err)
 	} else {
 		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
 	}
 
-	lambda.Start(Handler)
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
 }
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call in main()
 func Handler(ctx context.Context, request Request) (Response, error) {
 	var sites []Site
+	principal := auth.FromContext(ctx)
 
 	key := expression.Key("type").Equal(expression.Value("site"))
-	builder := expression.NewBuilder().WithKeyCondition(key)
-	expr, err := builder.Build()
+	// only the sites of the caller's tenant are queried
+	filter := access.TenantCondition(principal.Tenant)
+	// sites in the trash are hidden unless asked for
+	if request.QueryStringParameters["includeDeleted"] != "true" {
+		filter = filter.And(expression.AttributeNotExists(expression.Name("deletedAt")))
+	}
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
 	if err != nil {
 		log.Println("Error building dynamodb expression")
 		return Response{StatusCode: http.StatusInternalServerError}, err
@@ -89,6 +110,7 @@ func Handler(ctx context.Context, request Request) (Response, error) {
 
 	queryInput := dynamodb.QueryInput{
 		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		IndexName:                 aws.String("type-path-index"),
@@ -106,6 +128,14 @@ func Handler(ctx context.Context, request Request) (Response, error) {
 		return Response{StatusCode: http.StatusInternalServerError}, err
 	}
 
+	// only the sites the caller is a member of are listed
+	memberSites, err := policy.Sites(principal)
+	if err != nil {
+		log.Println("Error querying memberships in dynamodb")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+	sites = memberOf(sites, memberSites)
+
 	body, err := json.Marshal(sites)
 	if err != nil {
 		log.Println("Error marshalling sites into json for response body")
@@ -116,11 +146,26 @@ func Handler(ctx context.Context, request Request) (Response, error) {
 		StatusCode: http.StatusOK,
 		Body:       string(body),
 		Headers: map[string]string{
-			"Content-Type":                     "application/json",
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Credentials": "true",
+			"Content-Type": "application/json",
 		},
 	}
 
 	return response, nil
 }
+
+// memberOf returns the sites at one of the paths
+func memberOf(sites []Site, paths []string) []Site {
+	member := map[string]bool{}
+	for _, path := range paths {
+		member[path] = true
+	}
+
+	result := []Site{}
+	for _, site := range sites {
+		if member[site.Path] {
+			result = append(result, site)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/tags"
+	"github.com/feckmore/go-lambda-dynamo/webhooks"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Page defines the fields of the page model that are indexed for search
+type Page struct {
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Type        string                 `json:"type"`
+	Path        string                 `json:"path"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+}
+
+// Item identifies a single table item taken back out of the trash
+type Item struct {
+	ID        string     `json:"id"`
+	Version   string     `json:"version"`
+	Type      string     `json:"type"`
+	Path      string     `json:"path"`
+	Tenant    string     `json:"tenant,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// SharedKeys are the ids of the API keys for other sites too that lost the site when it was
+	// deleted, recorded on the site's versions
+	SharedKeys []string `json:"sharedKeys,omitempty"`
+}
+
+// RestoreReport is the response body of a site restore, listing every item it touched
+type RestoreReport struct {
+	SiteID   string `json:"siteId"`
+	Total    int    `json:"total"`
+	Restored int    `json:"restored"`
+	Items    []Item `json:"items"`
+	// SharedKeys are the ids of the API keys for other sites too, which allow this one again
+	SharedKeys []string `json:"sharedKeys,omitempty"`
+}
+
+var db dynamodbiface.DynamoDBAPI
+var policy *access.Policy
+var members *access.Table
+var trail audit.Log
+var index search.Index
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		members = &access.Table{DB: db, Name: table}
+		policy = access.FromEnv(members)
+		trail = &audit.Table{DB: db, Name: table}
+		index = &search.Table{DB: db, Name: table}
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It takes the site's versions in the trash (or only the `version` query parameter) back out,
+// along with the pages & everything else kept under the site's path that was deleted together
+// with them.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	id := request.PathParameters["siteid"]
+	version := request.QueryStringParameters["version"]
+
+	key := expression.Key("id").Equal(expression.Value(id))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		log.Println("Error building dynamodb expression")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	items, err := queryTrash(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(table),
+	}, func(item Item) bool {
+		return version == "" || item.Version == version
+	})
+	if err != nil {
+		log.Println("Error querying site versions in dynamodb")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+	if len(items) == 0 {
+		log.Println("No site in the trash to restore")
+		return Response{StatusCode: http.StatusNotFound}, nil
+	}
+
+	var sites []Item
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &sites)
+	if err != nil {
+		log.Println("Error unmarshalling into sites slice")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	// sites of other tenants aren't found, rather than forbidden, so their ids aren't confirmed
+	principal := auth.FromContext(ctx)
+	for _, site := range sites {
+		if principal != nil && site.Tenant != principal.Tenant {
+			log.Println("No site in the trash to restore")
+			return Response{StatusCode: http.StatusNotFound}, nil
+		}
+	}
+
+	// only owners may restore a site, of every path its versions have had. A site in the trash
+	// grants no roles, so its owners are those whose memberships went into the trash with it.
+	for _, site := range sites {
+		if policy.Admin(principal) {
+			break
+		}
+		if principal == nil || principal.Method == "apikey" {
+			return Response{StatusCode: http.StatusForbidden}, nil
+		}
+		member, err := members.TrashedMember(site.Path, principal.Subject)
+		if err != nil {
+			log.Println("Error getting member from dynamodb")
+			return Response{StatusCode: http.StatusInternalServerError}, err
+		}
+		if member == nil || !member.Role.AtLeast(access.Owner) {
+			return Response{StatusCode: http.StatusForbidden}, nil
+		}
+	}
+
+	// a site delete stamps the site and everything kept under its path with the same deletedAt,
+	// which tells apart the items deleted along with the site from those deleted on their own
+	// before it
+	var cascaded, logs []map[string]*dynamodb.AttributeValue
+	seen := map[string]bool{}
+	for _, site := range sites {
+		// versions deleted together share path & deletedAt, so their items only need restoring once
+		deletion := site.Path + "@" + site.DeletedAt.Format(time.RFC3339Nano)
+		if site.Path == "" || seen[deletion] {
+			continue
+		}
+		seen[deletion] = true
+
+		deleted, err := queryDeletedItems(site)
+		if err != nil {
+			log.Println("Error querying site items in dynamodb")
+			return Response{StatusCode: http.StatusInternalServerError}, err
+		}
+		cascaded = append(cascaded, deleted...)
+
+		deliveries, err := queryDeletedDeliveries(deleted, *site.DeletedAt)
+		if err != nil {
+			log.Println("Error querying webhook deliveries in dynamodb")
+			return Response{StatusCode: http.StatusInternalServerError}, err
+		}
+		logs = append(logs, deliveries...)
+	}
+
+	report := RestoreReport{SiteID: id, Total: len(items) + len(cascaded) + len(logs)}
+	err = dynamodbattribute.UnmarshalListOfMaps(append(append(items, cascaded...), logs...), &report.Items)
+	if err != nil {
+		log.Println("Error unmarshalling into items slice")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	// the site's versions come out of the trash last, so a restore that fails part way still
+	// finds the site in the trash when it's retried, and carries on with the items that are left
+	update := expression.Remove(expression.Name("deletedAt")).Remove(expression.Name("expiresAt"))
+	report.Restored, err = restore(cascaded, update)
+	if err == nil {
+		// delivery logs kept the expiry they had before going in the trash
+		var deliveries int
+		deliveries, err = restore(logs, expression.Remove(expression.Name("deletedAt")))
+		report.Restored += deliveries
+	}
+	added := map[string]bool{}
+	for _, site := range sites {
+		if err == nil && len(site.SharedKeys) > 0 {
+			err = addSite(site.SharedKeys, site.Path)
+		}
+		for _, key := range site.SharedKeys {
+			if !added[key] {
+				added[key] = true
+				report.SharedKeys = append(report.SharedKeys, key)
+			}
+		}
+	}
+	if err == nil {
+		var versions int
+		versions, err = restore(items, update.Remove(expression.Name("sharedKeys")))
+		report.Restored += versions
+	}
+	if err != nil {
+		log.Printf("Error restoring site %s after %d of %d items, try again to restore the rest\n", id, report.Restored, report.Total)
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	// the site is already restored, so failing to put its pages back in the search index is
+	// logged rather than reported, and the stream's indexer catches up with them
+	reindex(cascaded)
+
+	// the site is already restored, so failing to audit it is logged rather than reported. Its
+	// pages came back with it, so the site's versions are all that's recorded.
+	for _, site := range sites {
+		before := audit.Snapshot(&site)
+		site.DeletedAt = nil
+		target := audit.Target{Type: "site", ID: site.ID, Version: site.Version, Path: site.Path}
+		if err := trail.Append(audit.NewEntry(request.RequestContext, audit.Restore, target, before, &site)); err != nil {
+			log.Println("Error appending to audit trail:", err)
+		}
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		log.Println("Error marshalling restore report into json for response body")
+		return Response{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// queryDeletedItems returns the items kept under the site's path that were deleted along with
+// it: the versions of its pages, the redirects from paths under it, its tag index items, content
+// types & webhooks, its members, the reservation of its path and the API keys for it alone
+func queryDeletedItems(site Item) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+
+	// begins_with("acme") also matches "acme2/...", so only keep the site's own items
+	under := func(item Item) bool {
+		return item.Path == site.Path || strings.HasPrefix(item.Path, site.Path+"/")
+	}
+	all := func(item Item) bool { return true }
+	byType := func(itemType string, path expression.KeyConditionBuilder) expression.KeyConditionBuilder {
+		return expression.Key("type").Equal(expression.Value(itemType)).And(path)
+	}
+	// API keys are kept by their owner, and only those for the site alone went in the trash with it
+	forSite := expression.Contains(expression.Name("key.sites"), site.Path)
+
+	queries := []struct {
+		index  string
+		key    expression.KeyConditionBuilder
+		filter *expression.ConditionBuilder
+		keep   func(Item) bool
+	}{
+		{"type-path-index", byType("page", expression.Key("path").BeginsWith(site.Path)), nil, under},
+		{"type-path-index", byType("redirect", expression.Key("path").BeginsWith(site.Path)), nil, under},
+		{"type-path-index", byType("tag", expression.Key("path").BeginsWith(tags.Prefix(site.Path))), nil, all},
+		{"type-path-index", byType("contentType", expression.Key("path").Equal(expression.Value(site.Path))), nil, all},
+		{"type-path-index", byType("webhook", expression.Key("path").Equal(expression.Value(site.Path))), nil, all},
+		{"", expression.Key("id").Equal(expression.Value(access.MembersID(site.Path))), nil, all},
+		{"", expression.Key("id").Equal(expression.Value(access.ReservationID(site.Path))), nil, all},
+		{"type-path-index", expression.Key("type").Equal(expression.Value("apikey")), &forSite, all},
+	}
+	for _, q := range queries {
+		builder := expression.NewBuilder().WithKeyCondition(q.key)
+		if q.filter != nil {
+			builder = builder.WithFilter(*q.filter)
+		}
+		expr, err := builder.Build()
+		if err != nil {
+			return nil, err
+		}
+
+		queryInput := dynamodb.QueryInput{
+			KeyConditionExpression:    expr.KeyCondition(),
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			TableName:                 aws.String(table),
+		}
+		if q.index != "" {
+			queryInput.IndexName = aws.String(q.index)
+		}
+
+		deleted, err := queryTrash(&queryInput, func(item Item) bool {
+			return q.keep(item) && item.DeletedAt.Equal(*site.DeletedAt)
+		})
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, deleted...)
+	}
+
+	return items, nil
+}
+
+// queryDeletedDeliveries returns the delivery logs of the webhooks among the items that were
+// deleted along with them, at deletedAt
+func queryDeletedDeliveries(items []map[string]*dynamodb.AttributeValue, deletedAt time.Time) ([]map[string]*dynamodb.AttributeValue, error) {
+	var logs []map[string]*dynamodb.AttributeValue
+
+	for _, av := range items {
+		var item Item
+		if err := dynamodbattribute.UnmarshalMap(av, &item); err != nil {
+			return nil, err
+		}
+		if item.Type != "webhook" {
+			continue
+		}
+
+		key := expression.Key("id").Equal(expression.Value(webhooks.DeliveryID(item.ID)))
+		expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+		if err != nil {
+			return nil, err
+		}
+
+		deleted, err := queryTrash(&dynamodb.QueryInput{
+			KeyConditionExpression:    expr.KeyCondition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			TableName:                 aws.String(table),
+		}, func(delivery Item) bool {
+			return delivery.DeletedAt.Equal(deletedAt)
+		})
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, deleted...)
+	}
+
+	return logs, nil
+}
+
+// queryTrash runs the query through every page of results, returning the raw items that are
+// in the trash and are accepted by keep
+func queryTrash(queryInput *dynamodb.QueryInput, keep func(Item) bool) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+
+	var unmarshalErr error
+	err := db.QueryPages(queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, av := range page.Items {
+			var item Item
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(av, &item); unmarshalErr != nil {
+				return false
+			}
+			if item.DeletedAt != nil && keep(item) {
+				items = append(items, av)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, unmarshalErr
+}
+
+// restore makes the update to the items, in transactions of up to store.MaxWrites items. Only the
+// attributes of the update are removed, and only while the item is still in the trash, so
+// changes made since the items were read aren't lost. A transaction is cancelled when one of its
+// items was taken out of the trash meanwhile, and its items are then updated one at a time,
+// skipping those. It returns the number of items taken out of the trash so far, even on error.
+func restore(items []map[string]*dynamodb.AttributeValue, update expression.UpdateBuilder) (int, error) {
+	written := 0
+
+	condition := expression.AttributeExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return written, err
+	}
+
+	for start := 0; start < len(items); start += store.MaxWrites {
+		end := start + store.MaxWrites
+		if end > len(items) {
+			end = len(items)
+		}
+
+		work := store.NewUnitOfWork(db, table)
+		for _, item := range items[start:end] {
+			work.Update(aws.StringValue(item["id"].S), aws.StringValue(item["version"].S), expr)
+		}
+		err := work.Commit()
+		if err == store.ErrConflict {
+			var each int
+			each, err = restoreEach(items[start:end], expr)
+			written += each
+		} else if err == nil {
+			written += end - start
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	log.Printf("Restored %d of %d items\n", written, len(items))
+
+	return written, nil
+}
+
+// reindex puts the latest version of each page among the items back in the search index,
+// logging failures
+func reindex(items []map[string]*dynamodb.AttributeValue) {
+	latest := map[string]*Page{}
+	var ids []string
+	for _, av := range items {
+		var page Page
+		if err := dynamodbattribute.UnmarshalMap(av, &page); err != nil || page.Type != "page" {
+			continue
+		}
+		if latest[page.ID] == nil {
+			ids = append(ids, page.ID)
+		}
+		if latest[page.ID] == nil || page.UpdatedAt.After(latest[page.ID].UpdatedAt) {
+			latest[page.ID] = &page
+		}
+	}
+
+	for _, id := range ids {
+		page := latest[id]
+		doc := &search.Document{
+			ID:          page.ID,
+			Version:     page.Version,
+			Name:        aws.StringValue(page.Name),
+			Description: aws.StringValue(page.Description),
+			Keywords:    aws.StringValue(page.Keywords),
+			Content:     search.Text(page.Blocks, page.Fields),
+		}
+		if err := search.Update(index, access.SiteOf(page.Path), nil, doc); err != nil {
+			log.Println("Error indexing page", page.ID, "for search:", err)
+		}
+	}
+}
+
+// addSite gives the site path back to the sites of the API keys with the ids, which lost it when
+// the site was deleted. Keys revoked since are skipped.
+func addSite(ids []string, path string) error {
+	for _, id := range ids {
+		_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+			Key: map[string]*dynamodb.AttributeValue{
+				"id":      {S: aws.String(id)},
+				"version": {S: aws.String("apikey")},
+			},
+			UpdateExpression:          aws.String("ADD #key.#sites :paths"),
+			ConditionExpression:       aws.String("attribute_exists(id) AND attribute_not_exists(deletedAt)"),
+			ExpressionAttributeNames:  map[string]*string{"#key": aws.String("key"), "#sites": aws.String("sites")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":paths": {SS: aws.StringSlice([]string{path})}},
+			TableName:                 aws.String(table),
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreEach makes the update to the items one at a time, skipping those already out of the
+// trash. It returns the number of items taken out of the trash so far, even on error.
+func restoreEach(items []map[string]*dynamodb.AttributeValue, expr expression.Expression) (int, error) {
+	written := 0
+
+	for _, item := range items {
+		_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+			Key: map[string]*dynamodb.AttributeValue{
+				"id":      item["id"],
+				"version": item["version"],
+			},
+			UpdateExpression:          expr.Update(),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			TableName:                 aws.String(table),
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+
+		written++
+	}
+
+	return written, nil
+}
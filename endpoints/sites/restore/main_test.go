@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/search"
+	"github.com/feckmore/go-lambda-dynamo/store"
+	"github.com/feckmore/go-lambda-dynamo/webhooks"
+)
+
+func TestRestoreSite(t *testing.T) {
+	memory := setupTrash(t)
+
+	// a site in the trash grants no roles, so its owners are told by their trashed memberships
+	response, _ := Handler(callerContext("user-2"), restoreRequest())
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("RestoreSite Handler: got code %v restoring as an editor; wanted %v", response.StatusCode, http.StatusForbidden)
+	}
+
+	response, err := Handler(callerContext("user-1"), restoreRequest())
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("RestoreSite Handler: got code %v, error %v; wanted %v", response.StatusCode, err, http.StatusOK)
+	}
+	var report RestoreReport
+	json.Unmarshal([]byte(response.Body), &report)
+	if report.Total != 8 || report.Restored != 8 || len(report.SharedKeys) != 1 || report.SharedKeys[0] != "key-2" {
+		t.Errorf("RestoreSite Handler: got report %+v; wanted 8 items restored & the shared key", report)
+	}
+
+	for _, item := range memory.Items() {
+		id := *item["id"].S
+		switch id {
+		case "old-page":
+			// deleted on its own before the site, so it stays in the trash
+			if item["deletedAt"] == nil {
+				t.Errorf("RestoreSite Handler: took %v out of the trash; wanted it kept there", id)
+			}
+			continue
+		case webhooks.DeliveryID("hook-1"):
+			// delivery logs keep their own expiry
+			if aws.StringValue(item["expiresAt"].N) != "42" {
+				t.Errorf("RestoreSite Handler: got delivery expiry %v; wanted it kept", item["expiresAt"])
+			}
+		case "key-2":
+			sites := aws.StringValueSlice(item["key"].M["sites"].SS)
+			sort.Strings(sites)
+			if len(sites) != 2 || sites[0] != "acme" || sites[1] != "globex" {
+				t.Errorf("RestoreSite Handler: got shared key sites %v; wanted acme given back", sites)
+			}
+		default:
+			if item["expiresAt"] != nil {
+				t.Errorf("RestoreSite Handler: left the expiry on %v", id)
+			}
+		}
+		if item["deletedAt"] != nil || item["sharedKeys"] != nil {
+			t.Errorf("RestoreSite Handler: left %v in the trash", item)
+		}
+	}
+
+	if postings, _ := index.Postings("acme", "about"); len(postings) != 1 || postings[0].ID != "page-1" {
+		t.Errorf("RestoreSite Handler: got postings %+v; wanted the page back in the search index", postings)
+	}
+
+	// the site is out of the trash, so there's nothing left to restore
+	response, _ = Handler(callerContext("user-1"), restoreRequest())
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("RestoreSite Handler: got code %v restoring again; wanted %v", response.StatusCode, http.StatusNotFound)
+	}
+}
+
+// setupTrash points the handler at a memory store holding the acme site as a site delete leaves
+// it: in the trash along with its members, path, page, webhook & delivery log and the API key for
+// it alone, with the site taken from a key shared with another site. A page deleted before the
+// site is in the trash too.
+func setupTrash(t *testing.T) *store.Memory {
+	memory := store.NewMemory()
+	db = memory
+	table = "go-lambda-dynamo"
+	members = &access.Table{DB: memory, Name: table}
+	policy = &access.Policy{Store: members}
+	trail = &audit.Memory{}
+	index = search.NewMemory()
+
+	deletedAt := time.Now()
+	trashed := func(item map[string]interface{}) map[string]interface{} {
+		item["deletedAt"] = deletedAt
+		if item["expiresAt"] == nil {
+			item["expiresAt"] = deletedAt.Add(24 * time.Hour).Unix()
+		}
+		return item
+	}
+	member := func(subject string, role access.Role) map[string]interface{} {
+		var item map[string]interface{}
+		av, _ := dynamodbattribute.MarshalMap(access.Item(&access.Member{Site: "acme", Subject: subject, Role: role}))
+		dynamodbattribute.UnmarshalMap(av, &item)
+		return item
+	}
+
+	put(t, memory, trashed(map[string]interface{}{"id": "site-1", "version": "v1", "type": "site", "path": "acme", "tenant": "acme-corp", "sharedKeys": []string{"key-2"}}))
+	put(t, memory, trashed(member("user-1", access.Owner)))
+	put(t, memory, trashed(member("user-2", access.Editor)))
+	put(t, memory, trashed(map[string]interface{}{"id": access.ReservationID("acme"), "version": "path", "type": "sitePath", "path": access.ReservationID("acme"), "siteId": "site-1"}))
+	put(t, memory, trashed(map[string]interface{}{"id": "page-1", "version": "p1", "type": "page", "path": "acme/about", "name": "About", "updatedAt": deletedAt}))
+	put(t, memory, trashed(map[string]interface{}{"id": "hook-1", "version": "webhook", "type": "webhook", "path": "acme"}))
+	put(t, memory, trashed(map[string]interface{}{"id": webhooks.DeliveryID("hook-1"), "version": "1", "expiresAt": 42}))
+	put(t, memory, trashed(apiKey("key-1", "acme")))
+	put(t, memory, apiKey("key-2", "globex"))
+
+	earlier := trashed(map[string]interface{}{"id": "old-page", "version": "p1", "type": "page", "path": "acme/old"})
+	earlier["deletedAt"] = deletedAt.Add(-time.Hour)
+	put(t, memory, earlier)
+
+	return memory
+}
+
+// apiKey returns the API key item with the id, for the sites
+func apiKey(id string, sites ...string) map[string]interface{} {
+	type key struct {
+		ID    string   `json:"id"`
+		Sites []string `json:"sites" dynamodbav:"sites,stringset"`
+	}
+
+	return map[string]interface{}{
+		"id":      id,
+		"version": "apikey",
+		"type":    "apikey",
+		"path":    "apikey#user-1",
+		"key":     key{ID: id, Sites: sites},
+	}
+}
+
+// put adds the item to the memory store
+func put(t *testing.T, memory *store.Memory, item interface{}) {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memory.PutItem(&dynamodb.PutItemInput{Item: av, TableName: aws.String(table)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// callerContext returns a context with the principal of the authenticated caller
+func callerContext(subject string) context.Context {
+	return auth.NewContext(context.Background(), &auth.Principal{Subject: subject, Method: "jwt", Tenant: "acme-corp"})
+}
+
+// restoreRequest returns the request restoring every version of the site
+func restoreRequest() Request {
+	return Request{
+		PathParameters: map[string]string{"siteid": "site-1"},
+	}
+}
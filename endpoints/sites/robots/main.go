@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/sitemap"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+type SiteStatus int
+
+const (
+	Unpublished SiteStatus = iota
+	Published
+)
+
+// Site defines the fields of the site model needed for its robots.txt
+type Site struct {
+	Path      string                `json:"path"`
+	Status    SiteStatus            `json:"status,omitempty"`
+	URL       *string               `json:"url,omitempty"`
+	Robots    []sitemap.RobotsGroup `json:"robots,omitempty"`
+	UpdatedAt time.Time             `json:"updatedAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Optional(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It returns the site's robots.txt, made from the site's `robots` rules or allowing everything
+// when it has none. Unpublished sites disallow everything, and don't point to a sitemap.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+
+	site, err := latestSite(sitePath)
+	if err != nil {
+		log.Println("Error getting site from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting site"), nil
+	}
+	if site == nil {
+		return problemResponse(http.StatusNotFound, "No site found at "+sitePath), nil
+	}
+
+	groups := site.Robots
+	if len(groups) == 0 {
+		groups = sitemap.DefaultRobots
+	}
+	sitemapURL := ""
+	if site.URL != nil && *site.URL != "" {
+		sitemapURL = strings.TrimSuffix(*site.URL, "/") + "/sitemap.xml"
+	}
+	if site.Status != Published {
+		groups = []sitemap.RobotsGroup{{UserAgents: []string{"*"}, Disallow: []string{"/"}}}
+		sitemapURL = ""
+	}
+
+	var body bytes.Buffer
+	err = sitemap.WriteRobots(&body, groups, sitemapURL)
+	if err != nil {
+		log.Println("Error writing robots.txt:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing robots.txt"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       body.String(),
+		Headers: map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		},
+	}
+
+	return response, nil
+}
+
+// latestSite returns the latest version of the site at the path that's outside the trash, or
+// nil if there isn't one
+func latestSite(sitePath string) (*Site, error) {
+	var sites []Site
+
+	key := expression.Key("type").Equal(expression.Value("site")).And(expression.Key("path").Equal(expression.Value(sitePath)))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &sites)
+	if err != nil || len(sites) == 0 {
+		return nil, err
+	}
+
+	latest := sites[0]
+	for _, site := range sites[1:] {
+		if site.UpdatedAt.After(latest.UpdatedAt) {
+			latest = site
+		}
+	}
+
+	return &latest, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/sitemap"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+type SiteStatus int
+
+const (
+	Unpublished SiteStatus = iota
+	Published
+)
+
+// Site defines the fields of the site model needed for its sitemap
+type Site struct {
+	render.Site
+	Status    SiteStatus `json:"status,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+}
+
+// Page defines the fields of the page model listed in the sitemap
+type Page struct {
+	Path      string    `json:"path"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Optional(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It lists the published site's pages in a sitemap. Past sitemap.MaxURLs pages, it returns a
+// sitemap index instead, listing the sitemaps to fetch with `?page=1`, `?page=2` and so on.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	sitePath := strings.ToLower(request.PathParameters["siteid"])
+
+	site, err := latestSite(sitePath)
+	if err != nil {
+		log.Println("Error getting site from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting site"), nil
+	}
+	if site == nil || site.Status != Published {
+		return problemResponse(http.StatusNotFound, "No published site found at "+sitePath), nil
+	}
+	if site.URL == nil || *site.URL == "" {
+		return problemResponse(http.StatusNotFound, "Site has no URL to list its pages under"), nil
+	}
+
+	pages, err := publishedPages(sitePath)
+	if err != nil {
+		log.Println("Error querying pages in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying pages"), nil
+	}
+
+	urls := make([]sitemap.URL, len(pages))
+	for i, page := range pages {
+		urls[i] = sitemap.URL{Loc: render.URL(&site.Site, page.Path), LastMod: page.UpdatedAt}
+	}
+	chunks := sitemap.Split(urls)
+
+	var body bytes.Buffer
+	number := request.QueryStringParameters["page"]
+	switch {
+	case number == "" && len(chunks) <= 1:
+		err = sitemap.Write(&body, urls)
+
+	case number == "":
+		index := make([]sitemap.URL, len(chunks))
+		for i, chunk := range chunks {
+			loc := strings.TrimSuffix(*site.URL, "/") + "/sitemap.xml?page=" + strconv.Itoa(i+1)
+			index[i] = sitemap.URL{Loc: loc, LastMod: sitemap.Latest(chunk)}
+		}
+		err = sitemap.WriteIndex(&body, index)
+
+	default:
+		n, convErr := strconv.Atoi(number)
+		if convErr != nil || n < 1 || n > len(chunks) {
+			return problemResponse(http.StatusNotFound, "No sitemap page "+number), nil
+		}
+		err = sitemap.Write(&body, chunks[n-1])
+	}
+	if err != nil {
+		log.Println("Error writing sitemap:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing sitemap"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       body.String(),
+		Headers: map[string]string{
+			"Content-Type": "application/xml; charset=utf-8",
+		},
+	}
+
+	return response, nil
+}
+
+// latestSite returns the latest version of the site at the path that's outside the trash, or
+// nil if there isn't one
+func latestSite(sitePath string) (*Site, error) {
+	var sites []Site
+
+	key := expression.Key("type").Equal(expression.Value("site")).And(expression.Key("path").Equal(expression.Value(sitePath)))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &sites)
+	if err != nil || len(sites) == 0 {
+		return nil, err
+	}
+
+	latest := sites[0]
+	for _, site := range sites[1:] {
+		if site.UpdatedAt.After(latest.UpdatedAt) {
+			latest = site
+		}
+	}
+
+	return &latest, nil
+}
+
+// publishedPages returns the latest version of each of the site's pages outside the trash, in
+// path order
+func publishedPages(sitePath string) ([]Page, error) {
+	var pages []Page
+
+	key := expression.Key("type").Equal(expression.Value("page")).And(expression.Key("path").BeginsWith(sitePath))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt"))
+	projection := expression.NamesList(expression.Name("path"), expression.Name("updatedAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).WithProjection(projection).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	queryInput := dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var results []Page
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &results); unmarshalErr != nil {
+			return false
+		}
+		for _, result := range results {
+			// the prefix also matches other sites whose paths start with this one's
+			if result.Path != sitePath && !strings.HasPrefix(result.Path, sitePath+"/") {
+				continue
+			}
+			// versions of a page come back together, since the index is sorted by path
+			last := len(pages) - 1
+			if last >= 0 && pages[last].Path == result.Path {
+				if result.UpdatedAt.After(pages[last].UpdatedAt) {
+					pages[last] = result
+				}
+				continue
+			}
+			pages = append(pages, result)
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+
+	return pages, err
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -12,13 +12,22 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/audit"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/render"
+	"github.com/feckmore/go-lambda-dynamo/sitemap"
+	"github.com/feckmore/go-lambda-dynamo/tags"
 )
 
-type Response events.APIGatewayProxyResponse
-type Request events.APIGatewayProxyRequest
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
 type SiteStatus int
 
 const (
@@ -28,22 +37,39 @@ const (
 
 // Site defines the fields of the site model
 type Site struct {
-	ID           string     `json:"id"`
-	Version      string     `json:"version"`
-	Path         string     `json:"path"`
-	Type         string     `json:"type"`
-	Status       SiteStatus `json:"status,omitempty"`
-	Name         *string    `json:"name,omitempty"`
-	Description  *string    `json:"description,omitempty"`
-	Keywords     *string    `json:"keywords,omitempty"`
-	URL          *string    `json:"url,omitempty"`
-	TagManagerID *string    `json:"tagManagerId,omitempty"`
-	CardImageURL *string    `json:"cardImageUrl,omitempty"`
-	CreatedAt    time.Time  `json:"createdAt,omitempty"`
-	UpdatedAt    time.Time  `json:"updatedAt,omitempty"`
+	ID           string                `json:"id"`
+	Version      string                `json:"version"`
+	Path         string                `json:"path"`
+	Type         string                `json:"type"`
+	Tenant       string                `json:"tenant,omitempty"`
+	Status       SiteStatus            `json:"status,omitempty"`
+	Name         *string               `json:"name,omitempty"`
+	Description  *string               `json:"description,omitempty"`
+	Keywords     *string               `json:"keywords,omitempty"`
+	Tags         []string              `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	URL          *string               `json:"url,omitempty"`
+	TagManagerID *string               `json:"tagManagerId,omitempty"`
+	CardImageURL *string               `json:"cardImageUrl,omitempty"`
+	Theme        *string               `json:"theme,omitempty"`
+	Robots       []sitemap.RobotsGroup `json:"robots,omitempty"`
+	Social       *render.Social        `json:"social,omitempty"`
+	CreatedAt    time.Time             `json:"createdAt,omitempty"`
+	UpdatedAt    time.Time             `json:"updatedAt,omitempty"`
+	DeletedAt    *time.Time            `json:"deletedAt,omitempty"`
+	ExpiresAt    int64                 `json:"expiresAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
 }
 
 var db *dynamodb.DynamoDB
+var policy *access.Policy
+var trail audit.Log
 var region, stage, table string
 var currentTime time.Time
 
@@ -62,16 +88,18 @@ func init() {
 	// TODO: validate env vars
 }
 
-// main starts the session, news up the db & invokes lambda handler
+// main starts the session, news up the db & access policy & invokes lambda handler
 func main() {
 	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
 	if err != nil {
 		log.Println("Failed to connect to AWS:", err)
 	} else {
 		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		trail = &audit.Table{DB: db, Name: table}
 	}
 
-	lambda.Start(Handler)
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
 }
 
 // Handler is our lambda handler invoked by the `lambda.Start` function call in main()
@@ -97,75 +125,137 @@ func Handler(ctx context.Context, request Request) (Response, error) {
 		TableName: aws.String(table),
 	})
 	if err != nil {
-		log.Println("Error getting site from dynamodb")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error getting site from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting site"), nil
 	}
 
 	err = dynamodbattribute.UnmarshalMap(result.Item, &original)
 	if err != nil {
-		log.Println("Error unmarshalling into site")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error unmarshalling into site:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading site"), nil
 	}
 
 	// make sure there's a valid site returned
-	if original.ID == "" {
+	if original.ID == "" || original.DeletedAt != nil {
 		log.Println("No site returned from database query")
-		return Response{StatusCode: http.StatusNotFound}, err
+		return problemResponse(http.StatusNotFound, "No site found with that id and version"), nil
+	}
+
+	// sites of other tenants aren't found, rather than forbidden, so their ids aren't confirmed
+	principal := auth.FromContext(ctx)
+	if principal != nil && original.Tenant != principal.Tenant {
+		return problemResponse(http.StatusNotFound, "No site found with that id and version"), nil
+	}
+
+	// editors & owners may change the site, publishing it included
+	role, err := policy.Role(principal, original.Path)
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Editor) {
+		return problemResponse(http.StatusForbidden, "Only the site's editors may update it"), nil
 	}
 
 	// Get site changes from request body
 	var changes Site
 	err = json.Unmarshal([]byte(request.Body), &changes)
 	if err != nil {
-		log.Println("Error unmarshalling request body into site")
-		return Response{StatusCode: http.StatusBadRequest}, err
+		log.Println("Error unmarshalling request body into site:", err)
+		return problemResponse(http.StatusBadRequest, "Invalid site in request body"), nil
 	}
 
 	// combine original site with requested changes
 	changes.ID = original.ID
 	changes.Version = original.Version //TODO: new version
 	changes.Type = "site"
+	changes.Tenant = original.Tenant // sites stay in the tenant that created them
 	if len(changes.Path) == 0 {
 		changes.Path = original.Path
 	}
+	// the site's pages, members, keys & settings are all kept under its path, so it can't change
+	if strings.ToLower(changes.Path) != original.Path {
+		log.Println("Can't change site path")
+		return problemResponse(http.StatusBadRequest, "Can't change site path"), nil
+	}
+	changes.Path = original.Path
 	changes.CreatedAt = original.CreatedAt
+	changes.DeletedAt = nil // deleting & restoring go through their own endpoints
+	changes.ExpiresAt = 0
 	changes.UpdatedAt = time.Now()
+	// sending tags or keywords replaces the site's tags, with tags taking precedence
+	changedTags, changedKeywords := changes.Tags, changes.Keywords
+	before, wasPublished := audit.Snapshot(&original), original.Status == Published
 	updated, err := mergeSites(&original, &changes)
 	if err != nil {
-		log.Println("Error merging site attributes")
-		return Response{StatusCode: http.StatusBadRequest}, err
+		log.Println("Error merging site attributes:", err)
+		return problemResponse(http.StatusBadRequest, "Invalid site changes"), nil
+	}
+	if changedTags != nil || changedKeywords != nil {
+		updated.Tags, updated.Keywords = changedTags, changedKeywords
+	}
+	updated.Tags, err = tags.Normalize(updated.Tags, updated.Keywords)
+	if err != nil {
+		log.Println("Invalid tags:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	updated.Keywords = tags.Keywords(updated.Tags)
+	if err := sitemap.ValidateRobots(updated.Robots); err != nil {
+		log.Println("Invalid robots.txt rules:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
+	}
+	if err := updated.Social.Validate(); err != nil {
+		log.Println("Invalid social metadata:", err)
+		return problemResponse(http.StatusBadRequest, err.Error()), nil
 	}
 
 	av, err := dynamodbattribute.MarshalMap(updated)
 	if err != nil {
-		log.Println("Error marshalling site into dynamodb attribute")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error marshalling site into dynamodb attribute:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing site"), nil
 	}
 
+	// the site is only written over one that's still there, so one deleted since it was read
+	// isn't brought back
 	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(table),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_exists(id) AND attribute_not_exists(deletedAt)"),
+		TableName:           aws.String(table),
 	}
 
 	_, err = db.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		log.Println("Site deleted while being updated")
+		return problemResponse(http.StatusNotFound, "No site found with that id and version"), nil
+	}
 	if err != nil {
-		log.Println("Error putting item into DyanmoDB")
-		return Response{StatusCode: http.StatusBadRequest}, err
+		log.Println("Error putting item into DyanmoDB:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing site"), nil
+	}
+
+	// the site is already written, so failing to audit it is logged rather than reported
+	action := audit.Update
+	if !wasPublished && updated.Status == Published {
+		action = audit.Publish
+	} else if wasPublished && updated.Status != Published {
+		action = audit.Unpublish
+	}
+	target := audit.Target{Type: "site", ID: updated.ID, Version: updated.Version, Path: updated.Path}
+	if err := trail.Append(audit.NewEntry(request.RequestContext, action, target, before, updated)); err != nil {
+		log.Println("Error appending to audit trail:", err)
 	}
 
 	body, err := json.Marshal(updated)
 	if err != nil {
-		log.Println("Error marshalling site into json for response body")
-		return Response{StatusCode: http.StatusInternalServerError}, err
+		log.Println("Error marshalling site into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing site"), nil
 	}
 
 	response := Response{
 		StatusCode: http.StatusOK,
 		Body:       string(body),
 		Headers: map[string]string{
-			"Content-Type":                     "application/json",
-			"Access-Control-Allow-Origin":      "*",
-			"Access-Control-Allow-Credentials": "true",
+			"Content-Type": "application/json",
 		},
 	}
 
@@ -190,3 +280,22 @@ func mergeSites(original, changes *Site) (*Site, error) {
 
 	return original, nil
 }
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
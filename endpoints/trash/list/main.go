@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Item defines the fields shown for a site or page in the trash
+type Item struct {
+	ID        string     `json:"id"`
+	Version   string     `json:"version"`
+	Path      string     `json:"path"`
+	Type      string     `json:"type"`
+	Name      *string    `json:"name,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	ExpiresAt int64      `json:"expiresAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db dynamodbiface.DynamoDBAPI
+var policy *access.Policy
+var members *access.Table
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		members = &access.Table{DB: db, Name: table}
+		policy = access.FromEnv(members)
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It lists the sites and pages in the trash, optionally limited by the `type` query parameter.
+// Pages are listed for the sites the caller has a role in, and sites for those of its tenant it
+// was a member of when they went into the trash. Each is queried by its site's path, so no other
+// tenant's items are read.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	items := []Item{}
+
+	listSites, listPages := true, true
+	switch request.QueryStringParameters["type"] {
+	case "":
+	case "site":
+		listPages = false
+	case "page":
+		listSites = false
+	default:
+		return problemResponse(http.StatusBadRequest, "Type must be site or page"), nil
+	}
+
+	principal := auth.FromContext(ctx)
+	if listSites {
+		sites, err := trashedSites(principal)
+		if err != nil {
+			log.Println("Error querying memberships in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+		}
+		for _, site := range sites {
+			key := expression.Key("type").Equal(expression.Value("site")).And(expression.Key("path").Equal(expression.Value(site)))
+			trashed, err := queryTrash(key, inTrash().And(access.TenantCondition(principal.Tenant)))
+			if err != nil {
+				log.Println("Error querying trash in dynamodb:", err)
+				return problemResponse(http.StatusInternalServerError, "Error querying trash"), nil
+			}
+			items = append(items, trashed...)
+		}
+	}
+
+	if listPages {
+		sites, err := policy.Sites(principal)
+		if err != nil {
+			log.Println("Error querying memberships in dynamodb:", err)
+			return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+		}
+		for _, site := range sites {
+			key := expression.Key("type").Equal(expression.Value("page")).And(expression.Key("path").BeginsWith(site))
+			trashed, err := queryTrash(key, inTrash())
+			if err != nil {
+				log.Println("Error querying trash in dynamodb:", err)
+				return problemResponse(http.StatusInternalServerError, "Error querying trash"), nil
+			}
+			// the prefix also matches other sites whose paths start with this one's
+			for _, item := range trashed {
+				if access.SiteOf(item.Path) == site {
+					items = append(items, item)
+				}
+			}
+		}
+	}
+
+	body, err := json.Marshal(items)
+	if err != nil {
+		log.Println("Error marshalling trash into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing trash"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// trashedSites returns the paths of the sites in the trash the principal may see there: every
+// one of its tenant's for admins, and otherwise those it was a member of. API keys have none.
+func trashedSites(principal *auth.Principal) ([]string, error) {
+	if principal == nil || principal.Method == "apikey" {
+		return nil, nil
+	}
+
+	var paths []string
+	if policy.Admin(principal) {
+		// there's no index of sites by tenant, so the tenant's are filtered from the sites
+		key := expression.Key("type").Equal(expression.Value("site"))
+		trashed, err := queryTrash(key, inTrash().And(access.TenantCondition(principal.Tenant)))
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range trashed {
+			paths = append(paths, item.Path)
+		}
+	} else {
+		memberships, err := members.TrashedMemberships(principal.Subject)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range memberships {
+			paths = append(paths, member.Site)
+		}
+	}
+
+	// a site's versions share its path, so it's only queried once however many are in the trash
+	var sites []string
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			sites = append(sites, path)
+		}
+	}
+
+	return sites, nil
+}
+
+// inTrash is the condition of the items in the trash
+func inTrash() expression.ConditionBuilder {
+	return expression.AttributeExists(expression.Name("deletedAt"))
+}
+
+// queryTrash returns the items of the type-path index query with the key that meet the filter
+func queryTrash(key expression.KeyConditionBuilder, filter expression.ConditionBuilder) ([]Item, error) {
+	var items []Item
+
+	projection := expression.NamesList(expression.Name("id"), expression.Name("version"), expression.Name("path"), expression.Name("type"), expression.Name("name"), expression.Name("updatedAt"), expression.Name("deletedAt"), expression.Name("expiresAt"))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).WithProjection(projection).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	var unmarshalErr error
+	err = db.QueryPages(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var results []Item
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &results); unmarshalErr != nil {
+			return false
+		}
+		items = append(items, results...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+
+	return items, err
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
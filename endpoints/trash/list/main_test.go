@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/store"
+)
+
+func TestListTrash(t *testing.T) {
+	setupTrash(t)
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		itemType  string
+		want      string
+	}{
+		{"member, every type", member("user-1"), "", "gone,page-1"},
+		{"member, sites", member("user-1"), "site", "gone"},
+		{"member, pages", member("user-1"), "page", "page-1"},
+		{"admin, sites", member("admin-1"), "site", "gone,other"},
+		{"non-member", member("user-2"), "", ""},
+		{"api key, sites", &auth.Principal{Subject: "key-1", Method: "apikey", Tenant: "acme-corp", Sites: []string{"acme"}}, "site", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := Request{QueryStringParameters: map[string]string{"type": test.itemType}}
+			response, err := Handler(auth.NewContext(context.Background(), test.principal), request)
+			if err != nil || response.StatusCode != http.StatusOK {
+				t.Fatalf("ListTrash Handler: got code %v, error %v, body %v; wanted %v", response.StatusCode, err, response.Body, http.StatusOK)
+			}
+
+			var items []Item
+			json.Unmarshal([]byte(response.Body), &items)
+			var ids []string
+			for _, item := range items {
+				ids = append(ids, item.ID)
+			}
+			sort.Strings(ids)
+			if got := strings.Join(ids, ","); got != test.want {
+				t.Errorf("ListTrash Handler: got items %q; wanted %q", got, test.want)
+			}
+		})
+	}
+
+	request := Request{QueryStringParameters: map[string]string{"type": "member"}}
+	response, _ := Handler(auth.NewContext(context.Background(), member("user-1")), request)
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("ListTrash Handler: got code %v listing members; wanted %v", response.StatusCode, http.StatusBadRequest)
+	}
+}
+
+// setupTrash points the handler at a memory store holding the live acme site edited by user-1,
+// with a page in the trash and one that isn't, a page in the trash of the acme2 site, whose path
+// starts with acme's, and sites in the trash: gone, which user-1 was a member of, other, which
+// it wasn't, and initech, of another tenant
+func setupTrash(t *testing.T) *store.Memory {
+	memory := store.NewMemory()
+	db = memory
+	table = "go-lambda-dynamo"
+	members = &access.Table{DB: memory, Name: table}
+	policy = &access.Policy{Store: members, Admins: []string{"admin-1"}}
+
+	deletedAt := time.Now()
+	trashed := func(item map[string]interface{}) map[string]interface{} {
+		item["deletedAt"] = deletedAt
+		item["expiresAt"] = deletedAt.Add(24 * time.Hour).Unix()
+		return item
+	}
+	membership := func(site string) map[string]interface{} {
+		var item map[string]interface{}
+		av, _ := dynamodbattribute.MarshalMap(access.Item(&access.Member{Site: site, Subject: "user-1", Role: access.Editor}))
+		dynamodbattribute.UnmarshalMap(av, &item)
+		return item
+	}
+
+	put(t, memory, map[string]interface{}{"id": "site-1", "version": "v1", "type": "site", "path": "acme", "tenant": "acme-corp"})
+	put(t, memory, membership("acme"))
+	put(t, memory, trashed(map[string]interface{}{"id": "page-1", "version": "p1", "type": "page", "path": "acme/about"}))
+	put(t, memory, map[string]interface{}{"id": "page-2", "version": "p1", "type": "page", "path": "acme/live"})
+	put(t, memory, map[string]interface{}{"id": "site-2", "version": "v1", "type": "site", "path": "acme2", "tenant": "acme-corp"})
+	put(t, memory, trashed(map[string]interface{}{"id": "page-3", "version": "p1", "type": "page", "path": "acme2/about"}))
+
+	put(t, memory, trashed(map[string]interface{}{"id": "gone", "version": "v1", "type": "site", "path": "gone", "tenant": "acme-corp"}))
+	put(t, memory, trashed(membership("gone")))
+	put(t, memory, trashed(map[string]interface{}{"id": "other", "version": "v1", "type": "site", "path": "other", "tenant": "acme-corp"}))
+	put(t, memory, trashed(map[string]interface{}{"id": "initech", "version": "v1", "type": "site", "path": "initech", "tenant": "initech"}))
+	put(t, memory, trashed(membership("initech")))
+
+	return memory
+}
+
+// member returns the principal of the subject, signed in to the acme-corp tenant
+func member(subject string) *auth.Principal {
+	return &auth.Principal{Subject: subject, Method: "jwt", Tenant: "acme-corp"}
+}
+
+// put adds the item to the memory store
+func put(t *testing.T, memory *store.Memory, item interface{}) {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memory.PutItem(&dynamodb.PutItemInput{Item: av, TableName: aws.String(table)}); err != nil {
+		t.Fatal(err)
+	}
+}
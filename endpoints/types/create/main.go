@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/google/uuid"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// ContentType declares the custom fields of the site's pages of that type with a JSON Schema.
+// Content types are stored in the table alongside the site, with the site's path.
+type ContentType struct {
+	ID          string          `json:"id"`
+	Version     string          `json:"version"`
+	Path        string          `json:"path"`
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Description *string         `json:"description,omitempty"`
+	Schema      *content.Schema `json:"schema"`
+	CreatedAt   time.Time       `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time       `json:"updatedAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// validName is the form of content type names, which pages refer to their type by
+var validName = regexp.MustCompile(`^[a-z][a-z0-9-]{0,63}$`)
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It adds a content type to the site's registry. The name must be unique within the site.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	// editors & owners may change the site's content types
+	role, err := policy.Role(auth.FromContext(ctx), request.PathParameters["siteid"])
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Editor) {
+		return problemResponse(http.StatusForbidden, "Only the site's editors and owners may change its content types"), nil
+	}
+
+	sitePath := request.PathParameters["siteid"]
+
+	var contentType *ContentType
+	err = json.Unmarshal([]byte(request.Body), &contentType)
+	if contentType == nil || err != nil {
+		log.Println("Error unmarshalling request body into content type:", err)
+		return problemResponse(http.StatusBadRequest, "Request body is not a valid content type: "+errorDetail(err)), nil
+	}
+	if !validName.MatchString(contentType.Name) {
+		return problemResponse(http.StatusBadRequest, "Content type name must be lowercase letters, digits & dashes, starting with a letter"), nil
+	}
+	if err := contentType.Schema.Check(); err != nil {
+		return problemResponse(http.StatusBadRequest, "Invalid schema: "+err.Error()), nil
+	}
+
+	existing, err := getContentType(sitePath, contentType.Name)
+	if err != nil {
+		log.Println("Error getting content type from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting content type"), nil
+	}
+	if existing != nil {
+		return problemResponse(http.StatusConflict, "A content type named "+contentType.Name+" already exists"), nil
+	}
+
+	currentTime := time.Now()
+	contentType.ID = uuid.New().String()
+	contentType.Version = uuid.New().String()
+	contentType.Path = strings.ToLower(sitePath)
+	contentType.Type = "contentType"
+	contentType.CreatedAt = currentTime
+	contentType.UpdatedAt = currentTime
+
+	av, err := dynamodbattribute.MarshalMap(contentType)
+	if err != nil {
+		log.Println("Error marshalling content type into dynamodb attribute:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing content type"), nil
+	}
+
+	_, err = db.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(table),
+	})
+	if err != nil {
+		log.Println("Error putting item into DyanmoDB:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing content type"), nil
+	}
+
+	body, err := json.Marshal(contentType)
+	if err != nil {
+		log.Println("Error marshalling content type into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing content type"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusCreated,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// errorDetail describes the unmarshalling error, which for schemas names the unsupported keyword
+func errorDetail(err error) string {
+	if err == nil {
+		return "missing"
+	}
+	return err.Error()
+}
+
+// getContentType returns the site's content type with the name, or nil if there isn't one
+func getContentType(sitePath, name string) (*ContentType, error) {
+	var contentTypes []ContentType
+
+	key := expression.Key("type").Equal(expression.Value("contentType")).And(expression.Key("path").Equal(expression.Value(strings.ToLower(sitePath))))
+	filter := expression.Name("name").Equal(expression.Value(name))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &contentTypes)
+	if err != nil || len(contentTypes) == 0 {
+		return nil, err
+	}
+
+	return &contentTypes[0], nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
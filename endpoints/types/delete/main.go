@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/content"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// ContentType declares the custom fields of the site's pages of that type with a JSON Schema.
+// Content types are stored in the table alongside the site, with the site's path.
+type ContentType struct {
+	ID          string          `json:"id"`
+	Version     string          `json:"version"`
+	Path        string          `json:"path"`
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Description *string         `json:"description,omitempty"`
+	Schema      *content.Schema `json:"schema"`
+	CreatedAt   time.Time       `json:"createdAt,omitempty"`
+	UpdatedAt   time.Time       `json:"updatedAt,omitempty"`
+}
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var region, stage, table string
+var noContent bool
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// respond with 204 and no body instead of the deleted content type
+	noContent = os.Getenv("DELETE_NO_CONTENT") == "true"
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// Content types still used by pages outside the trash can't be deleted. The deleted content type
+// is returned, or 204 No Content when DELETE_NO_CONTENT is set.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	// editors & owners may change the site's content types
+	role, err := policy.Role(auth.FromContext(ctx), request.PathParameters["siteid"])
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Editor) {
+		return problemResponse(http.StatusForbidden, "Only the site's editors and owners may change its content types"), nil
+	}
+
+	var deleted ContentType
+	sitePath := request.PathParameters["siteid"]
+
+	contentType, err := getContentType(sitePath, request.PathParameters["typename"])
+	if err != nil {
+		log.Println("Error getting content type from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting content type"), nil
+	}
+	if contentType == nil {
+		return problemResponse(http.StatusNotFound, "No content type found with that name"), nil
+	}
+
+	used, err := countPages(sitePath, contentType.Name)
+	if err != nil {
+		log.Println("Error querying pages in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying pages"), nil
+	}
+	if used > 0 {
+		return problemResponse(http.StatusConflict, "Content type is used by pages"), nil
+	}
+
+	key := map[string]*dynamodb.AttributeValue{
+		"id":      {S: aws.String(contentType.ID)},
+		"version": {S: aws.String(contentType.Version)},
+	}
+
+	result, err := db.DeleteItem(&dynamodb.DeleteItemInput{
+		Key:                 key,
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ReturnValues:        aws.String(dynamodb.ReturnValueAllOld),
+		TableName:           aws.String(table),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return problemResponse(http.StatusNotFound, "No content type found with that name"), nil
+	}
+	if err != nil {
+		log.Println("Error deleting content type in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error deleting content type"), nil
+	}
+
+	if noContent {
+		response := Response{
+			StatusCode: http.StatusNoContent,
+		}
+
+		return response, nil
+	}
+
+	err = dynamodbattribute.UnmarshalMap(result.Attributes, &deleted)
+	if err != nil {
+		log.Println("Error unmarshalling into content type:", err)
+		return problemResponse(http.StatusInternalServerError, "Error reading deleted content type"), nil
+	}
+
+	body, err := json.Marshal(deleted)
+	if err != nil {
+		log.Println("Error marshalling content type into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing deleted content type"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// countPages returns how many of the site's pages outside the trash are of the content type
+func countPages(sitePath, name string) (int64, error) {
+	var count int64
+
+	key := expression.Key("type").Equal(expression.Value("page")).And(expression.Key("path").BeginsWith(sitePath))
+	filter := expression.Name("contentType").Equal(expression.Value(name)).And(expression.AttributeNotExists(expression.Name("deletedAt")))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return 0, err
+	}
+
+	queryInput := dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		Select:                    aws.String(dynamodb.SelectCount),
+		TableName:                 aws.String(table),
+	}
+
+	err = db.QueryPages(&queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		count += aws.Int64Value(page.Count)
+		return true
+	})
+
+	return count, err
+}
+
+// getContentType returns the site's content type with the name, or nil if there isn't one
+func getContentType(sitePath, name string) (*ContentType, error) {
+	var contentTypes []ContentType
+
+	key := expression.Key("type").Equal(expression.Value("contentType")).And(expression.Key("path").Equal(expression.Value(strings.ToLower(sitePath))))
+	filter := expression.Name("name").Equal(expression.Value(name))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(table),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &contentTypes)
+	if err != nil || len(contentTypes) == 0 {
+		return nil, err
+	}
+
+	return &contentTypes[0], nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
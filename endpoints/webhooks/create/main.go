@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/webhooks"
+	"github.com/google/uuid"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It subscribes a URL to changes in the site. The webhook's secret is generated here, and this
+// is the only response that includes it, for the receiver to verify signatures with.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	// only owners may manage the site's webhooks, which send its pages outside of it
+	role, err := policy.Role(auth.FromContext(ctx), request.PathParameters["siteid"])
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Owner) {
+		return problemResponse(http.StatusForbidden, "Only the site's owners may manage its webhooks"), nil
+	}
+
+	sitePath := request.PathParameters["siteid"]
+
+	var hook *webhooks.Webhook
+	err = json.Unmarshal([]byte(request.Body), &hook)
+	if hook == nil || err != nil {
+		log.Println("Error unmarshalling request body into webhook:", err)
+		return problemResponse(http.StatusBadRequest, "Request body is not a valid webhook"), nil
+	}
+
+	currentTime := time.Now()
+	hook.ID = uuid.New().String()
+	hook.Version = uuid.New().String()
+	hook.Type = "webhook"
+	hook.Path = strings.ToLower(sitePath)
+	hook.URL = strings.TrimSpace(hook.URL)
+	hook.Events = normalizeEvents(hook.Events)
+	hook.CreatedAt = currentTime
+	hook.UpdatedAt = currentTime
+
+	if detail := webhooks.Validate(hook); detail != "" {
+		return problemResponse(http.StatusBadRequest, detail), nil
+	}
+
+	hook.Secret, err = webhooks.NewSecret()
+	if err != nil {
+		log.Println("Error generating webhook secret:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing webhook"), nil
+	}
+
+	av, err := dynamodbattribute.MarshalMap(hook)
+	if err != nil {
+		log.Println("Error marshalling webhook into dynamodb attribute:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing webhook"), nil
+	}
+
+	_, err = db.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(table),
+	})
+	if err != nil {
+		log.Println("Error putting item into DyanmoDB:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing webhook"), nil
+	}
+
+	body, err := json.Marshal(hook)
+	if err != nil {
+		log.Println("Error marshalling webhook into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing webhook"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusCreated,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// normalizeEvents returns the events trimmed, lowercased, deduplicated & sorted
+func normalizeEvents(names []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
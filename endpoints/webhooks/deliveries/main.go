@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/feckmore/go-lambda-dynamo/access"
+	"github.com/feckmore/go-lambda-dynamo/apikeys"
+	"github.com/feckmore/go-lambda-dynamo/auth"
+	"github.com/feckmore/go-lambda-dynamo/cors"
+	"github.com/feckmore/go-lambda-dynamo/webhooks"
+)
+
+type Response = events.APIGatewayProxyResponse
+type Request = events.APIGatewayProxyRequest
+
+// Problem is an RFC 7807 problem details body returned with error responses
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// defaultLimit & maxLimit bound how many deliveries are listed
+const defaultLimit, maxLimit = 20, 100
+
+var db *dynamodb.DynamoDB
+var policy *access.Policy
+var hooks *webhooks.Table
+var region, stage, table string
+
+func init() {
+	// Enable line numbers in log output, but remove date/time
+	log.SetFlags(log.Llongfile)
+
+	region = strings.TrimSpace(os.Getenv("AWS_REGION"))
+	stage = os.Getenv("STAGE")
+	table = os.Getenv("TABLE_NAME")
+
+	log.Println("AWS_REGION:", region)
+	log.Println("STAGE:", stage)
+	log.Println("TABLE_NAME:", table)
+
+	// TODO: validate env vars
+}
+
+// main starts the session, news up the db & webhook store & access policy & invokes lambda handler
+func main() {
+	session, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Println("Failed to connect to AWS:", err)
+	} else {
+		db = dynamodb.New(session)
+		policy = access.FromEnv(&access.Table{DB: db, Name: table})
+		hooks = &webhooks.Table{DB: db, Name: table}
+	}
+
+	lambda.Start(cors.Apply(cors.FromEnv(), auth.Require(auth.FromEnv(apikeys.NewAuthenticator(db, table)), Handler)))
+}
+
+// Handler is our lambda handler invoked by the `lambda.Start` function call in main().
+// It lists the webhook's latest deliveries, newest first, up to the limit query parameter.
+// Deliveries are kept for 30 days.
+func Handler(ctx context.Context, request Request) (Response, error) {
+	// only owners may manage the site's webhooks, which send its pages outside of it
+	role, err := policy.Role(auth.FromContext(ctx), request.PathParameters["siteid"])
+	if err != nil {
+		log.Println("Error getting member from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error checking site membership"), nil
+	}
+	if !role.AtLeast(access.Owner) {
+		return problemResponse(http.StatusForbidden, "Only the site's owners may manage its webhooks"), nil
+	}
+
+	limit := defaultLimit
+	if value, ok := request.QueryStringParameters["limit"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLimit {
+			return problemResponse(http.StatusBadRequest, "Limit must be a number from 1 to "+strconv.Itoa(maxLimit)), nil
+		}
+		limit = n
+	}
+
+	hook, err := hooks.Webhook(request.PathParameters["siteid"], request.PathParameters["webhookid"])
+	if err != nil {
+		log.Println("Error getting webhook from dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error getting webhook"), nil
+	}
+	if hook == nil {
+		return problemResponse(http.StatusNotFound, "No webhook found with that id"), nil
+	}
+
+	deliveries, err := hooks.Deliveries(hook.ID, int64(limit))
+	if err != nil {
+		log.Println("Error querying deliveries in dynamodb:", err)
+		return problemResponse(http.StatusInternalServerError, "Error querying deliveries"), nil
+	}
+
+	body, err := json.Marshal(deliveries)
+	if err != nil {
+		log.Println("Error marshalling deliveries into json for response body:", err)
+		return problemResponse(http.StatusInternalServerError, "Error writing deliveries"), nil
+	}
+
+	response := Response{
+		StatusCode: http.StatusOK,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+
+	return response, nil
+}
+
+// problemResponse returns an application/problem+json response for the status. The error is
+// reported in the body rather than returned, since API Gateway turns handler errors into 502s.
+func problemResponse(status int, detail string) Response {
+	body, _ := json.Marshal(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+
+	return Response{
+		StatusCode: status,
+		Body:       string(body),
+		Headers: map[string]string{
+			"Content-Type": "application/problem+json",
+		},
+	}
+}
@@ -0,0 +1,159 @@
+// Package feed writes Atom (RFC 4287) and RSS 2.0 feeds of a site's recently updated pages.
+package feed
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// Feed is a site's feed, with its entries newest first
+type Feed struct {
+	Title       string
+	Description string
+	Link        string
+	Updated     time.Time
+	Entries     []Entry
+}
+
+// Entry is a page in the feed. ID identifies the page permanently, even if its link changes.
+type Entry struct {
+	ID      string
+	Title   string
+	Summary string
+	Author  string
+	Link    string
+	Updated time.Time
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Tagline string      `xml:"subtitle,omitempty"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Summary string      `xml:"summary,omitempty"`
+	Author  *atomAuthor `xml:"author"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// WriteAtom writes the feed as Atom. Atom requires an author for every entry, so entries without
+// one are credited to the feed's title.
+func WriteAtom(w io.Writer, feed Feed) error {
+	doc := atomFeed{
+		ID:      feed.Link,
+		Title:   feed.Title,
+		Tagline: feed.Description,
+		Links:   []atomLink{{Href: feed.Link}},
+		Updated: feed.Updated.UTC().Format(time.RFC3339),
+		Entries: make([]atomEntry, len(feed.Entries)),
+	}
+
+	for i, entry := range feed.Entries {
+		author := entry.Author
+		if author == "" {
+			author = feed.Title
+		}
+		doc.Entries[i] = atomEntry{
+			ID:      entry.ID,
+			Title:   entry.Title,
+			Link:    atomLink{Rel: "alternate", Href: entry.Link},
+			Updated: entry.Updated.UTC().Format(time.RFC3339),
+			Summary: entry.Summary,
+			Author:  &atomAuthor{Name: author},
+		}
+	}
+
+	return write(w, doc)
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	Description string  `xml:"description,omitempty"`
+	Creator     string  `xml:"dc:creator,omitempty"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// WriteRSS writes the feed as RSS 2.0. Authors go in dc:creator, since RSS's own author element
+// has to be an email address.
+func WriteRSS(w io.Writer, feed Feed) error {
+	doc := rss{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         feed.Title,
+			Link:          feed.Link,
+			Description:   feed.Description,
+			LastBuildDate: feed.Updated.UTC().Format(time.RFC1123Z),
+			Items:         make([]rssItem, len(feed.Entries)),
+		},
+	}
+
+	for i, entry := range feed.Entries {
+		doc.Channel.Items[i] = rssItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			GUID:        rssGUID{Value: entry.ID, IsPermaLink: entry.ID == entry.Link},
+			Description: entry.Summary,
+			Creator:     entry.Author,
+			PubDate:     entry.Updated.UTC().Format(time.RFC1123Z),
+		}
+	}
+
+	return write(w, doc)
+}
+
+// write writes the document as indented XML with the XML declaration
+func write(w io.Writer, document interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,76 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testFeed = Feed{
+	Title:       "Acme Blog",
+	Description: "News & notes",
+	Link:        "https://acme.example.com/",
+	Updated:     time.Date(2019, 4, 2, 9, 30, 0, 0, time.UTC),
+	Entries: []Entry{
+		{ID: "https://acme.example.com/blog/rockets", Title: "Rockets <3", Summary: "Faster", Author: "Wile E.", Link: "https://acme.example.com/blog/rockets", Updated: time.Date(2019, 4, 2, 9, 30, 0, 0, time.UTC)},
+		{ID: "urn:uuid:c0ffee", Title: "Anvils", Link: "https://acme.example.com/blog/anvils", Updated: time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)},
+	},
+}
+
+func TestWriteAtom(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteAtom(&b, testFeed); err != nil {
+		t.Fatalf("WriteAtom: %v", err)
+	}
+
+	for _, want := range []string{
+		`<feed xmlns="http://www.w3.org/2005/Atom">`,
+		"<updated>2019-04-02T09:30:00Z</updated>",
+		"<title>Rockets &lt;3</title>",
+		`<link rel="alternate" href="https://acme.example.com/blog/rockets"></link>`,
+		"<name>Wile E.</name>",
+		"<name>Acme Blog</name>",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteAtom: missing %s in\n%s", want, b.String())
+		}
+	}
+	assertWellFormed(t, b.Bytes())
+}
+
+func TestWriteRSS(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteRSS(&b, testFeed); err != nil {
+		t.Fatalf("WriteRSS: %v", err)
+	}
+
+	for _, want := range []string{
+		`<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">`,
+		"<lastBuildDate>Tue, 02 Apr 2019 09:30:00 +0000</lastBuildDate>",
+		`<guid isPermaLink="true">https://acme.example.com/blog/rockets</guid>`,
+		`<guid isPermaLink="false">urn:uuid:c0ffee</guid>`,
+		"<dc:creator>Wile E.</dc:creator>",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteRSS: missing %s in\n%s", want, b.String())
+		}
+	}
+	assertWellFormed(t, b.Bytes())
+}
+
+// assertWellFormed fails the test when the document isn't well formed XML
+func assertWellFormed(t *testing.T, document []byte) {
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	for {
+		_, err := decoder.Token()
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("document isn't well formed: %v", err)
+			}
+			return
+		}
+	}
+}
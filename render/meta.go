@@ -0,0 +1,109 @@
+package render
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Social is the social sharing metadata of a site or page. Anything a page leaves out is
+// inherited from the site, then from the page's own name & description.
+type Social struct {
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Image       *string `json:"image,omitempty"`
+	TwitterCard *string `json:"twitterCard,omitempty"`
+	TwitterSite *string `json:"twitterSite,omitempty"`
+	Canonical   *string `json:"canonical,omitempty"`
+}
+
+// twitterCards are the card types Twitter renders
+var twitterCards = map[string]bool{"summary": true, "summary_large_image": true, "app": true, "player": true}
+
+// Validate returns what is wrong with the social metadata, or nil when it's valid. Crawlers
+// only follow absolute URLs for images & canonical links.
+func (s *Social) Validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.Image != nil && !isAbsolute(*s.Image) {
+		return fmt.Errorf("social image must be an absolute http(s) URL")
+	}
+	if s.Canonical != nil && !isAbsolute(*s.Canonical) {
+		return fmt.Errorf("social canonical must be an absolute http(s) URL")
+	}
+	if s.TwitterCard != nil && !twitterCards[*s.TwitterCard] {
+		return fmt.Errorf("social twitterCard must be summary, summary_large_image, app or player")
+	}
+
+	return nil
+}
+
+// Tag is a meta tag, identified by either a property (Open Graph) or a name
+type Tag struct {
+	Property string `json:"property,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Content  string `json:"content"`
+}
+
+// Metadata is a page's resolved title, canonical URL & meta tags
+type Metadata struct {
+	Title     string `json:"title"`
+	Canonical string `json:"canonical,omitempty"`
+	Tags      []Tag  `json:"tags"`
+}
+
+// Meta resolves the page's metadata, with each value taken from the first of these that has it:
+//   - title: page social title, page name, site social title, site name
+//   - description: page social description, page description, site social description, site description
+//   - image: page social image, site social image, site card image
+//   - twitter card: page, then site, then summary_large_image with an image or summary without
+//   - canonical: page social canonical, then the page's URL on the site
+func Meta(site *Site, page *Page) Metadata {
+	pageSocial, siteSocial := page.Social, site.Social
+	if pageSocial == nil {
+		pageSocial = &Social{}
+	}
+	if siteSocial == nil {
+		siteSocial = &Social{}
+	}
+
+	title := first(pageSocial.Title, page.Name, siteSocial.Title, site.Name)
+	description := first(pageSocial.Description, page.Description, siteSocial.Description, site.Description)
+	image := first(pageSocial.Image, siteSocial.Image, site.CardImageURL)
+	canonical := first(pageSocial.Canonical)
+	if canonical == "" {
+		canonical = URL(site, page.Path)
+	}
+	card := first(pageSocial.TwitterCard, siteSocial.TwitterCard)
+	if card == "" && image != "" {
+		card = "summary_large_image"
+	} else if card == "" {
+		card = "summary"
+	}
+
+	meta := Metadata{Title: title, Canonical: canonical}
+	add := func(tag Tag) {
+		if tag.Content != "" {
+			meta.Tags = append(meta.Tags, tag)
+		}
+	}
+	add(Tag{Name: "description", Content: first(page.Description, site.Description)})
+	add(Tag{Name: "keywords", Content: first(page.Keywords, site.Keywords)})
+	add(Tag{Name: "author", Content: first(page.Author)})
+	add(Tag{Property: "og:type", Content: "website"})
+	add(Tag{Property: "og:site_name", Content: first(site.Name)})
+	add(Tag{Property: "og:title", Content: title})
+	add(Tag{Property: "og:description", Content: description})
+	add(Tag{Property: "og:image", Content: image})
+	add(Tag{Property: "og:url", Content: canonical})
+	add(Tag{Name: "twitter:card", Content: card})
+	add(Tag{Name: "twitter:site", Content: first(pageSocial.TwitterSite, siteSocial.TwitterSite)})
+
+	return meta
+}
+
+// isAbsolute reports whether the link is an absolute http(s) URL
+func isAbsolute(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
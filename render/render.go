@@ -0,0 +1,175 @@
+// Package render turns a site's published pages into HTML with html/template themes. The live
+// render endpoint and the static export both use it, so they produce the same pages.
+package render
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/feckmore/go-lambda-dynamo/content"
+)
+
+// Site defines the fields of the site model used in rendering its pages
+type Site struct {
+	Path         string  `json:"path"`
+	Name         *string `json:"name,omitempty"`
+	Description  *string `json:"description,omitempty"`
+	Keywords     *string `json:"keywords,omitempty"`
+	URL          *string `json:"url,omitempty"`
+	TagManagerID *string `json:"tagManagerId,omitempty"`
+	CardImageURL *string `json:"cardImageUrl,omitempty"`
+	Theme        *string `json:"theme,omitempty"`
+	Social       *Social `json:"social,omitempty"`
+}
+
+// Page defines the fields of the page model used in rendering it
+type Page struct {
+	ID          string                 `json:"id"`
+	Version     string                 `json:"version"`
+	Path        string                 `json:"path"`
+	Name        *string                `json:"name,omitempty"`
+	Description *string                `json:"description,omitempty"`
+	Keywords    *string                `json:"keywords,omitempty"`
+	Author      *string                `json:"author,omitempty"`
+	Blocks      []content.Block        `json:"blocks,omitempty"`
+	ContentType *string                `json:"contentType,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Social      *Social                `json:"social,omitempty"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+}
+
+// Data is what themes are executed with
+type Data struct {
+	Site         *Site
+	Page         *Page
+	Meta         Metadata
+	TagManagerID string
+	Content      template.HTML
+}
+
+// HTML renders the page of the site with the site's theme, or the default theme when the site
+// doesn't have one that exists
+func HTML(w io.Writer, site *Site, page *Page) error {
+	theme := themes[DefaultTheme]
+	if site.Theme != nil && themes[*site.Theme] != nil {
+		theme = themes[*site.Theme]
+	}
+
+	data := Data{
+		Site:         site,
+		Page:         page,
+		Meta:         Meta(site, page),
+		TagManagerID: first(site.TagManagerID),
+		Content:      Blocks(page.Blocks),
+	}
+
+	return theme.Execute(w, data)
+}
+
+// Relative returns the page's path relative to the site, which is where it's served from
+func Relative(site *Site, pagePath string) string {
+	relative := strings.TrimPrefix(pagePath, site.Path)
+	if !strings.HasPrefix(relative, "/") {
+		relative = "/" + relative
+	}
+
+	return relative
+}
+
+// URL returns the page's public URL on the site, or an empty string when the site has no URL
+func URL(site *Site, pagePath string) string {
+	if site.URL == nil || *site.URL == "" {
+		return ""
+	}
+
+	return strings.TrimSuffix(*site.URL, "/") + Relative(site, pagePath)
+}
+
+// Blocks renders the content blocks to HTML. Blocks are validated when pages are written, but
+// links are checked again here so a bad one can never reach the page.
+func Blocks(blocks []content.Block) template.HTML {
+	var b strings.Builder
+	escape := template.HTMLEscapeString
+
+	for _, block := range blocks {
+		switch block.Type {
+		case content.RichText:
+			b.WriteString("<p>")
+			for _, span := range block.Spans {
+				writeSpan(&b, span)
+			}
+			b.WriteString("</p>\n")
+
+		case content.Heading:
+			level := block.Level
+			if level < 1 || level > 6 {
+				level = 2
+			}
+			fmt.Fprintf(&b, "<h%d>%s</h%d>\n", level, escape(block.Text), level)
+
+		case content.Image:
+			if !content.IsLink(block.URL) {
+				continue
+			}
+			fmt.Fprintf(&b, `<figure><img src="%s" alt="%s">`, escape(block.URL), escape(block.Alt))
+			writeCaption(&b, block.Caption)
+
+		case content.Embed:
+			if !strings.HasPrefix(block.URL, "https://") {
+				continue
+			}
+			fmt.Fprintf(&b, `<figure><iframe src="%s" loading="lazy" allowfullscreen></iframe>`, escape(block.URL))
+			writeCaption(&b, block.Caption)
+
+		case content.CallToAction:
+			if !content.IsLink(block.URL) {
+				continue
+			}
+			fmt.Fprintf(&b, "<p class=\"cta\"><a class=\"button\" href=\"%s\">%s</a></p>\n", escape(block.URL), escape(block.Text))
+		}
+	}
+
+	return template.HTML(b.String())
+}
+
+// writeSpan writes a run of rich text, wrapped in its marks and link
+func writeSpan(b *strings.Builder, span content.Span) {
+	text := template.HTMLEscapeString(span.Text)
+	for _, mark := range span.Marks {
+		switch mark {
+		case content.Bold:
+			text = "<strong>" + text + "</strong>"
+		case content.Italic:
+			text = "<em>" + text + "</em>"
+		case content.Code:
+			text = "<code>" + text + "</code>"
+		}
+	}
+	if span.Href != "" && content.IsLink(span.Href) {
+		text = `<a href="` + template.HTMLEscapeString(span.Href) + `">` + text + "</a>"
+	}
+
+	b.WriteString(text)
+}
+
+// writeCaption closes a figure, with its caption if there is one
+func writeCaption(b *strings.Builder, caption string) {
+	if caption != "" {
+		b.WriteString("<figcaption>" + template.HTMLEscapeString(caption) + "</figcaption>")
+	}
+	b.WriteString("</figure>\n")
+}
+
+// first returns the first of the values that is set and not empty
+func first(values ...*string) string {
+	for _, value := range values {
+		if value != nil && strings.TrimSpace(*value) != "" {
+			return *value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,142 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/feckmore/go-lambda-dynamo/content"
+)
+
+func TestHTML(t *testing.T) {
+	site := &Site{
+		Path:         "acme",
+		Name:         aws.String("Acme"),
+		Description:  aws.String("Everything from A to Z"),
+		URL:          aws.String("https://acme.example.com/"),
+		TagManagerID: aws.String("GTM-ABC123"),
+		CardImageURL: aws.String("https://cdn.example.com/card.png"),
+	}
+	page := &Page{
+		Path:     "acme/about",
+		Name:     aws.String("About <us>"),
+		Keywords: aws.String("anvils, rockets"),
+		Blocks: []content.Block{
+			{Type: content.Heading, Text: "Who we are", Level: 1},
+			{Type: content.RichText, Spans: []content.Span{{Text: "Since 1949 & "}, {Text: "still", Marks: []string{content.Bold}, Href: "/acme/history"}}},
+			{Type: content.Image, URL: "javascript:alert(1)", Alt: "Broken"},
+		},
+	}
+
+	var b bytes.Buffer
+	if err := HTML(&b, site, page); err != nil {
+		t.Fatalf("HTML: %v", err)
+	}
+	html := b.String()
+
+	for _, want := range []string{
+		"<title>About &lt;us&gt;</title>",
+		`<meta name="description" content="Everything from A to Z">`,
+		`<meta name="keywords" content="anvils, rockets">`,
+		`<link rel="canonical" href="https://acme.example.com/about">`,
+		`<meta property="og:image" content="https://cdn.example.com/card.png">`,
+		`'dataLayer',"GTM-ABC123"`,
+		"ns.html?id=GTM-ABC123",
+		"<h1>Who we are</h1>",
+		`<p>Since 1949 &amp; <a href="/acme/history"><strong>still</strong></a></p>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("HTML: missing %s in\n%s", want, html)
+		}
+	}
+	if strings.Contains(html, "javascript:") {
+		t.Errorf("HTML: rendered an unsafe link in\n%s", html)
+	}
+}
+
+func TestURL(t *testing.T) {
+	site := &Site{Path: "acme", URL: aws.String("https://acme.example.com")}
+
+	var testCases = []struct {
+		path string
+		want string
+	}{
+		{"acme", "https://acme.example.com/"},
+		{"acme/about", "https://acme.example.com/about"},
+		{"acme/about/team", "https://acme.example.com/about/team"},
+	}
+
+	for _, tc := range testCases {
+		if got := URL(site, tc.path); got != tc.want {
+			t.Errorf("URL(%s): got %s; wanted %s", tc.path, got, tc.want)
+		}
+	}
+
+	if got := URL(&Site{Path: "acme"}, "acme/about"); got != "" {
+		t.Errorf("URL without site URL: got %s; wanted none", got)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	site := &Site{
+		Path:         "acme",
+		Name:         aws.String("Acme"),
+		Description:  aws.String("Everything from A to Z"),
+		URL:          aws.String("https://acme.example.com"),
+		CardImageURL: aws.String("https://cdn.example.com/card.png"),
+		Social:       &Social{TwitterSite: aws.String("@acme"), Title: aws.String("Acme Corporation")},
+	}
+
+	var testCases = []struct {
+		name string
+		page *Page
+		want map[string]string
+	}{
+		{"Inherits site", &Page{Path: "acme"}, map[string]string{
+			"title":          "Acme Corporation",
+			"canonical":      "https://acme.example.com/",
+			"og:title":       "Acme Corporation",
+			"og:description": "Everything from A to Z",
+			"og:image":       "https://cdn.example.com/card.png",
+			"twitter:card":   "summary_large_image",
+			"twitter:site":   "@acme",
+			"og:site_name":   "Acme",
+			"og:url":         "https://acme.example.com/",
+		}},
+		{"Page metadata", &Page{Path: "acme/about", Name: aws.String("About"), Description: aws.String("Who we are")}, map[string]string{
+			"title":          "About",
+			"og:description": "Who we are",
+			"description":    "Who we are",
+			"og:url":         "https://acme.example.com/about",
+		}},
+		{"Page social", &Page{Path: "acme/about", Name: aws.String("About"), Social: &Social{
+			Title:       aws.String("About Acme"),
+			Image:       aws.String("https://cdn.example.com/about.png"),
+			TwitterCard: aws.String("summary"),
+			Canonical:   aws.String("https://www.example.com/about-acme"),
+		}}, map[string]string{
+			"title":        "About Acme",
+			"og:image":     "https://cdn.example.com/about.png",
+			"twitter:card": "summary",
+			"canonical":    "https://www.example.com/about-acme",
+			"og:url":       "https://www.example.com/about-acme",
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			meta := Meta(site, tc.page)
+			got := map[string]string{"title": meta.Title, "canonical": meta.Canonical}
+			for _, tag := range meta.Tags {
+				got[tag.Property+tag.Name] = tag.Content
+			}
+
+			for key, want := range tc.want {
+				if got[key] != want {
+					t.Errorf("Meta: got %s %q; wanted %q", key, got[key], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package render
+
+import "html/template"
+
+// DefaultTheme is the theme sites are rendered with unless they name another
+const DefaultTheme = "default"
+
+// themes are the themes a site can be rendered with, by name
+var themes = map[string]*template.Template{
+	DefaultTheme: template.Must(template.New(DefaultTheme).Parse(head + defaultBody)),
+	"plain":      template.Must(template.New("plain").Parse(head + plainBody)),
+}
+
+// head is shared by every theme, so each gets the same metadata & tag manager snippet
+const head = `{{define "head"}}<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Meta.Title}}</title>
+{{- with .Meta.Canonical}}
+<link rel="canonical" href="{{.}}">
+{{- end}}
+{{- range .Meta.Tags}}
+{{- if .Property}}
+<meta property="{{.Property}}" content="{{.Content}}">
+{{- else}}
+<meta name="{{.Name}}" content="{{.Content}}">
+{{- end}}
+{{- end}}
+{{- with .TagManagerID}}
+<script>(function(w,d,s,l,i){w[l]=w[l]||[];w[l].push({'gtm.start':new Date().getTime(),event:'gtm.js'});var f=d.getElementsByTagName(s)[0],j=d.createElement(s),dl=l!='dataLayer'?'&l='+l:'';j.async=true;j.src='https://www.googletagmanager.com/gtm.js?id='+i+dl;f.parentNode.insertBefore(j,f);})(window,document,'script','dataLayer',{{.}});</script>
+{{- end}}
+{{end}}{{define "tagmanager"}}{{with .TagManagerID}}<noscript><iframe src="https://www.googletagmanager.com/ns.html?id={{.}}" height="0" width="0" style="display:none;visibility:hidden"></iframe></noscript>
+{{end}}{{end}}`
+
+const defaultBody = `<!DOCTYPE html>
+<html>
+<head>
+{{template "head" .}}<style>
+body{font-family:system-ui,sans-serif;line-height:1.6;margin:0 auto;max-width:44rem;padding:1rem;color:#222}
+img,iframe{max-width:100%;border:0}
+figure{margin:1.5rem 0}
+figcaption{color:#666;font-size:.9rem}
+.cta .button{display:inline-block;padding:.6rem 1.2rem;border-radius:.3rem;background:#0b5fff;color:#fff;text-decoration:none}
+</style>
+</head>
+<body>
+{{template "tagmanager" .}}<header>{{with .Site.Name}}<a href="/">{{.}}</a>{{end}}</header>
+<main>
+{{.Content}}</main>
+</body>
+</html>
+`
+
+const plainBody = `<!DOCTYPE html>
+<html>
+<head>
+{{template "head" .}}</head>
+<body>
+{{template "tagmanager" .}}{{.Content}}</body>
+</html>
+`
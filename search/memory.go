@@ -0,0 +1,64 @@
+package search
+
+import (
+	"sort"
+	"sync"
+)
+
+// Memory is an Index kept in memory, for tests and for indexing pages in a single process
+type Memory struct {
+	mu       sync.Mutex
+	postings map[string]map[string]map[string]Posting // by site, word & page id
+}
+
+// NewMemory returns an empty in-memory index
+func NewMemory() *Memory {
+	return &Memory{postings: map[string]map[string]map[string]Posting{}}
+}
+
+// Postings returns the postings of the word in the site's pages, ordered by page id
+func (m *Memory) Postings(site, term string) ([]Posting, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var postings []Posting
+	for _, posting := range m.postings[site][term] {
+		postings = append(postings, posting)
+	}
+	sort.Slice(postings, func(i, j int) bool { return postings[i].ID < postings[j].ID })
+
+	return postings, nil
+}
+
+// Put adds the postings, replacing any of the same word & page
+func (m *Memory) Put(site string, postings []Posting) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.postings[site] == nil {
+		m.postings[site] = map[string]map[string]Posting{}
+	}
+	for _, posting := range postings {
+		if m.postings[site][posting.Term] == nil {
+			m.postings[site][posting.Term] = map[string]Posting{}
+		}
+		m.postings[site][posting.Term][posting.ID] = posting
+	}
+
+	return nil
+}
+
+// Remove removes the postings of the same word & page, if there are any
+func (m *Memory) Remove(site string, postings []Posting) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, posting := range postings {
+		delete(m.postings[site][posting.Term], posting.ID)
+		if len(m.postings[site][posting.Term]) == 0 {
+			delete(m.postings[site], posting.Term)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+// Package search keeps an inverted index of the words in a site's pages, and ranks the pages
+// matching a query by relevance. The index stores postings, the pages each word appears in with
+// its weight there, behind the Index interface, so it can live in the table or in memory.
+package search
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/feckmore/go-lambda-dynamo/content"
+)
+
+// MaxQueryTerms is the most words of a query that are searched for
+const MaxQueryTerms = 10
+
+// maxTermLength is the longest word that is indexed, which keeps out things like encoded data
+const maxTermLength = 40
+
+// Weights of a word in each part of a page. A word in the name says more about the page than
+// the same word in its content.
+const (
+	NameWeight        = 4
+	KeywordsWeight    = 3
+	DescriptionWeight = 2
+	ContentWeight     = 1
+)
+
+// stopWords are too common to say anything about a page, so they are neither indexed nor searched
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "in": true, "is": true, "it": true, "of": true, "on": true,
+	"or": true, "that": true, "the": true, "this": true, "to": true, "was": true, "with": true,
+}
+
+// Document is the text of a page that is indexed. Content is the text of the page's blocks &
+// custom fields, see Text.
+type Document struct {
+	ID          string
+	Version     string
+	Name        string
+	Description string
+	Keywords    string
+	Content     string
+}
+
+// Posting records that a word appears in a page, with its weight there. A page has one posting
+// per word, whichever version of it was indexed last.
+type Posting struct {
+	Term    string
+	ID      string
+	Version string
+	Weight  float64
+}
+
+// Match is a page matching a query, with its relevance score
+type Match struct {
+	ID      string
+	Version string
+	Score   float64
+}
+
+// Index stores the postings of a site's pages, by word
+type Index interface {
+	// Postings returns the postings of the word in the site's pages
+	Postings(site, term string) ([]Posting, error)
+	// Put adds the postings, replacing any of the same word & page
+	Put(site string, postings []Posting) error
+	// Remove removes the postings of the same word & page, if there are any
+	Remove(site string, postings []Posting) error
+}
+
+// Tokenize splits the text into lowercase words, leaving out stop words and words that are too
+// short or too long to be useful
+func Tokenize(text string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		length := utf8.RuneCountInString(word)
+		if length < 2 || length > maxTermLength || stopWords[word] {
+			continue
+		}
+		terms = append(terms, word)
+	}
+
+	return terms
+}
+
+// Text returns the words of the blocks and the string values of the custom fields, to index as
+// a page's content
+func Text(blocks []content.Block, fields map[string]interface{}) string {
+	var parts []string
+	add := func(text string) {
+		if text = strings.TrimSpace(text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	for _, block := range blocks {
+		add(block.Text)
+		// spans run together, since marks can start or end inside a word
+		var spans strings.Builder
+		for _, span := range block.Spans {
+			spans.WriteString(span.Text)
+		}
+		add(spans.String())
+		add(block.Alt)
+		add(block.Caption)
+	}
+
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value, ok := fields[name].(string); ok {
+			add(value)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Terms returns the weight of every word in the document. Each part of the page adds its weight
+// for a word, dampened so a word repeated many times doesn't drown out everything else.
+func Terms(doc *Document) map[string]float64 {
+	weights := map[string]float64{}
+	if doc == nil {
+		return weights
+	}
+
+	for _, part := range []struct {
+		text   string
+		weight float64
+	}{
+		{doc.Name, NameWeight},
+		{doc.Keywords, KeywordsWeight},
+		{doc.Description, DescriptionWeight},
+		{doc.Content, ContentWeight},
+	} {
+		counts := map[string]int{}
+		for _, term := range Tokenize(part.text) {
+			counts[term]++
+		}
+		for term, count := range counts {
+			weights[term] += part.weight * (1 + math.Log(float64(count)))
+		}
+	}
+
+	return weights
+}
+
+// Update brings the index from the previous document of a page to its current one: postings are
+// put for every word of the current document, and removed for words only the previous one had.
+// Pass a nil previous document for a new page, and a nil current one for a page going away.
+func Update(index Index, site string, previous, current *Document) error {
+	site = strings.ToLower(site)
+	weights := Terms(current)
+
+	var puts []Posting
+	if current != nil {
+		for term, weight := range weights {
+			puts = append(puts, Posting{Term: term, ID: current.ID, Version: current.Version, Weight: weight})
+		}
+	}
+
+	var removes []Posting
+	if previous != nil {
+		for term := range Terms(previous) {
+			if _, ok := weights[term]; !ok {
+				removes = append(removes, Posting{Term: term, ID: previous.ID, Version: previous.Version})
+			}
+		}
+	}
+
+	sortPostings(puts)
+	sortPostings(removes)
+	if err := index.Put(site, puts); err != nil {
+		return fmt.Errorf("putting postings: %v", err)
+	}
+	if err := index.Remove(site, removes); err != nil {
+		return fmt.Errorf("removing postings: %v", err)
+	}
+
+	return nil
+}
+
+// Search returns the site's pages with every word of the query, most relevant first. A page
+// scores the weight of each word in it, scaled down the more pages have the word, since rare
+// words tell pages apart better than common ones.
+func Search(index Index, site, query string) ([]Match, error) {
+	site = strings.ToLower(site)
+	terms := unique(Tokenize(query))
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	if len(terms) > MaxQueryTerms {
+		terms = terms[:MaxQueryTerms]
+	}
+
+	matches := map[string]*Match{}
+	hits := map[string]int{}
+	for _, term := range terms {
+		postings, err := index.Postings(site, term)
+		if err != nil {
+			return nil, err
+		}
+		rarity := 1 / (1 + math.Log(float64(len(postings))))
+
+		for _, posting := range postings {
+			match := matches[posting.ID]
+			if match == nil {
+				match = &Match{ID: posting.ID, Version: posting.Version}
+				matches[posting.ID] = match
+			}
+			match.Score += posting.Weight * rarity
+			hits[posting.ID]++
+		}
+	}
+
+	var ranked []Match
+	for id, match := range matches {
+		if hits[id] == len(terms) {
+			ranked = append(ranked, *match)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+
+	return ranked, nil
+}
+
+// unique returns the terms without duplicates, in their original order
+func unique(terms []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			result = append(result, term)
+		}
+	}
+
+	return result
+}
+
+// sortPostings orders the postings by word, so indexes are written in a predictable order
+func sortPostings(postings []Posting) {
+	sort.Slice(postings, func(i, j int) bool { return postings[i].Term < postings[j].Term })
+}
@@ -0,0 +1,102 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/feckmore/go-lambda-dynamo/content"
+)
+
+func TestTokenize(t *testing.T) {
+	got := Tokenize("The Road-Runner's ROCKETS, 2x faster! a")
+	want := []string{"road", "runner", "rockets", "2x", "faster"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize: got %q; wanted %q", got, want)
+	}
+}
+
+func TestText(t *testing.T) {
+	blocks := []content.Block{
+		{Type: content.Heading, Text: "Rockets", Level: 1},
+		{Type: content.RichText, Spans: []content.Span{{Text: "Fast "}, {Text: "and loud"}}},
+		{Type: content.Image, URL: "/rocket.png", Alt: "A rocket", Caption: "Liftoff"},
+	}
+	fields := map[string]interface{}{"sku": "R-100", "price": 9.99}
+
+	got := Text(blocks, fields)
+	want := "Rockets Fast and loud A rocket Liftoff R-100"
+	if got != want {
+		t.Errorf("Text: got %q; wanted %q", got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	index := NewMemory()
+	docs := []*Document{
+		{ID: "rockets", Version: "1", Name: "Rockets", Description: "Fast rockets for every occasion", Content: "Our rockets are fast."},
+		{ID: "anvils", Version: "1", Name: "Anvils", Description: "Heavy anvils", Content: "Drop an anvil, not a rocket."},
+		{ID: "catalog", Version: "1", Name: "Catalog", Keywords: "rockets, anvils", Content: "Everything Acme sells."},
+	}
+	for _, doc := range docs {
+		if err := Update(index, "Acme", nil, doc); err != nil {
+			t.Fatalf("Update %s: %v", doc.ID, err)
+		}
+	}
+
+	var testCases = []struct {
+		query string
+		want  []string
+	}{
+		{"", nil},
+		{"the", nil},
+		{"rockets", []string{"rockets", "catalog"}},
+		{"ROCKETS anvils", []string{"catalog"}},
+		{"anvils", []string{"anvils", "catalog"}},
+		{"heavy", []string{"anvils"}},
+		{"rockets heavy", nil},
+		{"unicorns", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			matches, err := Search(index, "acme", tc.query)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if got := ids(matches); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Search: got %q; wanted %q", got, tc.want)
+			}
+		})
+	}
+
+	// the catalog stops mentioning rockets, and the anvils page goes away
+	updated := &Document{ID: "catalog", Version: "2", Name: "Catalog", Keywords: "anvils"}
+	if err := Update(index, "acme", docs[2], updated); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := Update(index, "acme", docs[1], nil); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	matches, _ := Search(index, "acme", "rockets")
+	if got, want := ids(matches), []string{"rockets"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Search after update: got %q; wanted %q", got, want)
+	}
+	matches, _ = Search(index, "acme", "anvils")
+	if len(matches) != 1 || matches[0].ID != "catalog" || matches[0].Version != "2" {
+		t.Errorf("Search after delete: got %+v; wanted catalog version 2", matches)
+	}
+	if postings, _ := index.Postings("acme", "heavy"); len(postings) != 0 {
+		t.Errorf("Postings after delete: got %+v; wanted none", postings)
+	}
+}
+
+// ids returns the page ids of the matches, in order
+func ids(matches []Match) []string {
+	var result []string
+	for _, match := range matches {
+		result = append(result, match.ID)
+	}
+
+	return result
+}
@@ -0,0 +1,130 @@
+package search
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// batchSize is the maximum number of write requests DynamoDB accepts in one BatchWriteItem call
+const batchSize = 25
+
+// maxRetries bounds how many times unprocessed writes are resubmitted before giving up
+const maxRetries = 5
+
+// Table is an Index stored in the DynamoDB table alongside the pages. All of a word's postings
+// in a site share an id, and the version is the page's id, so a word's postings are one query on
+// the id. Postings have no type or path, which keeps them out of the table's secondary indexes.
+type Table struct {
+	DB   dynamodbiface.DynamoDBAPI
+	Name string
+}
+
+// posting is how a posting is stored in the table
+type posting struct {
+	ID          string  `json:"id"`
+	Version     string  `json:"version"`
+	PageVersion string  `json:"pageVersion"`
+	Weight      float64 `json:"weight"`
+}
+
+// postingID returns the id shared by the postings of the word in the site's pages
+func postingID(site, term string) string {
+	return "search#" + site + "#" + term
+}
+
+// Postings returns the postings of the word in the site's pages
+func (t *Table) Postings(site, term string) ([]Posting, error) {
+	var postings []Posting
+
+	key := expression.Key("id").Equal(expression.Value(postingID(site, term)))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	var unmarshalErr error
+	err = t.DB.QueryPages(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(t.Name),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var items []posting
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items); unmarshalErr != nil {
+			return false
+		}
+		for _, item := range items {
+			postings = append(postings, Posting{Term: term, ID: item.Version, Version: item.PageVersion, Weight: item.Weight})
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+
+	return postings, err
+}
+
+// Put adds the postings, replacing any of the same word & page
+func (t *Table) Put(site string, postings []Posting) error {
+	var requests []*dynamodb.WriteRequest
+	for _, p := range postings {
+		item, err := dynamodbattribute.MarshalMap(posting{ID: postingID(site, p.Term), Version: p.ID, PageVersion: p.Version, Weight: p.Weight})
+		if err != nil {
+			return err
+		}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+
+	return t.write(requests)
+}
+
+// Remove removes the postings of the same word & page, if there are any
+func (t *Table) Remove(site string, postings []Posting) error {
+	var requests []*dynamodb.WriteRequest
+	for _, p := range postings {
+		requests = append(requests, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				"id":      {S: aws.String(postingID(site, p.Term))},
+				"version": {S: aws.String(p.ID)},
+			},
+		}})
+	}
+
+	return t.write(requests)
+}
+
+// write makes the requests in batches of 25, resubmitting unprocessed requests with an
+// exponential backoff
+func (t *Table) write(requests []*dynamodb.WriteRequest) error {
+	for start := 0; start < len(requests); start += batchSize {
+		end := start + batchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		pending := map[string][]*dynamodb.WriteRequest{t.Name: requests[start:end]}
+		for attempt := 0; len(pending[t.Name]) > 0; attempt++ {
+			if attempt > maxRetries {
+				return fmt.Errorf("%d postings unprocessed after retries", len(pending[t.Name]))
+			}
+			if attempt > 0 {
+				time.Sleep(time.Duration(1<<uint(attempt-1)) * 100 * time.Millisecond)
+			}
+
+			result, err := t.DB.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = result.UnprocessedItems
+		}
+	}
+
+	return nil
+}
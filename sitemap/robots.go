@@ -0,0 +1,74 @@
+package sitemap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RobotsGroup is a group of robots.txt rules applying to the user agents
+type RobotsGroup struct {
+	UserAgents []string `json:"userAgents"`
+	Allow      []string `json:"allow,omitempty"`
+	Disallow   []string `json:"disallow,omitempty"`
+	CrawlDelay int      `json:"crawlDelay,omitempty"`
+}
+
+// DefaultRobots allows every crawler everywhere, and is used for sites without their own rules
+var DefaultRobots = []RobotsGroup{{UserAgents: []string{"*"}, Allow: []string{"/"}}}
+
+// ValidateRobots returns what is wrong with the robots.txt rules, or nil when they're valid
+func ValidateRobots(groups []RobotsGroup) error {
+	for i, group := range groups {
+		if len(group.UserAgents) == 0 {
+			return fmt.Errorf("robots group %d: needs at least one user agent", i)
+		}
+		for _, agent := range group.UserAgents {
+			if strings.TrimSpace(agent) == "" || strings.ContainsAny(agent, "\r\n") {
+				return fmt.Errorf("robots group %d: user agents can't be blank or span lines", i)
+			}
+		}
+		for _, path := range append(append([]string{}, group.Allow...), group.Disallow...) {
+			if path != "" && !strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "*") {
+				return fmt.Errorf("robots group %d: path %q must start with / or *", i, path)
+			}
+			if strings.ContainsAny(path, "\r\n") {
+				return fmt.Errorf("robots group %d: paths can't span lines", i)
+			}
+		}
+		if group.CrawlDelay < 0 {
+			return fmt.Errorf("robots group %d: crawl delay can't be negative", i)
+		}
+	}
+
+	return nil
+}
+
+// WriteRobots writes a robots.txt with the groups of rules, pointing crawlers at the sitemap when
+// its URL is known. Unpublished sites should pass a group disallowing everything instead.
+func WriteRobots(w io.Writer, groups []RobotsGroup, sitemapURL string) error {
+	var b strings.Builder
+	for i, group := range groups {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		for _, agent := range group.UserAgents {
+			b.WriteString("User-agent: " + agent + "\n")
+		}
+		for _, path := range group.Allow {
+			b.WriteString("Allow: " + path + "\n")
+		}
+		for _, path := range group.Disallow {
+			b.WriteString("Disallow: " + path + "\n")
+		}
+		if group.CrawlDelay > 0 {
+			fmt.Fprintf(&b, "Crawl-delay: %d\n", group.CrawlDelay)
+		}
+	}
+	if sitemapURL != "" {
+		b.WriteString("\nSitemap: " + sitemapURL + "\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
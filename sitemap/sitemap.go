@@ -0,0 +1,105 @@
+// Package sitemap writes sitemap.xml files (https://www.sitemaps.org/protocol.html) for a site's
+// published pages, and the robots.txt that points crawlers at them.
+package sitemap
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// MaxURLs is the most URLs the protocol allows in one sitemap. Sites with more are split into
+// several sitemaps, listed by a sitemap index.
+const MaxURLs = 50000
+
+// URL is one page listed in a sitemap, or one sitemap listed in a sitemap index
+type URL struct {
+	Loc     string
+	LastMod time.Time
+}
+
+type urlset struct {
+	XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []xmlURL `xml:"url"`
+}
+
+type sitemapindex struct {
+	XMLName  xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []xmlURL `xml:"sitemap"`
+}
+
+type xmlURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Write writes a sitemap listing the URLs, in the order given
+func Write(w io.Writer, urls []URL) error {
+	set := urlset{URLs: make([]xmlURL, len(urls))}
+	for i, u := range urls {
+		set.URLs[i] = xmlURL{Loc: u.Loc, LastMod: lastMod(u.LastMod)}
+	}
+
+	return write(w, set)
+}
+
+// WriteIndex writes a sitemap index listing the sitemaps, in the order given
+func WriteIndex(w io.Writer, sitemaps []URL) error {
+	index := sitemapindex{Sitemaps: make([]xmlURL, len(sitemaps))}
+	for i, u := range sitemaps {
+		index.Sitemaps[i] = xmlURL{Loc: u.Loc, LastMod: lastMod(u.LastMod)}
+	}
+
+	return write(w, index)
+}
+
+// Split divides the URLs into chunks of at most MaxURLs, one per sitemap
+func Split(urls []URL) [][]URL {
+	var chunks [][]URL
+	for start := 0; start < len(urls); start += MaxURLs {
+		end := start + MaxURLs
+		if end > len(urls) {
+			end = len(urls)
+		}
+		chunks = append(chunks, urls[start:end])
+	}
+
+	return chunks
+}
+
+// Latest returns the most recent modification time of the URLs, for listing their sitemap in
+// an index
+func Latest(urls []URL) time.Time {
+	var latest time.Time
+	for _, u := range urls {
+		if u.LastMod.After(latest) {
+			latest = u.LastMod
+		}
+	}
+
+	return latest
+}
+
+// write writes the document as indented XML with the XML declaration
+func write(w io.Writer, document interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// lastMod formats the time as a W3C datetime, or an empty string when it isn't known
+func lastMod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,71 @@
+package sitemap
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	urls := make([]URL, MaxURLs*2+1)
+	for i := range urls {
+		urls[i] = URL{Loc: fmt.Sprintf("https://acme.example.com/%d", i)}
+	}
+
+	chunks := Split(urls)
+	if len(chunks) != 3 || len(chunks[0]) != MaxURLs || len(chunks[2]) != 1 {
+		t.Errorf("Split: got %d chunks; wanted 2 full & 1 with the last url", len(chunks))
+	}
+	if len(Split(nil)) != 0 {
+		t.Errorf("Split: got chunks for no urls")
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	lastMod := time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	var b bytes.Buffer
+	err := WriteIndex(&b, []URL{{Loc: "https://acme.example.com/sitemap.xml?page=1", LastMod: lastMod}})
+	if err != nil {
+		t.Fatalf("WriteIndex: %v", err)
+	}
+
+	for _, want := range []string{
+		`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`,
+		"<loc>https://acme.example.com/sitemap.xml?page=1</loc>",
+		"<lastmod>2019-04-01T12:00:00Z</lastmod>",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteIndex: missing %s in\n%s", want, b.String())
+		}
+	}
+}
+
+func TestWriteRobots(t *testing.T) {
+	groups := []RobotsGroup{
+		{UserAgents: []string{"*"}, Disallow: []string{"/drafts"}},
+		{UserAgents: []string{"BadBot"}, Disallow: []string{"/"}, CrawlDelay: 10},
+	}
+	if err := ValidateRobots(groups); err != nil {
+		t.Fatalf("ValidateRobots: %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := WriteRobots(&b, groups, "https://acme.example.com/sitemap.xml"); err != nil {
+		t.Fatalf("WriteRobots: %v", err)
+	}
+
+	want := "User-agent: *\nDisallow: /drafts\n\nUser-agent: BadBot\nDisallow: /\nCrawl-delay: 10\n\nSitemap: https://acme.example.com/sitemap.xml\n"
+	if b.String() != want {
+		t.Errorf("WriteRobots: got\n%s\nwanted\n%s", b.String(), want)
+	}
+
+	if err := ValidateRobots([]RobotsGroup{{UserAgents: []string{"*"}, Disallow: []string{"drafts"}}}); err == nil {
+		t.Errorf("ValidateRobots: got no error for a relative path")
+	}
+	if err := ValidateRobots([]RobotsGroup{{UserAgents: []string{"*\nDisallow: /"}}}); err == nil {
+		t.Errorf("ValidateRobots: got no error for a user agent spanning lines")
+	}
+}
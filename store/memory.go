@@ -0,0 +1,537 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Memory is an in-memory stand-in for the table, keyed on `id` & `version`, for running handlers
+// in tests. It implements the item reads & writes the handlers make, including all-or-nothing
+// transactions, but only understands conditions, key conditions & filters built from
+// attribute_exists, attribute_not_exists, =, <>, begins_with and contains joined by AND, and
+// updates that SET & REMOVE attributes or ADD & DELETE strings of string sets. Indexes are taken
+// to be named `<hash>-<range>-index`, as the table's are, so queries of one only match items with
+// its range attribute, ordered by it. Calling any other DynamoDB method panics.
+type Memory struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+// NewMemory returns an empty in-memory table
+func NewMemory() *Memory {
+	return &Memory{items: map[string]map[string]*dynamodb.AttributeValue{}}
+}
+
+// Items returns a copy of every item in the table, ordered by id & version
+func (m *Memory) Items() []map[string]*dynamodb.AttributeValue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for k := range m.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var items []map[string]*dynamodb.AttributeValue
+	for _, k := range keys {
+		items = append(items, copyItem(m.items[k]))
+	}
+
+	return items
+}
+
+// GetItem returns the item with the key, or an empty output when there is none
+func (m *Memory) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, err := key(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.GetItemOutput{Item: copyItem(m.items[k])}, nil
+}
+
+// PutItem stores the item if its condition holds
+func (m *Memory) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, err := key(input.Item)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.check(k, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	m.items[k] = copyItem(input.Item)
+
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// UpdateItem changes the item with the key if its condition holds, creating it when there is none
+func (m *Memory) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, err := key(input.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.check(k, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	item, err := m.updated(k, input.Key, input.UpdateExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	m.items[k] = item
+
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// DeleteItem removes the item with the key if its condition holds
+func (m *Memory) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, err := key(input.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.check(k, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.DeleteItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		output.Attributes = copyItem(m.items[k])
+	}
+	delete(m.items, k)
+
+	return output, nil
+}
+
+// Query returns the items matching the key condition & filter, in the order of the index's range
+// attribute, or of id & version for the table. Like DynamoDB, the limit is applied to the items
+// read before they are filtered.
+func (m *Memory) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rangeKey := indexRangeKey(aws.StringValue(input.IndexName))
+	var keys []string
+	for k, item := range m.items {
+		if rangeKey != "" && item[rangeKey] == nil {
+			continue
+		}
+		ok, err := matches(item, input.KeyConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if rangeKey != "" {
+			a, b := m.items[keys[i]][rangeKey], m.items[keys[j]][rangeKey]
+			if compare(a, b) != 0 {
+				return compare(a, b) < 0
+			}
+		}
+		return keys[i] < keys[j]
+	})
+	if input.ScanIndexForward != nil && !*input.ScanIndexForward {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	if input.Limit != nil && int64(len(keys)) > *input.Limit {
+		keys = keys[:*input.Limit]
+	}
+
+	output := &dynamodb.QueryOutput{}
+	for _, k := range keys {
+		ok, err := matches(m.items[k], input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			output.Items = append(output.Items, copyItem(m.items[k]))
+		}
+	}
+	output.Count = aws.Int64(int64(len(output.Items)))
+	output.ScannedCount = aws.Int64(int64(len(keys)))
+
+	return output, nil
+}
+
+// indexRangeKey returns the range attribute of the index, such as `path` for the
+// `type-path-index`, or "" for the table itself
+func indexRangeKey(index string) string {
+	parts := strings.Split(index, "-")
+	if len(parts) != 3 || parts[2] != "index" {
+		return ""
+	}
+
+	return parts[1]
+}
+
+// QueryPages calls fn with the results of the query, all in a single, last page
+func (m *Memory) QueryPages(input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	output, err := m.Query(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+
+	return nil
+}
+
+// TransactWriteItems checks the condition of every put, update, delete & condition check first,
+// and only makes the writes when all of them hold. Like DynamoDB, it rejects transactions touching the
+// same item twice.
+func (m *Memory) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type write struct {
+		key  string
+		item map[string]*dynamodb.AttributeValue // nil for deletes and condition checks
+		del  bool
+	}
+	type update struct {
+		key        map[string]*dynamodb.AttributeValue
+		expression *string
+		names      map[string]*string
+		values     map[string]*dynamodb.AttributeValue
+	}
+
+	var writes []write
+	updates := map[string]update{}
+	var reasons []string
+	failed := false
+	seen := map[string]bool{}
+
+	for _, ti := range input.TransactItems {
+		var w write
+		var condition *string
+		var names map[string]*string
+		var values map[string]*dynamodb.AttributeValue
+		var err error
+
+		switch {
+		case ti.Put != nil:
+			w.key, err = key(ti.Put.Item)
+			w.item = ti.Put.Item
+			condition, names, values = ti.Put.ConditionExpression, ti.Put.ExpressionAttributeNames, ti.Put.ExpressionAttributeValues
+		case ti.Update != nil:
+			w.key, err = key(ti.Update.Key)
+			updates[w.key] = update{ti.Update.Key, ti.Update.UpdateExpression, ti.Update.ExpressionAttributeNames, ti.Update.ExpressionAttributeValues}
+			condition, names, values = ti.Update.ConditionExpression, ti.Update.ExpressionAttributeNames, ti.Update.ExpressionAttributeValues
+		case ti.Delete != nil:
+			w.key, err = key(ti.Delete.Key)
+			w.del = true
+			condition, names, values = ti.Delete.ConditionExpression, ti.Delete.ExpressionAttributeNames, ti.Delete.ExpressionAttributeValues
+		case ti.ConditionCheck != nil:
+			w.key, err = key(ti.ConditionCheck.Key)
+			condition, names, values = ti.ConditionCheck.ConditionExpression, ti.ConditionCheck.ExpressionAttributeNames, ti.ConditionCheck.ExpressionAttributeValues
+		default:
+			err = awserr.New("ValidationException", "memory store only supports Put, Update, Delete and ConditionCheck in transactions", nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[w.key] {
+			return nil, awserr.New("ValidationException", "Transaction request cannot include multiple operations on one item", nil)
+		}
+		seen[w.key] = true
+
+		reason := "None"
+		if err := m.check(w.key, condition, names, values); err != nil {
+			if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+				return nil, err
+			}
+			reason = "ConditionalCheckFailed"
+			failed = true
+		}
+		reasons = append(reasons, reason)
+		writes = append(writes, w)
+	}
+
+	if failed {
+		message := fmt.Sprintf("Transaction cancelled, please refer cancellation reasons for specific reasons [%s]", strings.Join(reasons, ", "))
+		return nil, awserr.New(dynamodb.ErrCodeTransactionCanceledException, message, nil)
+	}
+
+	// updates are worked out before anything is written, so a bad one writes nothing
+	for i, w := range writes {
+		if u, ok := updates[w.key]; ok {
+			item, err := m.updated(w.key, u.key, u.expression, u.names, u.values)
+			if err != nil {
+				return nil, err
+			}
+			writes[i].item = item
+		}
+	}
+
+	for _, w := range writes {
+		switch {
+		case w.del:
+			delete(m.items, w.key)
+		case w.item != nil:
+			m.items[w.key] = copyItem(w.item)
+		}
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// check evaluates the condition against the stored item, returning a ConditionalCheckFailed
+// error when it doesn't hold
+func (m *Memory) check(k string, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) error {
+	ok, err := matches(m.items[k], condition, names, values)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+
+	return nil
+}
+
+// matches reports whether the item, nil when there's none, meets the condition. An empty
+// condition is met by every item.
+func matches(item map[string]*dynamodb.AttributeValue, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (bool, error) {
+	if aws.StringValue(condition) == "" {
+		return true, nil
+	}
+	if strings.Contains(*condition, " OR ") || strings.Contains(*condition, "NOT ") {
+		return false, awserr.New("ValidationException", "memory store does not support OR or NOT in conditions", nil)
+	}
+
+	for _, term := range strings.Split(*condition, " AND ") {
+		// `attribute_exists(id)`, the builder's `(attribute_exists (#0))`, `(#0 = :0)` and
+		// `(begins_with (#0, :0))` all become their operator & operands
+		fields := strings.Fields(strings.NewReplacer("(", " ", ")", " ", ",", " ").Replace(term))
+		if len(fields) < 2 {
+			return false, awserr.New("ValidationException", "memory store does not support condition "+term, nil)
+		}
+
+		var ok bool
+		switch {
+		case len(fields) == 2 && fields[0] == "attribute_exists":
+			ok = getPath(item, attributePath(fields[1], names)) != nil
+		case len(fields) == 2 && fields[0] == "attribute_not_exists":
+			ok = getPath(item, attributePath(fields[1], names)) == nil
+		case len(fields) == 3 && (fields[0] == "begins_with" || fields[0] == "contains"):
+			attribute, value := getPath(item, attributePath(fields[1], names)), values[fields[2]]
+			if value == nil || value.S == nil {
+				return false, awserr.New("ValidationException", "memory store only supports "+fields[0]+" of strings", nil)
+			}
+			switch {
+			case attribute == nil:
+			case fields[0] == "begins_with":
+				ok = attribute.S != nil && strings.HasPrefix(*attribute.S, *value.S)
+			case attribute.SS != nil:
+				for _, member := range attribute.SS {
+					ok = ok || *member == *value.S
+				}
+			default:
+				ok = attribute.S != nil && strings.Contains(*attribute.S, *value.S)
+			}
+		case len(fields) == 3 && (fields[1] == "=" || fields[1] == "<>"):
+			attribute, value := getPath(item, attributePath(fields[0], names)), values[fields[2]]
+			if value == nil {
+				return false, awserr.New("ValidationException", "memory store is missing the value of condition "+term, nil)
+			}
+			if fields[1] == "=" {
+				ok = attribute != nil && compare(attribute, value) == 0
+			} else {
+				ok = attribute == nil || compare(attribute, value) != 0
+			}
+		default:
+			return false, awserr.New("ValidationException", "memory store does not support condition "+term, nil)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// compare orders the values like DynamoDB does the strings or numbers of range attributes,
+// returning -1, 0 or 1. Values of other types are only told apart, by their printed form.
+func compare(a, b *dynamodb.AttributeValue) int {
+	switch {
+	case a.N != nil && b.N != nil:
+		x, _ := strconv.ParseFloat(*a.N, 64)
+		y, _ := strconv.ParseFloat(*b.N, 64)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	case a.S != nil && b.S != nil:
+		return strings.Compare(*a.S, *b.S)
+	}
+
+	return strings.Compare(a.String(), b.String())
+}
+
+// updated returns the stored item with the key, or a new one, changed by the update expression.
+// Its actions are separated by commas, and grouped under SET, REMOVE, ADD & DELETE like the
+// builder's `SET #0 = :0, #1 = :1\nREMOVE #2\n`.
+func (m *Memory) updated(k string, itemKey map[string]*dynamodb.AttributeValue, expression *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	item := copyItem(m.items[k])
+	if item == nil {
+		item = copyItem(itemKey)
+	}
+
+	clause := ""
+	var operands []string
+	apply := func() error {
+		if len(operands) == 0 {
+			return nil
+		}
+		defer func() { operands = nil }()
+
+		path := attributePath(operands[0], names)
+		switch {
+		case clause == "SET" && len(operands) == 3 && operands[1] == "=" && values[operands[2]] != nil:
+			return setPath(item, path, values[operands[2]])
+		case clause == "REMOVE" && len(operands) == 1:
+			return setPath(item, path, nil)
+		case (clause == "ADD" || clause == "DELETE") && len(operands) == 2 && values[operands[1]] != nil && values[operands[1]].SS != nil:
+			set := map[string]bool{}
+			if current := getPath(item, path); current != nil {
+				for _, s := range current.SS {
+					set[*s] = true
+				}
+			}
+			for _, s := range values[operands[1]].SS {
+				set[*s] = clause == "ADD"
+			}
+			var members []string
+			for s, in := range set {
+				if in {
+					members = append(members, s)
+				}
+			}
+			if len(members) == 0 {
+				// like DynamoDB, a set left empty is removed
+				return setPath(item, path, nil)
+			}
+			sort.Strings(members)
+			return setPath(item, path, &dynamodb.AttributeValue{SS: aws.StringSlice(members)})
+		}
+		return awserr.New("ValidationException", "memory store does not support update "+clause+" "+strings.Join(operands, " "), nil)
+	}
+
+	for _, token := range strings.Fields(strings.Replace(aws.StringValue(expression), ",", " , ", -1)) {
+		switch token {
+		case "SET", "REMOVE", "ADD", "DELETE", ",":
+			if err := apply(); err != nil {
+				return nil, err
+			}
+			if token != "," {
+				clause = token
+			}
+			continue
+		}
+		operands = append(operands, token)
+	}
+	if err := apply(); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// attributePath splits a document path like `#0.#1` into its attribute names
+func attributePath(path string, names map[string]*string) []string {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "#") {
+			parts[i] = aws.StringValue(names[part])
+		}
+	}
+
+	return parts
+}
+
+// getPath returns the value at the path in the item, or nil when there is none
+func getPath(item map[string]*dynamodb.AttributeValue, path []string) *dynamodb.AttributeValue {
+	value := item[path[0]]
+	if len(path) == 1 || value == nil {
+		return value
+	}
+
+	return getPath(value.M, path[1:])
+}
+
+// setPath sets the value at the path in the item, or removes it when the value is nil. The maps
+// along the path are copied, so items already returned to callers aren't changed.
+func setPath(item map[string]*dynamodb.AttributeValue, path []string, value *dynamodb.AttributeValue) error {
+	if len(path) == 1 {
+		if value == nil {
+			delete(item, path[0])
+		} else {
+			item[path[0]] = value
+		}
+		return nil
+	}
+
+	parent := item[path[0]]
+	if parent == nil || parent.M == nil {
+		return awserr.New("ValidationException", "The document path provided in the update expression is invalid for update", nil)
+	}
+	m := copyItem(parent.M)
+	if err := setPath(m, path[1:], value); err != nil {
+		return err
+	}
+	item[path[0]] = &dynamodb.AttributeValue{M: m}
+
+	return nil
+}
+
+// key returns the map key of the item from its `id` & `version` attributes
+func key(item map[string]*dynamodb.AttributeValue) (string, error) {
+	id, version := item["id"], item["version"]
+	if id == nil || id.S == nil || version == nil || version.S == nil {
+		return "", awserr.New("ValidationException", "The provided key element does not match the schema", nil)
+	}
+
+	return *id.S + "\x00" + *version.S, nil
+}
+
+// copyItem returns a shallow copy of the item, so callers can't change what is stored
+func copyItem(item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if item == nil {
+		return nil
+	}
+
+	c := make(map[string]*dynamodb.AttributeValue, len(item))
+	for k, v := range item {
+		c[k] = v
+	}
+
+	return c
+}
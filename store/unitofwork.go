@@ -0,0 +1,149 @@
+// Package store holds the DynamoDB helpers shared by the endpoint handlers: a unit of work for
+// writing several items in one transaction, and an in-memory table to run handlers against in tests.
+package store
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// MaxWrites is the maximum number of items DynamoDB accepts in one TransactWriteItems call
+const MaxWrites = 100
+
+// ErrConflict is returned by Commit when the transaction was cancelled, most often because the
+// condition on one of its writes failed. None of the writes were made.
+var ErrConflict = errors.New("transaction cancelled, no items were written")
+
+// ErrTooManyWrites is returned by Commit when the unit of work holds more than MaxWrites writes
+var ErrTooManyWrites = errors.New("too many writes for a single transaction")
+
+// UnitOfWork collects puts, updates and deletes of items in one table, and commits them together in a
+// single TransactWriteItems call so that either all of them happen or none do
+type UnitOfWork struct {
+	db    dynamodbiface.DynamoDBAPI
+	table string
+	items []*dynamodb.TransactWriteItem
+}
+
+// NewUnitOfWork returns an empty unit of work writing to the table
+func NewUnitOfWork(db dynamodbiface.DynamoDBAPI, table string) *UnitOfWork {
+	return &UnitOfWork{db: db, table: table}
+}
+
+// Create adds a put of a new item, which cancels the transaction if the item already exists
+func (u *UnitOfWork) Create(item interface{}) error {
+	return u.put(item, "attribute_not_exists(id)")
+}
+
+// Replace adds a put over an existing item, which cancels the transaction if the item doesn't
+// exist or is in the trash
+func (u *UnitOfWork) Replace(item interface{}) error {
+	return u.put(item, "attribute_exists(id) AND attribute_not_exists(deletedAt)")
+}
+
+// Put adds an unconditional put of the item
+func (u *UnitOfWork) Put(item interface{}) error {
+	return u.put(item, "")
+}
+
+// Update adds an update of the item with the id & version, which changes only the attributes
+// named by the expression's update, and cancels the transaction if the expression's condition
+// doesn't hold
+func (u *UnitOfWork) Update(id, version string, expr expression.Expression) {
+	u.items = append(u.items, &dynamodb.TransactWriteItem{
+		Update: &dynamodb.Update{
+			Key: map[string]*dynamodb.AttributeValue{
+				"id":      {S: aws.String(id)},
+				"version": {S: aws.String(version)},
+			},
+			UpdateExpression:          expr.Update(),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			TableName:                 aws.String(u.table),
+		},
+	})
+}
+
+// Delete adds a permanent delete of the item with the id & version, which cancels the
+// transaction if the item doesn't exist
+func (u *UnitOfWork) Delete(id, version string) {
+	u.items = append(u.items, &dynamodb.TransactWriteItem{
+		Delete: &dynamodb.Delete{
+			Key: map[string]*dynamodb.AttributeValue{
+				"id":      {S: aws.String(id)},
+				"version": {S: aws.String(version)},
+			},
+			ConditionExpression: aws.String("attribute_exists(id)"),
+			TableName:           aws.String(u.table),
+		},
+	})
+}
+
+// Remove adds an unconditional delete of the item with the id & version, which succeeds whether
+// or not the item exists
+func (u *UnitOfWork) Remove(id, version string) {
+	u.items = append(u.items, &dynamodb.TransactWriteItem{
+		Delete: &dynamodb.Delete{
+			Key: map[string]*dynamodb.AttributeValue{
+				"id":      {S: aws.String(id)},
+				"version": {S: aws.String(version)},
+			},
+			TableName: aws.String(u.table),
+		},
+	})
+}
+
+// Merge adds every write of the other unit of work to this one, for grouping the writes of
+// several changes into one transaction
+func (u *UnitOfWork) Merge(other *UnitOfWork) {
+	u.items = append(u.items, other.items...)
+}
+
+// Len returns the number of writes added so far
+func (u *UnitOfWork) Len() int {
+	return len(u.items)
+}
+
+// Commit makes every write added so far in a single transaction. It returns ErrConflict when the
+// transaction was cancelled, in which case nothing was written.
+func (u *UnitOfWork) Commit() error {
+	if len(u.items) == 0 {
+		return nil
+	}
+	if len(u.items) > MaxWrites {
+		return ErrTooManyWrites
+	}
+
+	_, err := u.db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: u.items})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeTransactionCanceledException {
+		return ErrConflict
+	}
+
+	return err
+}
+
+// put marshals the item and adds a put of it guarded by the condition
+func (u *UnitOfWork) put(item interface{}, condition string) error {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+
+	put := &dynamodb.Put{
+		Item:      av,
+		TableName: aws.String(u.table),
+	}
+	if condition != "" {
+		put.ConditionExpression = aws.String(condition)
+	}
+	u.items = append(u.items, &dynamodb.TransactWriteItem{Put: put})
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+type item struct {
+	ID        string     `json:"id"`
+	Version   string     `json:"version"`
+	Path      string     `json:"path"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+func TestUnitOfWork(t *testing.T) {
+	deletedAt := time.Now()
+
+	var testCases = []struct {
+		name      string
+		existing  []item
+		work      func(u *UnitOfWork)
+		wantErr   error
+		wantPaths []string
+	}{
+		{"Nothing", nil, func(u *UnitOfWork) {}, nil, nil},
+		{"Create two", nil, func(u *UnitOfWork) {
+			u.Create(item{ID: "site", Version: "1", Path: "acme"})
+			u.Create(item{ID: "page", Version: "1", Path: "acme/home"})
+		}, nil, []string{"acme/home", "acme"}},
+		{"Create existing", []item{{ID: "site", Version: "1", Path: "acme"}}, func(u *UnitOfWork) {
+			u.Create(item{ID: "page", Version: "1", Path: "acme/home"})
+			u.Create(item{ID: "site", Version: "1", Path: "other"})
+		}, ErrConflict, []string{"acme"}},
+		{"Replace existing", []item{{ID: "page", Version: "1", Path: "acme/old"}}, func(u *UnitOfWork) {
+			u.Replace(item{ID: "page", Version: "1", Path: "acme/new"})
+			u.Create(item{ID: "redirect", Version: "1", Path: "acme/old"})
+		}, nil, []string{"acme/new", "acme/old"}},
+		{"Replace missing", nil, func(u *UnitOfWork) {
+			u.Create(item{ID: "redirect", Version: "1", Path: "acme/old"})
+			u.Replace(item{ID: "page", Version: "1", Path: "acme/new"})
+		}, ErrConflict, nil},
+		{"Replace deleted", []item{{ID: "page", Version: "1", Path: "acme/old", DeletedAt: &deletedAt}}, func(u *UnitOfWork) {
+			u.Replace(item{ID: "page", Version: "1", Path: "acme/new"})
+		}, ErrConflict, []string{"acme/old"}},
+		{"Delete existing", []item{{ID: "site", Version: "1", Path: "acme"}, {ID: "page", Version: "1", Path: "acme/home"}}, func(u *UnitOfWork) {
+			u.Delete("site", "1")
+			u.Delete("page", "1")
+		}, nil, nil},
+		{"Delete missing", []item{{ID: "site", Version: "1", Path: "acme"}}, func(u *UnitOfWork) {
+			u.Delete("site", "1")
+			u.Delete("page", "1")
+		}, ErrConflict, []string{"acme"}},
+		{"Remove missing", []item{{ID: "site", Version: "1", Path: "acme"}}, func(u *UnitOfWork) {
+			u.Remove("site", "1")
+			u.Remove("page", "1")
+		}, nil, nil},
+		{"Update existing", []item{{ID: "page", Version: "1", Path: "acme/old"}}, func(u *UnitOfWork) {
+			u.Update("page", "1", update(expression.Set(expression.Name("path"), expression.Value("acme/new")), "id"))
+		}, nil, []string{"acme/new"}},
+		{"Update missing", []item{{ID: "page", Version: "1", Path: "acme/old"}}, func(u *UnitOfWork) {
+			u.Update("page", "1", update(expression.Set(expression.Name("path"), expression.Value("acme/new")), "id"))
+			u.Update("page", "2", update(expression.Set(expression.Name("path"), expression.Value("acme/new")), "id"))
+		}, ErrConflict, []string{"acme/old"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := NewMemory()
+			setup := NewUnitOfWork(db, "test")
+			for _, existing := range tc.existing {
+				setup.Put(existing)
+			}
+			if err := setup.Commit(); err != nil {
+				t.Fatalf("setup: %v", err)
+			}
+
+			u := NewUnitOfWork(db, "test")
+			tc.work(u)
+			err := u.Commit()
+
+			if err != tc.wantErr {
+				t.Errorf("got error %v; wanted %v", err, tc.wantErr)
+			}
+
+			var gotPaths []string
+			for _, av := range db.Items() {
+				gotPaths = append(gotPaths, aws.StringValue(av["path"].S))
+			}
+			if len(gotPaths) != len(tc.wantPaths) {
+				t.Fatalf("got paths %v; wanted %v", gotPaths, tc.wantPaths)
+			}
+			for i := range gotPaths {
+				if gotPaths[i] != tc.wantPaths[i] {
+					t.Errorf("got paths %v; wanted %v", gotPaths, tc.wantPaths)
+				}
+			}
+		})
+	}
+}
+
+// update returns the expression of the update, on the condition that the attribute exists
+func update(u expression.UpdateBuilder, exists string) expression.Expression {
+	expr, err := expression.NewBuilder().WithUpdate(u).WithCondition(expression.AttributeExists(expression.Name(exists))).Build()
+	if err != nil {
+		panic(err)
+	}
+	return expr
+}
+
+func TestMemoryUpdateItem(t *testing.T) {
+	db := NewMemory()
+	db.PutItem(&dynamodb.PutItemInput{Item: map[string]*dynamodb.AttributeValue{
+		"id":      {S: aws.String("key")},
+		"version": {S: aws.String("apikey")},
+		"key":     {M: map[string]*dynamodb.AttributeValue{"sites": {SS: aws.StringSlice([]string{"acme", "globex"})}}},
+		"expires": {N: aws.String("1")},
+	}})
+	stored := db.Items()[0]
+
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		Key:                 map[string]*dynamodb.AttributeValue{"id": {S: aws.String("key")}, "version": {S: aws.String("apikey")}},
+		UpdateExpression:    aws.String("DELETE #key.#sites :sites REMOVE #expires SET #deletedAt = :now"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key": aws.String("key"), "#sites": aws.String("sites"), "#expires": aws.String("expires"), "#deletedAt": aws.String("deletedAt"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sites": {SS: aws.StringSlice([]string{"acme"})},
+			":now":   {S: aws.String("now")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := db.Items()[0]
+	if sites := aws.StringValueSlice(got["key"].M["sites"].SS); len(sites) != 1 || sites[0] != "globex" {
+		t.Errorf("got sites %v; wanted globex", sites)
+	}
+	if got["expires"] != nil || aws.StringValue(got["deletedAt"].S) != "now" {
+		t.Errorf("got item %v; wanted expires removed & deletedAt set", got)
+	}
+	if len(stored["key"].M["sites"].SS) != 2 {
+		t.Errorf("changed the item already returned to %v", stored)
+	}
+}
+
+func TestUnitOfWorkSameItemTwice(t *testing.T) {
+	db := NewMemory()
+	u := NewUnitOfWork(db, "test")
+	u.Put(item{ID: "page", Version: "1", Path: "acme/a"})
+	u.Put(item{ID: "page", Version: "1", Path: "acme/b"})
+
+	// rejected outright, like DynamoDB does, rather than cancelled
+	if err := u.Commit(); err == nil || err == ErrConflict {
+		t.Errorf("got error %v; wanted a validation error", err)
+	}
+	if items := db.Items(); len(items) != 0 {
+		t.Errorf("got %d items; wanted none", len(items))
+	}
+}
+
+func TestUnitOfWorkTooManyWrites(t *testing.T) {
+	db := NewMemory()
+	u := NewUnitOfWork(db, "test")
+	for i := 0; i <= MaxWrites; i++ {
+		u.Delete("page", string(rune('a'+i)))
+	}
+
+	if err := u.Commit(); err != ErrTooManyWrites {
+		t.Errorf("got error %v; wanted %v", err, ErrTooManyWrites)
+	}
+}
+
+func TestMemoryQuery(t *testing.T) {
+	db := NewMemory()
+	u := NewUnitOfWork(db, "test")
+	u.Put(item{ID: "site", Version: "1", Path: "acme"})
+	u.Put(item{ID: "site", Version: "2", Path: "acme"})
+	u.Put(item{ID: "other", Version: "1", Path: "globex"})
+	if err := u.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	key := expression.Key("path").Equal(expression.Value("acme"))
+	expr, _ := expression.NewBuilder().WithKeyCondition(key).Build()
+	result, err := db.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("path-version-index"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.Int64Value(result.Count) != 2 || *result.Items[0]["version"].S != "1" || *result.Items[1]["version"].S != "2" {
+		t.Errorf("got items %v; wanted both versions of the site", result.Items)
+	}
+}
+
+func TestMemoryQueryFilter(t *testing.T) {
+	deletedAt := time.Now()
+	db := NewMemory()
+	u := NewUnitOfWork(db, "test")
+	u.Put(item{ID: "page", Version: "1", Path: "acme/a"})
+	u.Put(item{ID: "page", Version: "2", Path: "acme/b"})
+	u.Put(item{ID: "page", Version: "3", Path: "acme/c", DeletedAt: &deletedAt})
+	u.Put(item{ID: "page", Version: "4", Path: "globex/a"})
+	if err := u.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	key := expression.Key("id").Equal(expression.Value("page")).And(expression.Key("path").BeginsWith("acme/"))
+	filter := expression.AttributeNotExists(expression.Name("deletedAt")).And(expression.Name("version").NotEqual(expression.Value("1")))
+	expr, _ := expression.NewBuilder().WithKeyCondition(key).WithFilter(filter).Build()
+	input := &dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("id-path-index"),
+		ScanIndexForward:          aws.Bool(false),
+	}
+	result, err := db.Query(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.Int64Value(result.Count) != 1 || *result.Items[0]["path"].S != "acme/b" {
+		t.Errorf("got items %v; wanted acme/b alone", result.Items)
+	}
+
+	// the limit applies before the filter, so the last page in the trash leaves nothing
+	input.Limit = aws.Int64(1)
+	result, err = db.Query(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.Int64Value(result.Count) != 0 || aws.Int64Value(result.ScannedCount) != 1 {
+		t.Errorf("got %d of %d items; wanted none of 1", aws.Int64Value(result.Count), aws.Int64Value(result.ScannedCount))
+	}
+}
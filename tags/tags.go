@@ -0,0 +1,127 @@
+// Package tags normalizes the tags of sites & pages, and keeps the index items that let a site's
+// pages be listed by tag with a single query instead of a scan of the table.
+package tags
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/feckmore/go-lambda-dynamo/store"
+)
+
+// MaxTags is the most tags a site or page can have
+const MaxTags = 20
+
+// MaxLength is the most characters a tag can have
+const MaxLength = 50
+
+// Item is the index item recording that a page has a tag. All the pages with the same tag in a
+// site share an id, and the version is the page's id, so listing a tag is one query on the id.
+// The path groups a site's tags together in type-path-index for listing them.
+type Item struct {
+	ID          string    `json:"id"`
+	Version     string    `json:"version"`
+	Type        string    `json:"type"`
+	Path        string    `json:"path"`
+	Tag         string    `json:"tag"`
+	PageID      string    `json:"pageId"`
+	PageVersion string    `json:"pageVersion"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Normalize returns the tags lowercased, trimmed, with runs of whitespace collapsed to a single
+// space, without duplicates & sorted. When tags is nil they are taken from the comma separated
+// keywords instead, which is how sites & pages written before tags existed describe themselves.
+// It returns nil when there are no tags, so they are left out of the stored item.
+func Normalize(tags []string, keywords *string) ([]string, error) {
+	if tags == nil && keywords != nil {
+		tags = strings.Split(*keywords, ",")
+	}
+
+	seen := map[string]bool{}
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if strings.ContainsAny(tag, "#/,") {
+			return nil, fmt.Errorf("tag %q can't contain #, / or commas", tag)
+		}
+		if utf8.RuneCountInString(tag) > MaxLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, MaxLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > MaxTags {
+		return nil, fmt.Errorf("more than %d tags", MaxTags)
+	}
+	sort.Strings(normalized)
+
+	return normalized, nil
+}
+
+// Keywords returns the tags joined into the keywords string that is still stored alongside them,
+// or nil when there are none
+func Keywords(tags []string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+	keywords := strings.Join(tags, ", ")
+
+	return &keywords
+}
+
+// Contains reports whether the tag is one of the tags
+func Contains(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ID returns the id shared by the index items of the site's pages with the tag
+func ID(sitePath, tag string) string {
+	return "tag#" + Prefix(sitePath) + tag
+}
+
+// Prefix returns the path prefix of the site's index items in type-path-index
+func Prefix(sitePath string) string {
+	return strings.ToLower(sitePath) + "#"
+}
+
+// Index adds the writes that bring the page's index items from its previous tags to its current
+// ones to the unit of work: a put for every current tag, so each item has the page's latest
+// version, and a delete for every tag the page no longer has. Pass nil previous tags for a new
+// page, and nil current tags for a page going in the trash.
+func Index(work *store.UnitOfWork, sitePath, pageID, pageVersion string, updatedAt time.Time, previous, current []string) error {
+	for _, tag := range current {
+		item := Item{
+			ID:          ID(sitePath, tag),
+			Version:     pageID,
+			Type:        "tag",
+			Path:        Prefix(sitePath) + tag,
+			Tag:         tag,
+			PageID:      pageID,
+			PageVersion: pageVersion,
+			UpdatedAt:   updatedAt,
+		}
+		if err := work.Put(item); err != nil {
+			return err
+		}
+	}
+	for _, tag := range previous {
+		if !Contains(current, tag) {
+			work.Remove(ID(sitePath, tag), pageID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package tags
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/feckmore/go-lambda-dynamo/store"
+)
+
+func TestNormalize(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		tags     []string
+		keywords *string
+		want     []string
+		wantErr  bool
+	}{
+		{"Nothing", nil, nil, nil, false},
+		{"Tags", []string{" Rockets ", "anvils", "ROCKETS", "Road  Runner", ""}, nil, []string{"anvils", "road runner", "rockets"}, false},
+		{"Keywords", nil, aws.String("Rockets, anvils,,  road runner "), []string{"anvils", "road runner", "rockets"}, false},
+		{"Tags over keywords", []string{"rockets"}, aws.String("anvils"), []string{"rockets"}, false},
+		{"Empty tags clear keywords", []string{}, aws.String("anvils"), nil, false},
+		{"Empty keywords", nil, aws.String(" , "), nil, false},
+		{"Slash", []string{"acme/rockets"}, nil, nil, true},
+		{"Hash", []string{"#rockets"}, nil, nil, true},
+		{"Too long", []string{strings.Repeat("a", MaxLength+1)}, nil, nil, true},
+		{"Too many", strings.Split("abcdefghijklmnopqrstuvwxyz"[:MaxTags+1], ""), nil, nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Normalize(tc.tags, tc.keywords)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Normalize: got error %v; wanted error %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Normalize: got %q; wanted %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIndex(t *testing.T) {
+	db := store.NewMemory()
+	updatedAt := time.Date(2019, 4, 2, 9, 30, 0, 0, time.UTC)
+
+	work := store.NewUnitOfWork(db, "test")
+	if err := Index(work, "Acme", "page", "1", updatedAt, nil, []string{"anvils", "rockets"}); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if err := work.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// the page loses anvils, gains road runner & keeps rockets with its new version
+	work = store.NewUnitOfWork(db, "test")
+	if err := Index(work, "Acme", "page", "2", updatedAt, []string{"anvils", "rockets"}, []string{"road runner", "rockets"}); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if err := work.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	var items []Item
+	if err := dynamodbattribute.UnmarshalListOfMaps(db.Items(), &items); err != nil {
+		t.Fatalf("UnmarshalListOfMaps: %v", err)
+	}
+	want := []Item{
+		{ID: "tag#acme#road runner", Version: "page", Type: "tag", Path: "acme#road runner", Tag: "road runner", PageID: "page", PageVersion: "2", UpdatedAt: updatedAt},
+		{ID: "tag#acme#rockets", Version: "page", Type: "tag", Path: "acme#rockets", Tag: "rockets", PageID: "page", PageVersion: "2", UpdatedAt: updatedAt},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("Index: got items %+v; wanted %+v", items, want)
+	}
+}
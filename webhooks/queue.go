@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Message is a payload waiting to be delivered to a webhook. It names the webhook rather than
+// carrying it, so secrets stay out of the queue and webhooks deleted in the meantime aren't sent.
+type Message struct {
+	Site      string  `json:"site"`
+	WebhookID string  `json:"webhookId"`
+	Payload   Payload `json:"payload"`
+}
+
+// Queue holds messages until they're delivered, which keeps slow & failing webhooks from
+// holding up the table's stream
+type Queue interface {
+	Enqueue(ctx context.Context, message Message) error
+}
+
+// SQSQueue is a Queue in an SQS FIFO queue. Each webhook's messages are delivered in order, and
+// a payload queued again for the same webhook, as when stream records are retried, is dropped by
+// the queue's deduplication.
+type SQSQueue struct {
+	SQS sqsiface.SQSAPI
+	URL string
+}
+
+// Enqueue sends the message to the queue
+func (q *SQSQueue) Enqueue(ctx context.Context, message Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.SQS.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		MessageBody:            aws.String(string(body)),
+		MessageDeduplicationId: aws.String(message.Payload.ID + "#" + message.WebhookID),
+		MessageGroupId:         aws.String(message.WebhookID),
+		QueueUrl:               aws.String(q.URL),
+	})
+
+	return err
+}
+
+// Worker delivers queued messages to their webhooks
+type Worker struct {
+	Store  Store
+	Sender *Sender
+}
+
+// Deliver sends the message's payload to its webhook, logging the delivery, and drops messages
+// of webhooks that are gone. Only failing to find the webhook is returned as an error, for the
+// message to be retried: deliveries that fail after their attempts are left in the log instead,
+// and failing to log them is logged.
+func (w *Worker) Deliver(ctx context.Context, message Message) error {
+	hook, err := w.Store.Webhook(message.Site, message.WebhookID)
+	if err != nil || hook == nil {
+		return err
+	}
+
+	delivery, err := w.Sender.Deliver(ctx, hook, message.Payload)
+	if err != nil {
+		log.Println("Error sending payload", message.Payload.ID, "to webhook", hook.ID, err)
+		return nil
+	}
+	if err := w.Store.SaveDelivery(delivery); err != nil {
+		log.Println("Error logging delivery", delivery.ID, "to webhook", hook.ID, err)
+	}
+
+	return nil
+}
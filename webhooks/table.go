@@ -0,0 +1,167 @@
+package webhooks
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// sortableTime formats times so they sort in order as strings, unlike RFC 3339 with nanoseconds
+// which drops trailing zeros
+const sortableTime = "2006-01-02T15:04:05.000000000Z"
+
+// Table is a Store in the DynamoDB table alongside the sites. Webhooks are items of type
+// "webhook" whose path is their site's. All of a webhook's deliveries share an id, and their
+// version starts with when they were made, so the log is one query on the id, newest first.
+// Deliveries have no type or path, which keeps them out of the table's secondary indexes, and
+// are purged by the table's TTL.
+type Table struct {
+	DB   dynamodbiface.DynamoDBAPI
+	Name string
+}
+
+// delivery is how a delivery is stored in the table
+type delivery struct {
+	ID        string   `json:"id"`
+	Version   string   `json:"version"`
+	Delivery  Delivery `json:"delivery"`
+	ExpiresAt int64    `json:"expiresAt,omitempty"`
+}
+
+// DeliveryID returns the id shared by the webhook's deliveries
+func DeliveryID(webhookID string) string {
+	return "delivery#" + webhookID
+}
+
+// live is the filter of webhooks that aren't in the trash, where they go along with their site
+func live() expression.ConditionBuilder {
+	return expression.AttributeNotExists(expression.Name("deletedAt"))
+}
+
+// Webhooks returns the site's webhooks, leaving out those in the trash with their site
+func (t *Table) Webhooks(site string) ([]Webhook, error) {
+	var hooks []Webhook
+
+	key := expression.Key("type").Equal(expression.Value("webhook")).And(expression.Key("path").Equal(expression.Value(strings.ToLower(site))))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(live()).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	var unmarshalErr error
+	err = t.DB.QueryPages(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("type-path-index"),
+		TableName:                 aws.String(t.Name),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		var items []Webhook
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items); unmarshalErr != nil {
+			return false
+		}
+		hooks = append(hooks, items...)
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+
+	return hooks, err
+}
+
+// Webhook returns the webhook with the id when it is one of the site's and not in the trash, or
+// nil otherwise
+func (t *Table) Webhook(site, id string) (*Webhook, error) {
+	var hooks []Webhook
+
+	key := expression.Key("id").Equal(expression.Value(id))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).WithFilter(live()).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := t.DB.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		TableName:                 aws.String(t.Name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hook := range hooks {
+		if hook.Type == "webhook" && hook.Path == strings.ToLower(site) {
+			return &hook, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// SaveDelivery adds the delivery to its webhook's log
+func (t *Table) SaveDelivery(d *Delivery) error {
+	item, err := dynamodbattribute.MarshalMap(delivery{
+		ID:        DeliveryID(d.WebhookID),
+		Version:   d.CreatedAt.UTC().Format(sortableTime) + "#" + d.ID,
+		Delivery:  *d,
+		ExpiresAt: d.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = t.DB.PutItem(&dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(t.Name),
+	})
+
+	return err
+}
+
+// Deliveries returns up to limit of the webhook's latest deliveries, newest first
+func (t *Table) Deliveries(webhookID string, limit int64) ([]Delivery, error) {
+	var items []delivery
+
+	key := expression.Key("id").Equal(expression.Value(DeliveryID(webhookID)))
+	expr, err := expression.NewBuilder().WithKeyCondition(key).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := t.DB.Query(&dynamodb.QueryInput{
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		Limit:                     aws.Int64(limit),
+		ScanIndexForward:          aws.Bool(false),
+		TableName:                 aws.String(t.Name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]Delivery, len(items))
+	for i, item := range items {
+		deliveries[i] = item.Delivery
+	}
+
+	return deliveries, nil
+}
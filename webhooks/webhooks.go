@@ -0,0 +1,419 @@
+// Package webhooks delivers changes to sites & pages to the URLs subscribed to them. Payloads are
+// JSON signed with the webhook's secret, failed deliveries are retried with an exponential
+// backoff, and every delivery is logged to the table along with its attempts.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/feckmore/go-lambda-dynamo/changes"
+)
+
+// Headers sent with every delivery
+const (
+	IDHeader        = "X-Webhook-Id"
+	EventHeader     = "X-Webhook-Event"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Ping is the event of test deliveries
+const Ping = "ping"
+
+// Events are the events webhooks can subscribe to, named for the type of item and kind of change
+var Events = []string{
+	"site.created", "site.updated", "site.published", "site.deleted",
+	"page.created", "page.updated", "page.deleted",
+}
+
+// logRetention is how long deliveries are kept in the log before the table's TTL purges them
+const logRetention = 30 * 24 * time.Hour
+
+// internal are the networks inside which webhooks aren't sent, besides loopback, link-local &
+// multicast addresses: private networks, shared address space & "this" network
+var internal = networks("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+// lookupIP resolves a host's addresses, replaced in tests
+var lookupIP = net.LookupIP
+
+// Webhook is a subscription of a URL to changes in a site. Path is the path of the site, and a
+// webhook without events is sent all of them. The secret is only returned when it's created.
+type Webhook struct {
+	ID        string    `json:"id"`
+	Version   string    `json:"version"`
+	Path      string    `json:"path"`
+	Type      string    `json:"type"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events,omitempty" dynamodbav:"events,stringset,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// Payload is the body of a delivery. Change & Object are missing from pings; Object is the site or
+// page after the change, or before it for deletes.
+type Payload struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	Site      string          `json:"site"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Change    *changes.Change `json:"change,omitempty"`
+	Object    interface{}     `json:"object,omitempty"`
+}
+
+// Attempt is one request of a delivery. Status is missing when there was no response.
+type Attempt struct {
+	At       time.Time `json:"at"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration int64     `json:"durationMs"`
+}
+
+// Delivery is the log of sending a payload to a webhook
+type Delivery struct {
+	ID        string    `json:"id"`
+	WebhookID string    `json:"webhookId"`
+	Event     string    `json:"event"`
+	URL       string    `json:"url"`
+	Delivered bool      `json:"delivered"`
+	Attempts  []Attempt `json:"attempts"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt int64     `json:"expiresAt,omitempty"`
+}
+
+// Store holds the webhooks & their deliveries
+type Store interface {
+	Webhooks(site string) ([]Webhook, error)
+	Webhook(site, id string) (*Webhook, error)
+	SaveDelivery(delivery *Delivery) error
+}
+
+// Event returns the event of the change, e.g. "page.updated"
+func Event(change changes.Change) string {
+	return change.Type + "." + string(change.Kind)
+}
+
+// Subscribes reports whether the webhook is sent the event. Every webhook is sent pings.
+func (w *Webhook) Subscribes(event string) bool {
+	if len(w.Events) == 0 || event == Ping {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate returns what is wrong with the webhook's URL & events, or an empty string when they
+// are valid
+func Validate(w *Webhook) string {
+	u, err := url.Parse(w.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "Webhook url must be an absolute https URL"
+	}
+	if err := checkHost(u.Hostname()); err != nil {
+		return "Webhook url must be of a public host: " + err.Error()
+	}
+	for _, event := range w.Events {
+		known := false
+		for _, e := range Events {
+			known = known || e == event
+		}
+		if !known {
+			return "Webhook events must be some of " + strings.Join(Events, ", ")
+		}
+	}
+
+	return ""
+}
+
+// checkHost returns an error when the host is, or resolves to, an address webhooks aren't sent to
+func checkHost(host string) error {
+	addresses := []net.IP{net.ParseIP(host)}
+	if addresses[0] == nil {
+		var err error
+		if addresses, err = lookupIP(host); err != nil {
+			return fmt.Errorf("%s can't be resolved", host)
+		}
+	}
+	for _, ip := range addresses {
+		if !public(ip) {
+			return fmt.Errorf("%s is a private, loopback or link-local address", ip)
+		}
+	}
+
+	return nil
+}
+
+// public reports whether webhooks may be sent to the address, which they aren't for addresses
+// inside the network the deliveries are sent from, such as the instance metadata service's
+func public(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range internal {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// networks parses the CIDR blocks
+func networks(blocks ...string) []*net.IPNet {
+	var parsed []*net.IPNet
+	for _, block := range blocks {
+		_, network, err := net.ParseCIDR(block)
+		if err != nil {
+			panic(err)
+		}
+		parsed = append(parsed, network)
+	}
+
+	return parsed
+}
+
+// NewSecret returns a random secret for signing a webhook's payloads
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature of the body sent at the Unix timestamp: "sha256=" followed by the
+// hex HMAC-SHA256 of the timestamp, a period & the body. Signing the timestamp lets receivers
+// reject old payloads being replayed.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the signature is the body's, in constant time
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Sender sends payloads to webhooks, making up to Attempts requests and waiting Backoff before
+// the first retry, doubling it before each one after that
+type Sender struct {
+	Client   *http.Client
+	Attempts int
+	Backoff  time.Duration
+
+	// sleep waits between attempts, returning early when the context is done
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewClient returns a client for sending deliveries, timing out after the duration, which refuses
+// to connect to private, loopback & link-local addresses. It checks the address it connects to,
+// redirects included, rather than the one the webhook's host resolved to when it was saved, so
+// the host can't be pointed inside the network afterwards.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !public(ip) {
+				return fmt.Errorf("webhooks aren't sent to %s, a private, loopback or link-local address", host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
+
+// NewSender returns a sender making 4 attempts over 7 seconds, each timing out after 10 seconds
+func NewSender() *Sender {
+	return &Sender{
+		Client:   NewClient(10 * time.Second),
+		Attempts: 4,
+		Backoff:  time.Second,
+	}
+}
+
+// Deliver sends the payload to the webhook until it's accepted with a 2xx response, or rejected
+// with a 4xx response other than 408 & 429, or the attempts run out. The returned delivery logs
+// every attempt; the error is only for payloads that can't be sent at all.
+func (s *Sender) Deliver(ctx context.Context, hook *Webhook, payload Payload) (*Delivery, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &Delivery{
+		ID:        payload.ID,
+		WebhookID: hook.ID,
+		Event:     payload.Event,
+		URL:       hook.URL,
+		CreatedAt: time.Now(),
+	}
+	delivery.ExpiresAt = delivery.CreatedAt.Add(logRetention).Unix()
+
+	sleep := s.sleep
+	if sleep == nil {
+		sleep = wait
+	}
+
+	for i := 0; i < s.Attempts; i++ {
+		if i > 0 {
+			if err := sleep(ctx, s.Backoff<<uint(i-1)); err != nil {
+				break
+			}
+		}
+
+		attempt, retry := s.send(ctx, hook, payload, body)
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		if attempt.Error == "" {
+			delivery.Delivered = true
+		}
+		if !retry {
+			break
+		}
+	}
+
+	return delivery, nil
+}
+
+// send makes one attempt at delivering the body, and reports whether it's worth retrying
+func (s *Sender) send(ctx context.Context, hook *Webhook, payload Payload, body []byte) (attempt Attempt, retry bool) {
+	attempt.At = time.Now()
+	defer func() { attempt.Duration = int64(time.Since(attempt.At) / time.Millisecond) }()
+
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	timestamp := attempt.At.Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "go-lambda-dynamo-webhooks")
+	request.Header.Set(IDHeader, payload.ID)
+	request.Header.Set(EventHeader, payload.Event)
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, body))
+
+	response, err := s.Client.Do(request.WithContext(ctx))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, ctx.Err() == nil
+	}
+	defer response.Body.Close()
+	// drain a little of the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 4096))
+
+	attempt.Status = response.StatusCode
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return attempt, false
+	case response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		attempt.Error = response.Status
+		return attempt, true
+	default:
+		attempt.Error = response.Status
+		return attempt, false
+	}
+}
+
+// wait sleeps for the duration, or until the context is done
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Notifier is the changes subscriber that queues changes for delivery to the webhooks of their
+// site
+type Notifier struct {
+	Store Store
+	Queue Queue
+}
+
+// Notify queues the change for each of the site's webhooks subscribed to it. The payload's id is
+// the stream record's, so a change queued again when its record is retried has the same id, and
+// receivers can tell they've already had it.
+func (n *Notifier) Notify(ctx context.Context, change changes.Change) error {
+	hooks, err := n.Store.Webhooks(change.Site)
+	if err != nil {
+		return err
+	}
+
+	event := Event(change)
+	for i := range hooks {
+		hook := &hooks[i]
+		if !hook.Subscribes(event) {
+			continue
+		}
+
+		payload := Payload{
+			ID:        change.EventID,
+			Event:     event,
+			Site:      change.Site,
+			CreatedAt: change.At,
+			Change:    &change,
+			Object:    object(change),
+		}
+		if err := n.Queue.Enqueue(ctx, Message{Site: change.Site, WebhookID: hook.ID, Payload: payload}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// object returns the site or page after the change, or before it when it was deleted
+func object(change changes.Change) interface{} {
+	switch {
+	case change.NewPage != nil:
+		return change.NewPage
+	case change.OldPage != nil:
+		return change.OldPage
+	case change.NewSite != nil:
+		return change.NewSite
+	case change.OldSite != nil:
+		return change.OldSite
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/feckmore/go-lambda-dynamo/changes"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"ping"}`)
+	signature := Sign("secret", 1554197400, body)
+
+	if len(signature) != len("sha256=")+64 || signature[:7] != "sha256=" {
+		t.Fatalf("Sign: got %q; wanted sha256= & 64 hex digits", signature)
+	}
+	if !Verify("secret", 1554197400, body, signature) {
+		t.Errorf("Verify: rejected its own signature")
+	}
+	if Verify("secret", 1554197401, body, signature) {
+		t.Errorf("Verify: accepted the signature at another time")
+	}
+	if Verify("other", 1554197400, body, signature) {
+		t.Errorf("Verify: accepted the signature with another secret")
+	}
+	if Verify("secret", 1554197400, []byte(`{"event":"page.deleted"}`), signature) {
+		t.Errorf("Verify: accepted the signature of another body")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "hooks.example.com":
+			return []net.IP{net.ParseIP("93.184.216.34")}, nil
+		case "internal.example.com":
+			return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("10.0.12.7")}, nil
+		case "localhost":
+			return []net.IP{net.ParseIP("127.0.0.1")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	defer func() { lookupIP = net.LookupIP }()
+
+	var testCases = []struct {
+		hook Webhook
+		ok   bool
+	}{
+		{Webhook{URL: "https://hooks.example.com/acme"}, true},
+		{Webhook{URL: "https://hooks.example.com/acme", Events: []string{"page.created", "site.published"}}, true},
+		{Webhook{URL: "http://hooks.example.com/acme"}, false},
+		{Webhook{URL: "/acme"}, false},
+		{Webhook{URL: ""}, false},
+		{Webhook{URL: "https://hooks.example.com/acme", Events: []string{"page.published"}}, false},
+		{Webhook{URL: "https://internal.example.com/acme"}, false},
+		{Webhook{URL: "https://localhost:8443/acme"}, false},
+		{Webhook{URL: "https://unknown.example.com/acme"}, false},
+		{Webhook{URL: "https://127.0.0.1/acme"}, false},
+		{Webhook{URL: "https://169.254.169.254/latest/meta-data"}, false},
+		{Webhook{URL: "https://192.168.1.20/acme"}, false},
+		{Webhook{URL: "https://[::1]/acme"}, false},
+		{Webhook{URL: "https://[fd00::1]/acme"}, false},
+		{Webhook{URL: "https://[::ffff:10.0.0.1]/acme"}, false},
+		{Webhook{URL: "https://93.184.216.34/acme"}, true},
+	}
+
+	for _, tc := range testCases {
+		if detail := Validate(&tc.hook); (detail == "") != tc.ok {
+			t.Errorf("Validate %+v: got %q; wanted ok %v", tc.hook, detail, tc.ok)
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(time.Second).Get(server.URL); err == nil {
+		t.Errorf("NewClient: got a response from a loopback address; wanted the connection refused")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		statuses  []int
+		attempts  int
+		delivered bool
+	}{
+		{"accepted", []int{http.StatusNoContent}, 1, true},
+		{"retried", []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK}, 3, true},
+		{"rejected", []int{http.StatusGone}, 1, false},
+		{"exhausted", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK}, 4, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+				if !Verify("secret", timestamp, body, r.Header.Get(SignatureHeader)) {
+					t.Errorf("Deliver: sent a bad signature %q", r.Header.Get(SignatureHeader))
+				}
+				if r.Header.Get(IDHeader) != "delivery" || r.Header.Get(EventHeader) != Ping {
+					t.Errorf("Deliver: got headers %v", r.Header)
+				}
+				w.WriteHeader(tc.statuses[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			var waits []time.Duration
+			sender := &Sender{Client: server.Client(), Attempts: 4, Backoff: time.Second}
+			sender.sleep = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+
+			hook := &Webhook{ID: "hook", URL: server.URL, Secret: "secret"}
+			delivery, err := sender.Deliver(context.Background(), hook, Payload{ID: "delivery", Event: Ping, Site: "acme"})
+			if err != nil {
+				t.Fatalf("Deliver: %v", err)
+			}
+
+			if len(delivery.Attempts) != tc.attempts || delivery.Delivered != tc.delivered {
+				t.Errorf("Deliver: got %d attempts & delivered %v; wanted %d & %v", len(delivery.Attempts), delivery.Delivered, tc.attempts, tc.delivered)
+			}
+			for i, wait := range waits {
+				if want := time.Second << uint(i); wait != want {
+					t.Errorf("Deliver: waited %v before retry %d; wanted %v", wait, i+1, want)
+				}
+			}
+			if last := delivery.Attempts[len(delivery.Attempts)-1]; last.Status != tc.statuses[tc.attempts-1] {
+				t.Errorf("Deliver: got last status %d; wanted %d", last.Status, tc.statuses[tc.attempts-1])
+			}
+		})
+	}
+}
+
+// store is a Store of webhooks in memory
+type store struct {
+	hooks      []Webhook
+	deliveries []*Delivery
+}
+
+func (s *store) Webhooks(site string) ([]Webhook, error) {
+	return s.hooks, nil
+}
+
+func (s *store) Webhook(site, id string) (*Webhook, error) {
+	for i := range s.hooks {
+		if s.hooks[i].ID == id {
+			return &s.hooks[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *store) SaveDelivery(delivery *Delivery) error {
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+// queue is a Queue in memory, which encodes messages like SQS does
+type queue struct {
+	bodies [][]byte
+}
+
+func (q *queue) Enqueue(ctx context.Context, message Message) error {
+	body, err := json.Marshal(message)
+	q.bodies = append(q.bodies, body)
+	return err
+}
+
+func TestNotify(t *testing.T) {
+	var payloads []Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+	}))
+	defer server.Close()
+
+	s := &store{hooks: []Webhook{
+		{ID: "all", URL: server.URL},
+		{ID: "published", URL: server.URL, Events: []string{"site.published"}},
+		{ID: "pages", URL: server.URL, Events: []string{"page.created", "page.updated"}},
+	}}
+	q := &queue{}
+	notifier := &Notifier{Store: s, Queue: q}
+
+	name := "Rockets"
+	change := changes.Change{EventID: "record", Kind: changes.Updated, Type: "page", Site: "acme", ID: "rockets", Path: "acme/rockets", NewPage: &changes.Page{ID: "rockets", Name: &name}}
+	// a retried stream record queues the same payloads again
+	for i := 0; i < 2; i++ {
+		if err := notifier.Notify(context.Background(), change); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+	if len(q.bodies) != 4 || string(q.bodies[0]) != string(q.bodies[2]) || string(q.bodies[1]) != string(q.bodies[3]) {
+		t.Fatalf("Notify: got messages %q; wanted the same 2 twice", q.bodies)
+	}
+
+	// the webhook is deleted before its message is delivered
+	s.hooks = s.hooks[:2]
+	worker := &Worker{Store: s, Sender: &Sender{Client: server.Client(), Attempts: 1}}
+	for _, body := range q.bodies[:2] {
+		var message Message
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if err := worker.Deliver(context.Background(), message); err != nil {
+			t.Fatalf("Deliver: %v", err)
+		}
+	}
+
+	if len(s.deliveries) != 1 || s.deliveries[0].WebhookID != "all" || s.deliveries[0].ID != "record" {
+		t.Fatalf("Deliver: got deliveries %+v; wanted record to all", s.deliveries)
+	}
+	for _, payload := range payloads {
+		if payload.ID != "record" || payload.Event != "page.updated" || payload.Change == nil || payload.Change.Path != "acme/rockets" {
+			t.Errorf("Deliver: got payload %+v", payload)
+		}
+		if page, ok := payload.Object.(map[string]interface{}); !ok || page["name"] != "Rockets" {
+			t.Errorf("Deliver: got object %#v; wanted the page", payload.Object)
+		}
+	}
+}